@@ -0,0 +1,10 @@
+#!/bin/sh
+# Compares the heap allocations made by source/escape-bench with
+# and without escape analysis. Run from the repo root.
+set -e
+
+for escape in false true; do
+	go run . build -escape=$escape source/escape-bench
+	allocations=$(GC_STATS=1 ./out 2>&1 > /dev/null | grep allocations)
+	echo "escape=$escape $allocations"
+done
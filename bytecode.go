@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/alexmarchant/compiler/vm"
+)
+
+// loadBytecode compiles a source file to bytecode, or reads a
+// .bc file written by the bytecode command
+func loadBytecode(path string) (*vm.Program, error) {
+	if strings.HasSuffix(path, ".bc") {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		program, err := vm.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: error: %s", path, err)
+		}
+		return program, nil
+	}
+
+	prog, err := compileChecked(path)
+	if err != nil {
+		return nil, err
+	}
+	var program *vm.Program
+	err = catch(func() {
+		program = vm.Compile(prog.nodes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: error: %s", path, err)
+	}
+	return program, nil
+}
+
+// runBytecode runs a program in the VM. Like the C runtime,
+// GC_STATS=1 prints heap statistics at exit.
+func runBytecode(path string) int {
+	program, err := loadBytecode(path)
+	if err != nil {
+		return report(err)
+	}
+
+	machine := vm.New(program)
+	code := 0
+	err = catch(func() {
+		code = machine.Run()
+	})
+	if stats := os.Getenv("GC_STATS"); stats != "" && stats != "0" {
+		fmt.Fprintln(os.Stderr, machine.Stats())
+	}
+	if err != nil {
+		return report(fmt.Errorf("%s: error: %s", path, err))
+	}
+	return code
+}
+
+func bytecode(args []string) int {
+	flags := flag.NewFlagSet("bytecode", flag.ExitOnError)
+	output := flags.String("o", "out.bc", "path of the .bc file")
+	path, _ := fileArg(flags, args)
+
+	program, err := loadBytecode(path)
+	if err != nil {
+		return report(err)
+	}
+	if err := ioutil.WriteFile(*output, vm.Encode(program), 0644); err != nil {
+		return report(err)
+	}
+	return 0
+}
+
+func disasm(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	path, _ := fileArg(flags, args)
+
+	program, err := loadBytecode(path)
+	if err != nil {
+		return report(err)
+	}
+	fmt.Print(vm.Disassemble(program))
+	return 0
+}
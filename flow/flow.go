@@ -0,0 +1,105 @@
+// Package flow checks the paths through the bodies of a program's
+// fns and methods. A fn returning a value must return on every path
+// through its body, it's an error for one to run off the end. Code
+// after a return and values computed but never used are warned about.
+package flow
+
+import (
+	"fmt"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Program checks a program's fns and methods, returning its warnings
+func Program(nodes []parser.Node) []string {
+	warnings := []string{}
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+		case *parser.Function:
+			warnings = append(warnings, Function(n.Prototype.Name, n)...)
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				name := fmt.Sprintf("%s.%s", n.Name, method.Prototype.Name)
+				warnings = append(warnings, Function(name, method)...)
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+	return warnings
+}
+
+// Function checks a fn or method, name is how it's reported
+func Function(name string, function *parser.Function) []string {
+	c := &checker{name: name}
+	returns := c.block(function.Expressions)
+	if !returns && function.Prototype.ReturnType != "void" {
+		msg := fmt.Sprintf("Missing return: %s can reach the end of its body", name)
+		panic(msg)
+	}
+	return c.warnings
+}
+
+type checker struct {
+	name     string
+	warnings []string
+}
+
+func (c *checker) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// block checks a list of statements, reporting whether every path
+// through them returns
+func (c *checker) block(expressions []parser.Expression) bool {
+	for i, expression := range expressions {
+		if c.statement(expression) {
+			if i < len(expressions)-1 {
+				c.warn("unreachable code after return in %s", c.name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// statement checks a statement, reporting whether it always returns.
+// An if returns when both of its branches do.
+func (c *checker) statement(expression parser.Expression) bool {
+	switch exp := expression.(type) {
+	case *parser.ReturnExpression:
+		return true
+	case *parser.IfExpression:
+		then := c.block(exp.Then)
+		otherwise := c.block(exp.Else)
+		return then && otherwise
+	default:
+		if isUnused(exp) {
+			c.warn("unused value of expression statement in %s", c.name)
+		}
+		return false
+	}
+}
+
+// isUnused reports whether a statement only computes a value, which
+// is thrown away. Calls are run for their effects, as are assignments.
+func isUnused(expression parser.Expression) bool {
+	switch exp := expression.(type) {
+	case *parser.IntExpression,
+		*parser.StringExpression,
+		*parser.InterpolatedStringExpression,
+		*parser.BoolExpression,
+		*parser.ArrayExpression,
+		*parser.BinaryExpression,
+		*parser.VariableExpression:
+		return true
+	case *parser.ParenExpression:
+		return isUnused(exp.Expression)
+	case *parser.AccessorExpression:
+		_, isField := exp.Member().(*parser.VariableExpression)
+		return isField
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package flow
+
+import (
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// TailCalls finds the calls the @tailrec fns and methods of a program
+// make that can jump to their callee instead of calling it, for the
+// backends running the AST. Like ir.EliminateTailCalls these are the
+// calls in tail position to fns, or for methods to methods on self,
+// that take the same params and return the same type. A call is in
+// tail position when its result is returned straight away or, in fns
+// returning Void, when it ends the fn. ir.CheckAttributes makes sure
+// every recursive call a @tailrec fn makes is one of them.
+func TailCalls(nodes []parser.Node) map[*parser.CallExpression]bool {
+	functions := map[string]*parser.Function{}
+	methods := map[string]map[string]*parser.Function{}
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Function:
+			functions[n.Prototype.Name] = n
+		case *parser.Struct:
+			methods[n.Name] = map[string]*parser.Function{}
+			for _, method := range n.Functions {
+				methods[n.Name][method.Prototype.Name] = method
+			}
+		}
+	}
+
+	calls := map[*parser.CallExpression]bool{}
+	add := func(caller *parser.Function, callees map[string]*parser.Function, self bool) {
+		if !caller.HasAttribute("tailrec") {
+			return
+		}
+		void := caller.Prototype.ReturnType == "void"
+		for _, expression := range tailExpressions(caller.Expressions, void) {
+			call := tailCall(expression, self)
+			if call == nil {
+				continue
+			}
+			callee := callees[call.Callee]
+			if callee != nil && samePrototype(caller.Prototype, callee.Prototype) {
+				calls[call] = true
+			}
+		}
+	}
+	for _, function := range functions {
+		add(function, functions, false)
+	}
+	for _, str := range methods {
+		for _, method := range str {
+			add(method, str, true)
+		}
+	}
+	return calls
+}
+
+// tailExpressions are a block's expressions in tail position, those
+// it returns and, when tail is set, the one ending it
+func tailExpressions(expressions []parser.Expression, tail bool) []parser.Expression {
+	tails := []parser.Expression{}
+	for i, expression := range expressions {
+		last := tail && i == len(expressions)-1
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			tails = append(tails, exp.Expression)
+		case *parser.IfExpression:
+			tails = append(tails, tailExpressions(exp.Then, last)...)
+			tails = append(tails, tailExpressions(exp.Else, last)...)
+		default:
+			if last {
+				tails = append(tails, expression)
+			}
+		}
+	}
+	return tails
+}
+
+// tailCall is the call an expression in tail position makes, a fn's
+// or, in methods, one on self
+func tailCall(expression parser.Expression, self bool) *parser.CallExpression {
+	switch exp := expression.(type) {
+	case *parser.ParenExpression:
+		return tailCall(exp.Expression, self)
+	case *parser.CallExpression:
+		if !self {
+			return exp
+		}
+	case *parser.AccessorExpression:
+		if call, ok := exp.Expression.(*parser.CallExpression); ok && self && exp.Target == "self" {
+			return call
+		}
+	}
+	return nil
+}
+
+func samePrototype(a *parser.Prototype, b *parser.Prototype) bool {
+	if a.ReturnType != b.ReturnType || len(a.Props) != len(b.Props) {
+		return false
+	}
+	for i, prop := range a.Props {
+		if b.Props[i].Type != prop.Type {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+// Package fold evaluates the expressions of a program whose operands
+// are literals at compile time, e.g. return 0 + 1 becomes return 1,
+// and replaces the uses of const variables with their values. Errors
+// the evaluation would hit at run time, dividing by zero or
+// overflowing an Int, are reported like the rest of the compiler's.
+package fold
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Consts maps the const variables in scope to the literals
+// they were initialised with
+type Consts map[string]parser.Expression
+
+// Program folds a program's globals, in the order they're
+// initialised, then the bodies of its fns and methods in place.
+// Global consts are replaced in the bodies unless a param hides them.
+func Program(nodes []parser.Node) {
+	globals := Consts{}
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			Expression(global.Declaration, globals)
+		}
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+		case *parser.Function:
+			n.Expressions = Block(n.Expressions, functionConsts(n, globals))
+		case *parser.Struct:
+			for _, function := range n.Functions {
+				function.Expressions = Block(function.Expressions, functionConsts(function, globals))
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+}
+
+// functionConsts are the global consts a fn's body can use
+func functionConsts(function *parser.Function, globals Consts) Consts {
+	consts := Consts{}
+	for name, value := range globals {
+		consts[name] = value
+	}
+	for _, prop := range function.Prototype.Props {
+		delete(consts, prop.Name)
+	}
+	return consts
+}
+
+// Block folds a list of statements. Variables are function scoped,
+// so the consts declared in an if's body stay in consts after it.
+func Block(expressions []parser.Expression, consts Consts) []parser.Expression {
+	folded := []parser.Expression{}
+	for _, expression := range expressions {
+		folded = append(folded, Expression(expression, consts))
+	}
+	return folded
+}
+
+// Expression folds an expression, returning a literal when all of
+// its operands are literals
+func Expression(expression parser.Expression, consts Consts) parser.Expression {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		if exp.Value > math.MaxInt32 {
+			msg := fmt.Sprintf("Int overflow: %d", exp.Value)
+			panic(msg)
+		}
+		return exp
+	case *parser.StringExpression, *parser.BoolExpression:
+		return exp
+	case *parser.InterpolatedStringExpression:
+		return interpolation(exp, consts)
+	case *parser.ArrayExpression:
+		exp.Elements = Block(exp.Elements, consts)
+		return exp
+	case *parser.ReturnExpression:
+		if exp.Expression != nil {
+			exp.Expression = Expression(exp.Expression, consts)
+		}
+		return exp
+	case *parser.IfExpression:
+		exp.Condition = Expression(exp.Condition, consts)
+		exp.Then = Block(exp.Then, consts)
+		exp.Else = Block(exp.Else, consts)
+		return exp
+	case *parser.BinaryExpression:
+		exp.LHS = Expression(exp.LHS, consts)
+		exp.RHS = Expression(exp.RHS, consts)
+		if folded := binary(exp); folded != nil {
+			return folded
+		}
+		return exp
+	case *parser.ParenExpression:
+		exp.Expression = Expression(exp.Expression, consts)
+		if isLiteral(exp.Expression) {
+			return exp.Expression
+		}
+		return exp
+	case *parser.CallExpression:
+		exp.Params = Block(exp.Params, consts)
+		return exp
+	case *parser.VariableExpression:
+		if value, ok := consts[exp.Name]; ok {
+			return value
+		}
+		return exp
+	case *parser.VariableDeclarationExpression:
+		exp.Expression = Expression(exp.Expression, consts)
+		if !exp.Const {
+			delete(consts, exp.Name)
+			return exp
+		}
+		if !isLiteral(exp.Expression) {
+			msg := fmt.Sprintf("const %s must be initialised by a constant expression", exp.Name)
+			panic(msg)
+		}
+		consts[exp.Name] = exp.Expression
+		return exp
+	case *parser.AssignmentExpression:
+		if _, ok := consts[exp.Target.Name]; ok {
+			msg := fmt.Sprintf("Can't assign to const %s", exp.Target)
+			panic(msg)
+		}
+		exp.Expression = Expression(exp.Expression, consts)
+		return exp
+	case *parser.AccessorExpression:
+		if method, ok := exp.Member().(*parser.CallExpression); ok {
+			method.Params = Block(method.Params, consts)
+		}
+		return exp
+	default:
+		panic("Invalid ExpressionType")
+	}
+}
+
+func isLiteral(expression parser.Expression) bool {
+	switch expression.(type) {
+	case *parser.IntExpression, *parser.BoolExpression, *parser.StringExpression:
+		return true
+	default:
+		return false
+	}
+}
+
+// binary evaluates an operator applied to two literals of the same
+// type, or returns nil. Mismatched operands are left for the type
+// checker to report.
+func binary(exp *parser.BinaryExpression) parser.Expression {
+	switch lhs := exp.LHS.(type) {
+	case *parser.IntExpression:
+		if rhs, ok := exp.RHS.(*parser.IntExpression); ok {
+			return intBinary(exp.Op, int64(lhs.Value), int64(rhs.Value))
+		}
+	case *parser.BoolExpression:
+		if rhs, ok := exp.RHS.(*parser.BoolExpression); ok {
+			switch exp.Op {
+			case parser.BinaryOperatorEqual:
+				return &parser.BoolExpression{Value: lhs.Value == rhs.Value}
+			case parser.BinaryOperatorNotEqual:
+				return &parser.BoolExpression{Value: lhs.Value != rhs.Value}
+			}
+		}
+	case *parser.StringExpression:
+		if rhs, ok := exp.RHS.(*parser.StringExpression); ok {
+			return stringBinary(exp.Op, lhs.Value, rhs.Value)
+		}
+	}
+	return nil
+}
+
+func intBinary(op parser.BinaryOperator, lhs int64, rhs int64) parser.Expression {
+	var result int64
+	symbol := ""
+	switch op {
+	case parser.BinaryOperatorPlus:
+		result, symbol = lhs+rhs, "+"
+	case parser.BinaryOperatorMinus:
+		result, symbol = lhs-rhs, "-"
+	case parser.BinaryOperatorMultiplication:
+		result, symbol = lhs*rhs, "*"
+	case parser.BinaryOperatorDivision:
+		if rhs == 0 {
+			msg := fmt.Sprintf("Division by zero: %d / 0", lhs)
+			panic(msg)
+		}
+		result, symbol = lhs/rhs, "/"
+	case parser.BinaryOperatorModulo:
+		if rhs == 0 {
+			msg := fmt.Sprintf("Division by zero: %d %% 0", lhs)
+			panic(msg)
+		}
+		result, symbol = lhs%rhs, "%"
+	default:
+		return &parser.BoolExpression{Value: compare(op, lhs, rhs)}
+	}
+
+	if result > math.MaxInt32 || result < math.MinInt32 {
+		msg := fmt.Sprintf("Int overflow: %d %s %d", lhs, symbol, rhs)
+		panic(msg)
+	}
+	return &parser.IntExpression{Value: int(result)}
+}
+
+func stringBinary(op parser.BinaryOperator, lhs string, rhs string) parser.Expression {
+	if op == parser.BinaryOperatorPlus {
+		// Both are still escaped as they were written in source
+		return &parser.StringExpression{Value: lhs + rhs}
+	}
+	if !op.IsComparison() {
+		return nil
+	}
+
+	order := int64(0)
+	lhs, rhs = parser.Unescape(lhs), parser.Unescape(rhs)
+	if lhs < rhs {
+		order = -1
+	} else if lhs > rhs {
+		order = 1
+	}
+	return &parser.BoolExpression{Value: compare(op, order, 0)}
+}
+
+func compare(op parser.BinaryOperator, lhs int64, rhs int64) bool {
+	switch op {
+	case parser.BinaryOperatorEqual:
+		return lhs == rhs
+	case parser.BinaryOperatorNotEqual:
+		return lhs != rhs
+	case parser.BinaryOperatorLessThan:
+		return lhs < rhs
+	case parser.BinaryOperatorGreaterThan:
+		return lhs > rhs
+	case parser.BinaryOperatorLessThanOrEqual:
+		return lhs <= rhs
+	case parser.BinaryOperatorGreaterThanOrEqual:
+		return lhs >= rhs
+	default:
+		panic("Invalid BinaryOperator")
+	}
+}
+
+// interpolation folds the parts of an interpolated string, joining
+// it into one string when every part is a literal
+func interpolation(exp *parser.InterpolatedStringExpression, consts Consts) parser.Expression {
+	exp.Parts = Block(exp.Parts, consts)
+
+	value := ""
+	for _, part := range exp.Parts {
+		switch literal := part.(type) {
+		case *parser.StringExpression:
+			value += literal.Value
+		case *parser.IntExpression:
+			value += strconv.Itoa(literal.Value)
+		case *parser.BoolExpression:
+			value += strconv.FormatBool(literal.Value)
+		default:
+			return exp
+		}
+	}
+	return &parser.StringExpression{Value: value}
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/alexmarchant/compiler/ir"
+)
+
+// Generated code in MemoryModeARC owns the objects held by its
+// variables and props, see runtime/arc.h. Params are retained
+// on entry so they can be reassigned like any other local.
+
+func generateARCFrameEnter(function *ir.Function) string {
+	code := "\tARCFrame __arc_frame;\n"
+	code += "\tarc_enter(&__arc_frame);\n"
+	for _, param := range managedParams(function) {
+		code += fmt.Sprintf("\tarc_retain(%s);\n", cName(param))
+	}
+	return code
+}
+
+func generateARCReleaseLocals(indent string) string {
+	code := ""
+	for _, local := range currentLocals {
+		code += fmt.Sprintf("%sarc_release(%s);\n", indent, local)
+	}
+	return code
+}
+
+func generateARCFrameLeave(indent string) string {
+	code := generateARCReleaseLocals(indent)
+	code += fmt.Sprintf("%sarc_leave(&__arc_frame);\n", indent)
+	return code
+}
+
+// generateARCReturn keeps the returned object alive while the
+// locals are released, then autoreleases it into the caller's frame
+func generateARCReturn(value string, indent string) string {
+	if !currentFunction.ReturnType.IsRef() {
+		return generateARCFrameLeave(indent) + fmt.Sprintf("%sreturn %s;\n", indent, value)
+	}
+	code := fmt.Sprintf("%sarc_retain(%s);\n", indent, value)
+	code += generateARCFrameLeave(indent)
+	return code + fmt.Sprintf("%sreturn arc_autorelease(%s);\n", indent, value)
+}
+
+func generateARCStore(target string, value string, weak bool) string {
+	if weak {
+		return fmt.Sprintf("arc_store_weak((void**)&%s, %s)", target, value)
+	}
+	return fmt.Sprintf("arc_store((void**)&%s, %s)", target, value)
+}
+
+// generateARCFinalize clears a struct's weak props, so the
+// objects they point to stop tracking them
+func generateARCFinalize(str *ir.Struct, weakProps []string) string {
+	code := fmt.Sprintf("void %s__finalize(void* object) {\n", str.Name)
+	code += fmt.Sprintf("\t%s* self = object;\n", str.Name)
+	for _, prop := range weakProps {
+		code += fmt.Sprintf("\tarc_store_weak((void**)&self->%s, NULL);\n", prop)
+	}
+	code += "}\n\n"
+	return code
+}
+
+// Objects held by globals are released before leaks are reported at
+// exit, so ARC_DEBUG=1 only reports the objects nothing can reach
+
+func generateARCGlobalRoots(roots []string) string {
+	if len(roots) == 0 {
+		return ""
+	}
+	code := "static void __arc_release_globals() {\n"
+	for _, root := range roots {
+		code += fmt.Sprintf("\tarc_store((void**)&%s, NULL);\n", root)
+	}
+	code += "}\n"
+	return code
+}
+
+func generateARCGlobalsEnter(roots []string) string {
+	if len(roots) == 0 {
+		return ""
+	}
+	return "\tarc_globals(__arc_release_globals);\n"
+}
@@ -0,0 +1,778 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/ir"
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// The asm backend emits x86-64 System V assembly in GNU as syntax.
+// Expressions leave their value in %rax, intermediate values are
+// pushed on the machine stack, every local gets an 8 byte slot
+// below %rbp and every global a quad in .data. Ints are C ints, so
+// arithmetic is done on the low 32 bits and sign extended. Programs
+// link against the same runtime as the C backend and keep the
+// collector informed with the same GCFrame calls, see runtime/gc.h.
+
+var argRegisters = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+// asmStrings are the string literals of the program, emitted
+// to .rodata with the labels .LS0, .LS1, ...
+var asmStrings []string
+var asmLabels int
+
+// asmGlobals are the types of the globals, kept in .data as
+// __global_name, and asmGlobalRoots those holding objects
+var asmGlobals map[string]string
+var asmGlobalRoots []string
+
+// asmTailCalls jump to their callee, see flow.TailCalls
+var asmTailCalls map[*parser.CallExpression]bool
+
+// gcFrameSize is sizeof(GCFrame) in runtime/gc.h
+const gcFrameSize = 32
+
+// asmFunction is the state of the function being generated
+type asmFunction struct {
+	code       string
+	returnType string
+	isMain     bool
+	variables  map[string]string
+	slots      map[string]int
+	roots      []string
+	retSlot    int
+	rootsSlot  int
+	frameSlot  int
+	frameSize  int
+	// depth counts the 8 byte values pushed since the prologue,
+	// calls pad the stack to keep it 16 byte aligned
+	depth int
+}
+
+// GenerateAsm ...
+func GenerateAsm(nodes []parser.Node, config *BuildConfig) string {
+	if config.Memory != MemoryModeGC {
+		panic("The asm backend only supports -memory=gc")
+	}
+
+	registerTypes(nodes)
+	asmStrings = []string{}
+	asmLabels = 0
+	asmTailCalls = flow.TailCalls(nodes)
+
+	// Globals are declared first so every function can use them
+	text := ""
+	data := ""
+	globals := []*parser.Global{}
+	asmGlobals = map[string]string{}
+	asmGlobalRoots = []string{}
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			name := global.Declaration.Name
+			globals = append(globals, global)
+			asmGlobals[name] = cType(global.Declaration.Type)
+			if isPointerType(asmGlobals[name]) {
+				asmGlobalRoots = append(asmGlobalRoots, name)
+			}
+			data += fmt.Sprintf("\t.p2align 3\n%s:\n\t.quad 0\n\n", cGlobalName(name))
+		}
+	}
+	if len(globals) > 0 {
+		text += generateAsmFunction(globalsInitFunction(globals))
+	}
+	if len(asmGlobalRoots) > 0 {
+		// The GCFrame main enters for them, see generateGCGlobalsEnter
+		data += "\t.p2align 3\n"
+		data += fmt.Sprintf("__gc_global_roots:\n\t.zero %d\n", 8*len(asmGlobalRoots))
+		data += fmt.Sprintf("__gc_globals:\n\t.zero %d\n\n", gcFrameSize)
+	}
+
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			text += generateAsmFunction(function)
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			text += generateAsmStruct(str)
+			data += generateAsmStructType(str)
+			for _, function := range str.Functions {
+				text += generateAsmFunction(methodFunction(str, function))
+			}
+		case parser.NodeTypeGlobal:
+			// Declared above
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+
+	code := "\t.text\n\n" + text
+	code += "\t.data\n\n" + data
+	// atexit in libc needs __dso_handle, which the C compiler's
+	// crtbegin.o would provide
+	code += "\t.hidden __dso_handle\n"
+	code += "\t.weak __dso_handle\n"
+	code += "__dso_handle:\n\t.quad __dso_handle\n\n"
+	code += "\t.section .rodata\n"
+	for i, value := range asmStrings {
+		code += fmt.Sprintf(".LS%d:\n\t.asciz \"%s\"\n", i, value)
+	}
+	code += "\n\t.section .note.GNU-stack,\"\",@progbits\n"
+	return code
+}
+
+func asmString(value string) string {
+	for i, existing := range asmStrings {
+		if existing == value {
+			return fmt.Sprintf(".LS%d", i)
+		}
+	}
+	asmStrings = append(asmStrings, value)
+	return fmt.Sprintf(".LS%d", len(asmStrings)-1)
+}
+
+func asmLabel() string {
+	asmLabels++
+	return fmt.Sprintf(".L%d", asmLabels)
+}
+
+// asmPropOffset is the offset of a prop in a struct, every
+// prop takes 8 bytes
+func asmPropOffset(structName string, prop string) int {
+	str := structTypes[typeName(structName)]
+	for i, p := range str.Props {
+		if p.Name == prop {
+			return i * 8
+		}
+	}
+	msg := fmt.Sprintf("Unknown prop: %s.%s", structName, prop)
+	panic(msg)
+}
+
+func generateAsmStruct(str *parser.Struct) string {
+	code := fmt.Sprintf("\t.globl %s__make\n", str.Name)
+	code += fmt.Sprintf("%s__make:\n", str.Name)
+	code += "\tpushq %rbp\n"
+	code += "\tmovq %rsp, %rbp\n"
+	code += fmt.Sprintf("\tmovq $%d, %%rdi\n", len(str.Props)*8)
+	code += fmt.Sprintf("\tleaq %s__type(%%rip), %%rsi\n", str.Name)
+	code += "\tcall gc_alloc\n"
+	code += "\tpopq %rbp\n"
+	code += "\tret\n\n"
+
+	pointerProps := []string{}
+	for _, prop := range str.Props {
+		if isPointerType(cType(prop.Type)) {
+			pointerProps = append(pointerProps, prop.Name)
+		}
+	}
+	if len(pointerProps) == 0 {
+		return code
+	}
+
+	// T__trace(object, visit) calls visit on each object prop
+	code += fmt.Sprintf("%s__trace:\n", str.Name)
+	code += "\tpushq %rbp\n"
+	code += "\tmovq %rsp, %rbp\n"
+	code += "\tpushq %rbx\n"
+	code += "\tpushq %r12\n"
+	code += "\tmovq %rdi, %rbx\n"
+	code += "\tmovq %rsi, %r12\n"
+	for _, prop := range pointerProps {
+		code += fmt.Sprintf("\tmovq %d(%%rbx), %%rdi\n", asmPropOffset(str.Name, prop))
+		code += "\tcall *%r12\n"
+	}
+	code += "\tpopq %r12\n"
+	code += "\tpopq %rbx\n"
+	code += "\tpopq %rbp\n"
+	code += "\tret\n\n"
+	return code
+}
+
+// generateAsmStructType emits the struct's ObjectType, see runtime/object.h
+func generateAsmStructType(str *parser.Struct) string {
+	trace := "0"
+	for _, prop := range str.Props {
+		if isPointerType(cType(prop.Type)) {
+			trace = fmt.Sprintf("%s__trace", str.Name)
+		}
+	}
+
+	code := fmt.Sprintf("\t.globl %s__type\n", str.Name)
+	code += "\t.p2align 3\n"
+	code += fmt.Sprintf("%s__type:\n", str.Name)
+	code += fmt.Sprintf("\t.quad %s\n", asmString(str.Name))
+	code += fmt.Sprintf("\t.quad %s\n", trace)
+	code += "\t.quad 0\n\n"
+	return code
+}
+
+func generateAsmFunction(function *parser.Function) string {
+	a := &asmFunction{
+		returnType: cType(function.Prototype.ReturnType),
+		isMain:     function.Prototype.Name == "main",
+		variables:  map[string]string{},
+		slots:      map[string]int{},
+	}
+
+	// Every param and local gets a slot
+	names := []string{}
+	for _, prop := range function.Prototype.Props {
+		a.variables[prop.Name] = cType(prop.Type)
+		names = append(names, prop.Name)
+	}
+	declarations := []*parser.VariableDeclarationExpression{}
+	asmDeclarations(function.Expressions, &declarations)
+	for _, exp := range declarations {
+		if _, ok := a.variables[exp.Name]; ok {
+			continue
+		}
+		a.variables[exp.Name] = cType(exp.Type)
+		names = append(names, exp.Name)
+	}
+
+	for name, globalType := range asmGlobals {
+		if _, ok := a.variables[name]; !ok {
+			a.variables[name] = globalType
+		}
+	}
+
+	offset := 0
+	for _, name := range names {
+		offset += 8
+		a.slots[name] = -offset
+		if name != "self" && isPointerType(a.variables[name]) {
+			a.roots = append(a.roots, name)
+		}
+	}
+	offset += 8
+	a.retSlot = -offset
+	offset += 8 * len(a.roots)
+	a.rootsSlot = -offset
+	offset += gcFrameSize
+	a.frameSlot = -offset
+	a.frameSize = (offset + 15) / 16 * 16
+
+	name := function.Prototype.Name
+	a.emit(".globl %s", name)
+	a.code += name + ":\n"
+	a.emit("pushq %%rbp")
+	a.emit("movq %%rsp, %%rbp")
+	a.emit("subq $%d, %%rsp", a.frameSize)
+	for _, name := range names {
+		a.emit("movq $0, %d(%%rbp)", a.slots[name])
+	}
+	for i, prop := range function.Prototype.Props {
+		if i < len(argRegisters) {
+			a.emit("movq %s, %d(%%rbp)", argRegisters[i], a.slots[prop.Name])
+		} else {
+			a.emit("movq %d(%%rbp), %%rax", 16+8*(i-len(argRegisters)))
+			a.emit("movq %%rax, %d(%%rbp)", a.slots[prop.Name])
+		}
+	}
+
+	if a.isMain && len(asmGlobalRoots) > 0 {
+		a.emit("leaq __gc_globals(%%rip), %%rdi")
+		a.emit("leaq __gc_global_roots(%%rip), %%rsi")
+		a.emit("movq $%d, %%rdx", len(asmGlobalRoots))
+		a.emit("call gc_enter")
+		for _, root := range asmGlobalRoots {
+			a.emit("leaq __gc_globals(%%rip), %%rdi")
+			a.emit("leaq %s(%%rip), %%rsi", cGlobalName(root))
+			a.emit("call gc_root")
+		}
+	}
+
+	// gc_enter(&frame, roots, count) then gc_root(&frame, &slot)
+	a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+	if len(a.roots) > 0 {
+		a.emit("leaq %d(%%rbp), %%rsi", a.rootsSlot)
+	} else {
+		a.emit("movq $0, %%rsi")
+	}
+	a.emit("movq $%d, %%rdx", len(a.roots))
+	a.emit("call gc_enter")
+	for _, root := range a.roots {
+		a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+		a.emit("leaq %d(%%rbp), %%rsi", a.slots[root])
+		a.emit("call gc_root")
+	}
+	if a.isMain && len(asmGlobals) > 0 {
+		a.emit("call %s", ir.GlobalsInit)
+	}
+
+	a.block(function.Expressions)
+	if !endsWithReturn(function.Expressions) {
+		a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+		a.emit("call gc_leave")
+		a.emit("movq $0, %%rax")
+		a.emit("leave")
+		a.emit("ret")
+	}
+
+	return a.code + "\n"
+}
+
+// asmDeclarations finds every declaration in a function body
+func asmDeclarations(expressions []parser.Expression, declarations *[]*parser.VariableDeclarationExpression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.VariableDeclarationExpression:
+			*declarations = append(*declarations, exp)
+		case *parser.IfExpression:
+			asmDeclarations(exp.Then, declarations)
+			asmDeclarations(exp.Else, declarations)
+		}
+	}
+}
+
+func (a *asmFunction) emit(format string, args ...interface{}) {
+	a.code += "\t" + fmt.Sprintf(format, args...) + "\n"
+}
+
+func (a *asmFunction) push(register string) {
+	a.emit("pushq %s", register)
+	a.depth++
+}
+
+func (a *asmFunction) pop(register string) {
+	a.emit("popq %s", register)
+	a.depth--
+}
+
+func (a *asmFunction) block(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			a.ret(exp)
+		case *parser.IfExpression:
+			a.ifExpression(exp)
+		default:
+			a.expression(expression)
+			a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+			a.emit("call gc_end_statement")
+		}
+	}
+}
+
+// ret leaves the frame, handing objects to the caller's frame
+func (a *asmFunction) ret(exp *parser.ReturnExpression) {
+	a.expression(exp.Expression)
+	a.emit("movq %%rax, %d(%%rbp)", a.retSlot)
+	a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+	a.emit("call gc_leave")
+	a.emit("movq %d(%%rbp), %%rax", a.retSlot)
+	if isPointerType(a.returnType) {
+		a.emit("movq %%rax, %%rdi")
+		a.emit("call gc_keep")
+	}
+	a.emit("leave")
+	a.emit("ret")
+}
+
+func (a *asmFunction) ifExpression(exp *parser.IfExpression) {
+	elseLabel := asmLabel()
+	endLabel := asmLabel()
+
+	a.expression(exp.Condition)
+	a.emit("testq %%rax, %%rax")
+	a.emit("je %s", elseLabel)
+	a.block(exp.Then)
+	a.emit("jmp %s", endLabel)
+	a.code += elseLabel + ":\n"
+	a.block(exp.Else)
+	a.code += endLabel + ":\n"
+}
+
+// call evaluates args left to right onto the stack, pops the
+// first six into registers and leaves the rest for the callee
+func (a *asmFunction) call(name string, args []func(), returnType string) {
+	extra := 0
+	if len(args) > len(argRegisters) {
+		extra = len(args) - len(argRegisters)
+	}
+	pad := 0
+	if (a.depth+extra)%2 == 1 {
+		pad = 1
+		a.emit("subq $8, %%rsp")
+		a.depth++
+	}
+
+	// Args are evaluated left to right into slots laid out like the
+	// stack args, the first are then popped into registers
+	if len(args) > 0 {
+		a.emit("subq $%d, %%rsp", 8*len(args))
+		a.depth += len(args)
+	}
+	for i, arg := range args {
+		arg()
+		a.emit("movq %%rax, %d(%%rsp)", 8*i)
+	}
+	for i := 0; i < len(args) && i < len(argRegisters); i++ {
+		a.pop(argRegisters[i])
+	}
+
+	// Variadic functions read the number of vector args from %al
+	a.emit("movl $0, %%eax")
+	a.emit("call %s", name)
+	if extra+pad > 0 {
+		a.emit("addq $%d, %%rsp", 8*(extra+pad))
+		a.depth -= extra + pad
+	}
+
+	switch returnType {
+	case "int":
+		a.emit("movslq %%eax, %%rax")
+	case "bool":
+		a.emit("movzbl %%al, %%eax")
+	}
+}
+
+// tailCall jumps to a function taking the same params instead of
+// calling it. The args are evaluated like call's, then the frame is
+// left and they're passed in the registers and stack args this
+// function was called with. Nothing is allocated between leaving
+// the frame and the callee rooting its params.
+func (a *asmFunction) tailCall(name string, args []func()) {
+	depth := a.depth
+	if len(args) > 0 {
+		a.emit("subq $%d, %%rsp", 8*len(args))
+		a.depth += len(args)
+	}
+	for i, arg := range args {
+		arg()
+		a.emit("movq %%rax, %d(%%rsp)", 8*i)
+	}
+	a.emit("leaq %d(%%rbp), %%rdi", a.frameSlot)
+	a.callRegisters("gc_leave")
+	for i := range args {
+		if i < len(argRegisters) {
+			a.emit("movq %d(%%rsp), %s", 8*i, argRegisters[i])
+		} else {
+			a.emit("movq %d(%%rsp), %%rax", 8*i)
+			a.emit("movq %%rax, %d(%%rbp)", 16+8*(i-len(argRegisters)))
+		}
+	}
+	a.emit("leave")
+	a.emit("jmp %s", name)
+	// What's emitted after the jump is never reached
+	a.depth = depth
+}
+
+func (a *asmFunction) arg(expression parser.Expression) func() {
+	return func() {
+		a.expression(expression)
+	}
+}
+
+func (a *asmFunction) args(expressions []parser.Expression) []func() {
+	args := []func(){}
+	for _, expression := range expressions {
+		args = append(args, a.arg(expression))
+	}
+	return args
+}
+
+func (a *asmFunction) expression(expression parser.Expression) {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		a.emit("movq $%d, %%rax", exp.Value)
+	case *parser.BoolExpression:
+		if exp.Value {
+			a.emit("movq $1, %%rax")
+		} else {
+			a.emit("movq $0, %%rax")
+		}
+	case *parser.StringExpression:
+		label := asmString(exp.Value)
+		a.call("String__make", []func(){func() {
+			a.emit("leaq %s(%%rip), %%rax", label)
+		}}, "String*")
+	case *parser.InterpolatedStringExpression:
+		a.toString(exp.Parts[0])
+		for _, part := range exp.Parts[1:] {
+			part := part
+			a.push("%rax")
+			a.toString(part)
+			a.emit("movq %%rax, %%rsi")
+			a.pop("%rdi")
+			a.callRegisters("String__concat")
+		}
+	case *parser.BinaryExpression:
+		a.binary(exp)
+	case *parser.ParenExpression:
+		a.expression(exp.Expression)
+	case *parser.CallExpression:
+		a.callExpression(exp)
+	case *parser.VariableExpression:
+		a.load(exp.Name)
+	case *parser.VariableDeclarationExpression:
+		a.expression(exp.Expression)
+		a.emit("movq %%rax, %d(%%rbp)", a.slot(exp.Name))
+	case *parser.AssignmentExpression:
+		a.assign(exp)
+	case *parser.AccessorExpression:
+		a.accessor(exp)
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
+
+// load reads a local or, when there's no local with the name, a
+// global. The objects read from globals are kept alive until the end
+// of the statement, like the C backend's generateLoadGlobal.
+func (a *asmFunction) load(name string) {
+	if _, ok := a.slots[name]; ok || asmGlobals[name] == "" {
+		a.emit("movq %d(%%rbp), %%rax", a.slot(name))
+		return
+	}
+	a.emit("movq %s(%%rip), %%rax", cGlobalName(name))
+	if isPointerType(asmGlobals[name]) {
+		a.emit("movq %%rax, %%rdi")
+		a.callRegisters("gc_keep")
+	}
+}
+
+// store sets a local or global to %rax
+func (a *asmFunction) store(name string) {
+	if _, ok := a.slots[name]; ok || asmGlobals[name] == "" {
+		a.emit("movq %%rax, %d(%%rbp)", a.slot(name))
+		return
+	}
+	a.emit("movq %%rax, %s(%%rip)", cGlobalName(name))
+}
+
+func (a *asmFunction) slot(name string) int {
+	slot, ok := a.slots[name]
+	if !ok {
+		msg := fmt.Sprintf("Undeclared variable: %s", name)
+		panic(msg)
+	}
+	return slot
+}
+
+// callRegisters calls a two arg function whose args are
+// already in %rdi and %rsi
+func (a *asmFunction) callRegisters(name string) {
+	pad := a.depth%2 == 1
+	if pad {
+		a.emit("subq $8, %%rsp")
+	}
+	a.emit("call %s", name)
+	if pad {
+		a.emit("addq $8, %%rsp")
+	}
+}
+
+func (a *asmFunction) binary(exp *parser.BinaryExpression) {
+	if expressionValueType(exp.LHS, &a.variables) == "String*" {
+		a.stringBinary(exp)
+		return
+	}
+
+	a.expression(exp.LHS)
+	a.push("%rax")
+	a.expression(exp.RHS)
+	a.emit("movq %%rax, %%rcx")
+	a.pop("%rax")
+
+	switch exp.Op {
+	case parser.BinaryOperatorPlus:
+		a.emit("addl %%ecx, %%eax")
+	case parser.BinaryOperatorMinus:
+		a.emit("subl %%ecx, %%eax")
+	case parser.BinaryOperatorMultiplication:
+		a.emit("imull %%ecx, %%eax")
+	case parser.BinaryOperatorDivision:
+		a.emit("cltd")
+		a.emit("idivl %%ecx")
+	case parser.BinaryOperatorModulo:
+		a.emit("cltd")
+		a.emit("idivl %%ecx")
+		a.emit("movl %%edx, %%eax")
+	default:
+		a.emit("cmpl %%ecx, %%eax")
+		a.emit("%s %%al", asmSetCondition(exp.Op))
+		a.emit("movzbl %%al, %%eax")
+		return
+	}
+	a.emit("movslq %%eax, %%rax")
+}
+
+func asmSetCondition(binOp parser.BinaryOperator) string {
+	switch binOp {
+	case parser.BinaryOperatorEqual:
+		return "sete"
+	case parser.BinaryOperatorNotEqual:
+		return "setne"
+	case parser.BinaryOperatorLessThan:
+		return "setl"
+	case parser.BinaryOperatorGreaterThan:
+		return "setg"
+	case parser.BinaryOperatorLessThanOrEqual:
+		return "setle"
+	case parser.BinaryOperatorGreaterThanOrEqual:
+		return "setge"
+	default:
+		panic("Fallthrough")
+	}
+}
+
+func (a *asmFunction) stringBinary(exp *parser.BinaryExpression) {
+	args := a.args([]parser.Expression{exp.LHS, exp.RHS})
+
+	switch exp.Op {
+	case parser.BinaryOperatorPlus:
+		a.call("String__concat", args, "String*")
+	case parser.BinaryOperatorEqual:
+		a.call("String__equals", args, "bool")
+	case parser.BinaryOperatorNotEqual:
+		a.call("String__equals", args, "bool")
+		a.emit("xorq $1, %%rax")
+	case parser.BinaryOperatorLessThan,
+		parser.BinaryOperatorGreaterThan,
+		parser.BinaryOperatorLessThanOrEqual,
+		parser.BinaryOperatorGreaterThanOrEqual:
+		a.call("String__compare", args, "int")
+		a.emit("cmpl $0, %%eax")
+		a.emit("%s %%al", asmSetCondition(exp.Op))
+		a.emit("movzbl %%al, %%eax")
+	default:
+		msg := fmt.Sprintf("Invalid String operator: %s", exp.Op)
+		panic(msg)
+	}
+}
+
+// toString converts any expression to a String*, like the C backend
+func (a *asmFunction) toString(expression parser.Expression) {
+	valueType := expressionValueType(expression, &a.variables)
+
+	switch {
+	case valueType == "String*":
+		a.expression(expression)
+	case valueType == "int", valueType == "bool", isTypeStruct(typeName(valueType)):
+		methodType(valueType, "toString")
+		a.call(
+			fmt.Sprintf("%s__toString", typeName(valueType)),
+			[]func(){a.arg(expression)},
+			"String*")
+	default:
+		msg := fmt.Sprintf("Type can't be converted to a String: %s", valueType)
+		panic(msg)
+	}
+}
+
+func (a *asmFunction) callExpression(exp *parser.CallExpression) {
+	if exp.Callee == "println" {
+		a.println(exp)
+		return
+	}
+
+	if isTypeStruct(exp.Callee) || isTypeRuntime(exp.Callee) {
+		a.call(fmt.Sprintf("%s__make", exp.Callee), nil, cType(exp.Callee))
+		return
+	}
+
+	if asmTailCalls[exp] {
+		a.tailCall(exp.Callee, a.args(exp.Params))
+		return
+	}
+	a.call(exp.Callee, a.args(exp.Params), expressionValueType(exp, &a.variables))
+}
+
+func (a *asmFunction) println(exp *parser.CallExpression) {
+	format := []string{}
+	args := []func(){}
+
+	for _, param := range exp.Params {
+		param := param
+		val := strings.Trim(expressionValueType(param, &a.variables), "*")
+		switch {
+		case val == "int":
+			format = append(format, "%d")
+			args = append(args, a.arg(param))
+		case val == "bool":
+			format = append(format, "%s")
+			trueLabel := asmString("true")
+			falseLabel := asmString("false")
+			args = append(args, func() {
+				a.expression(param)
+				a.emit("testq %%rax, %%rax")
+				a.emit("leaq %s(%%rip), %%rax", falseLabel)
+				a.emit("leaq %s(%%rip), %%rcx", trueLabel)
+				a.emit("cmovne %%rcx, %%rax")
+			})
+		case val == "String" || isTypeStruct(val):
+			format = append(format, "%s")
+			args = append(args, func() {
+				a.toString(param)
+				// String's value is its first field
+				a.emit("movq (%%rax), %%rax")
+			})
+		default:
+			msg := fmt.Sprintf("Unknown type: %s", val)
+			panic(msg)
+		}
+	}
+
+	label := asmString(strings.Join(format, " ") + "\\n")
+	args = append([]func(){func() {
+		a.emit("leaq %s(%%rip), %%rax", label)
+	}}, args...)
+	a.call("printf", args, "void")
+}
+
+func (a *asmFunction) accessor(exp *parser.AccessorExpression) {
+	if _, ok := a.variables[exp.Target]; !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
+		panic(msg)
+	}
+	target := exp.Holder()
+	targetType := expressionValueType(target, &a.variables)
+
+	switch inner := exp.Member().(type) {
+	case *parser.CallExpression:
+		// Methods are called as Type__method(self, ...)
+		name := fmt.Sprintf("%s__%s", typeName(targetType), inner.Callee)
+		params := append([]parser.Expression{target}, inner.Params...)
+		if asmTailCalls[inner] {
+			a.tailCall(name, a.args(params))
+			return
+		}
+		a.call(name, a.args(params), methodType(targetType, inner.Callee))
+	case *parser.VariableExpression:
+		a.expression(target)
+		a.emit("movq %d(%%rax), %%rax", asmPropOffset(targetType, inner.Name))
+	default:
+		panic("Invalid accessor expression")
+	}
+}
+
+// assign stores to a variable or the last of a chain of fields. The
+// struct holding the field is read first, then the value, which
+// reads a compound assignment's target again.
+func (a *asmFunction) assign(exp *parser.AssignmentExpression) {
+	target := exp.Target
+	if len(target.Fields) == 0 {
+		a.expression(exp.Value())
+		a.store(target.Name)
+		return
+	}
+
+	if _, ok := a.variables[target.Name]; !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", target.Name)
+		panic(msg)
+	}
+	last := len(target.Fields) - 1
+	holder := (&parser.LValue{Name: target.Name, Fields: target.Fields[:last]}).Read()
+	a.expression(holder)
+	a.push("%rax")
+	a.expression(exp.Value())
+	a.pop("%rcx")
+	offset := asmPropOffset(expressionValueType(holder, &a.variables), target.Fields[last])
+	a.emit("movq %%rax, %d(%%rcx)", offset)
+}
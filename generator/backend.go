@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Artifacts are the files a backend generates, mapped from
+// file name to contents, e.g. out.c
+type Artifacts map[string]string
+
+// Backend turns a parsed program into an executable
+type Backend interface {
+	// Generate panics with a message on errors, like the rest
+	// of the compiler. It returns the names of the unused code
+	// it removed with the artifacts.
+	Generate(nodes []parser.Node, config *BuildConfig) (Artifacts, []string)
+	Link(artifacts Artifacts, config *BuildConfig) error
+}
+
+var backends = map[string]Backend{}
+
+// RegisterBackend makes a backend available to the driver by name
+func RegisterBackend(name string, backend Backend) {
+	if _, ok := backends[name]; ok {
+		msg := fmt.Sprintf("Backend registered twice: %s", name)
+		panic(msg)
+	}
+	backends[name] = backend
+}
+
+// LookupBackend ...
+func LookupBackend(name string) (Backend, error) {
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown backend: %s", name)
+	}
+	return backend, nil
+}
+
+// BackendNames lists the registered backends in order
+func BackendNames() []string {
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterBackend("c", cBackend{})
+	RegisterBackend("asm", asmBackend{})
+	RegisterBackend("llvm", llvmBackend{})
+}
+
+type cBackend struct{}
+
+func (cBackend) Generate(nodes []parser.Node, config *BuildConfig) (Artifacts, []string) {
+	code, removed := GenerateC(nodes, config)
+	return Artifacts{"out.c": code}, removed
+}
+
+func (cBackend) Link(artifacts Artifacts, config *BuildConfig) error {
+	return CompileC(artifacts["out.c"], config)
+}
+
+type asmBackend struct{}
+
+func (asmBackend) Generate(nodes []parser.Node, config *BuildConfig) (Artifacts, []string) {
+	// The IR checks types and declarations for the backends
+	// generating code from the AST
+	Check(nodes)
+	return Artifacts{"out.s": GenerateAsm(nodes, config)}, nil
+}
+
+func (asmBackend) Link(artifacts Artifacts, config *BuildConfig) error {
+	return AssembleAndLink(artifacts["out.s"], config)
+}
+
+type llvmBackend struct{}
+
+func (llvmBackend) Generate(nodes []parser.Node, config *BuildConfig) (Artifacts, []string) {
+	Check(nodes)
+	return Artifacts{"out.ll": GenerateLLVM(nodes, config)}, nil
+}
+
+func (llvmBackend) Link(artifacts Artifacts, config *BuildConfig) error {
+	return CompileLLVM(artifacts["out.ll"], config)
+}
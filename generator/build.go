@@ -0,0 +1,282 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// BuildConfig describes how a program is compiled, the code
+// generated for it and how that's turned into an executable
+type BuildConfig struct {
+	// Memory selects how generated programs manage their objects
+	Memory MemoryMode
+	// EscapeAnalysis lets structs that never leave the function
+	// creating them live on the C stack instead of the heap
+	EscapeAnalysis bool
+	// DeadCodeElimination drops the functions, methods and structs
+	// main and Exports can't reach from the IR the C is generated from
+	DeadCodeElimination bool
+	// Inlining replaces calls to small functions with their bodies,
+	// see ir.Inline
+	Inlining bool
+	// Exports are functions kept for C callers when main doesn't call them
+	Exports []string
+	// CC is the C compiler, either a name found on PATH or a path.
+	// When empty $CC is used, then the first of clang, gcc, cc
+	// and tcc that is installed. The llvm backend needs clang.
+	CC string
+	// AS and LD are the assembler and linker used by the asm
+	// backend, as and ld on PATH when empty
+	AS string
+	LD string
+	// CFlags are passed to the C compiler when compiling
+	CFlags []string
+	// LDFlags are passed to the C compiler after the sources
+	LDFlags []string
+	// OptLevel is passed as -O<level>, e.g. 0, 2 or s
+	OptLevel string
+	// Output is the path of the executable
+	Output string
+	// BuildDir is where out.c, out.s or out.ll is written. When empty a temp dir
+	// is used and removed after compiling.
+	BuildDir string
+	// Runtime holds the runtime/ sources, usually embedded in the compiler
+	Runtime fs.FS
+	// CacheDir is where the compiled runtime is kept, the
+	// user's cache dir when empty
+	CacheDir string
+}
+
+// DefaultBuildConfig ...
+func DefaultBuildConfig() *BuildConfig {
+	return &BuildConfig{
+		Memory:              MemoryModeGC,
+		EscapeAnalysis:      true,
+		DeadCodeElimination: true,
+		Inlining:            true,
+		OptLevel:            "0",
+		Output:              "out",
+	}
+}
+
+var knownCompilers = []string{"clang", "gcc", "cc", "tcc"}
+
+// FindCC resolves the C compiler a config will use
+func (c *BuildConfig) FindCC() (string, error) {
+	if c.CC != "" {
+		return exec.LookPath(c.CC)
+	}
+	if cc := os.Getenv("CC"); cc != "" {
+		return exec.LookPath(cc)
+	}
+	for _, cc := range knownCompilers {
+		if path, err := exec.LookPath(cc); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("No C compiler found, tried: %v", knownCompilers)
+}
+
+// CompileC writes the generated code to out.c in the build dir
+// and builds it with the runtime into an executable
+func CompileC(code string, config *BuildConfig) error {
+	cc, err := config.FindCC()
+	if err != nil {
+		return err
+	}
+
+	buildDir, cleanup, err := prepareBuildDir(config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	source := filepath.Join(buildDir, "out.c")
+	if err := ioutil.WriteFile(source, []byte(code), 0644); err != nil {
+		return err
+	}
+
+	runtimeDir, runtimeObjects, err := runtimeObjects(cc, config)
+	if err != nil {
+		return err
+	}
+
+	args := compileArgs(config)
+	// out.c includes runtime/runtime.h
+	args = append(args, "-I", runtimeDir)
+	args = append(args, source)
+	args = append(args, runtimeObjects...)
+	args = append(args, "-o", config.Output)
+	args = append(args, config.LDFlags...)
+
+	cmd := exec.Command(cc, args...)
+	var errLog bytes.Buffer
+	cmd.Stderr = &errLog
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("C compilation failed: %s\n%s", err, errLog.String())
+	}
+	return nil
+}
+
+// CompileLLVM writes the generated IR to out.ll in the build dir
+// and builds it with the runtime using clang, which optimises
+// the IR at the config's OptLevel
+func CompileLLVM(code string, config *BuildConfig) error {
+	clang, err := exec.LookPath(orDefault(config.CC, "clang"))
+	if err != nil {
+		return err
+	}
+
+	buildDir, cleanup, err := prepareBuildDir(config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	source := filepath.Join(buildDir, "out.ll")
+	if err := ioutil.WriteFile(source, []byte(code), 0644); err != nil {
+		return err
+	}
+
+	// The runtime is built by the same clang
+	llvmConfig := *config
+	llvmConfig.CC = clang
+	_, runtimeObjects, err := runtimeObjects(clang, &llvmConfig)
+	if err != nil {
+		return err
+	}
+
+	args := compileArgs(config)
+	args = append(args, source)
+	args = append(args, runtimeObjects...)
+	args = append(args, "-o", config.Output)
+	args = append(args, config.LDFlags...)
+	return runTool("LLVM compilation", clang, args...)
+}
+
+// AssembleAndLink writes the generated assembly to out.s in the
+// build dir, assembles it with as and links it with the runtime
+// and libc using ld
+func AssembleAndLink(code string, config *BuildConfig) error {
+	cc, err := config.FindCC()
+	if err != nil {
+		return err
+	}
+	as, err := exec.LookPath(orDefault(config.AS, "as"))
+	if err != nil {
+		return err
+	}
+	ld, err := exec.LookPath(orDefault(config.LD, "ld"))
+	if err != nil {
+		return err
+	}
+
+	buildDir, cleanup, err := prepareBuildDir(config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	source := filepath.Join(buildDir, "out.s")
+	if err := ioutil.WriteFile(source, []byte(code), 0644); err != nil {
+		return err
+	}
+	object := filepath.Join(buildDir, "out.o")
+	if err := runTool("Assembling", as, "-o", object, source); err != nil {
+		return err
+	}
+
+	// The runtime is still C, built by the C compiler
+	_, runtimeObjects, err := runtimeObjects(cc, config)
+	if err != nil {
+		return err
+	}
+
+	libDir, err := findLibDir()
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-o", config.Output,
+		"-dynamic-linker", dynamicLinker,
+		filepath.Join(libDir, "crt1.o"),
+		filepath.Join(libDir, "crti.o"),
+		object,
+	}
+	args = append(args, runtimeObjects...)
+	args = append(args, config.LDFlags...)
+	args = append(args, "-L"+libDir, "-lc", filepath.Join(libDir, "crtn.o"))
+	return runTool("Linking", ld, args...)
+}
+
+const dynamicLinker = "/lib64/ld-linux-x86-64.so.2"
+
+// libDirs are searched for the C startup files and libc
+var libDirs = []string{
+	"/usr/lib/x86_64-linux-gnu",
+	"/usr/lib64",
+	"/lib/x86_64-linux-gnu",
+	"/usr/lib",
+}
+
+func findLibDir() (string, error) {
+	for _, dir := range libDirs {
+		if _, err := os.Stat(filepath.Join(dir, "crt1.o")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("No crt1.o found, tried: %v", libDirs)
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func runTool(step string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	var errLog bytes.Buffer
+	cmd.Stderr = &errLog
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s\n%s", step, err, errLog.String())
+	}
+	return nil
+}
+
+// prepareBuildDir creates the config's build dir, or a temp dir
+// removed by cleanup when it isn't set
+func prepareBuildDir(config *BuildConfig) (string, func(), error) {
+	if config.BuildDir == "" {
+		dir, err := ioutil.TempDir("", "compiler-build")
+		if err != nil {
+			return "", nil, err
+		}
+		return dir, func() { os.RemoveAll(dir) }, nil
+	}
+	if err := os.MkdirAll(config.BuildDir, 0755); err != nil {
+		return "", nil, err
+	}
+	return config.BuildDir, func() {}, nil
+}
+
+// compileArgs are the flags shared by the runtime and generated code
+func compileArgs(config *BuildConfig) []string {
+	args := []string{}
+	args = append(args, config.CFlags...)
+	if config.OptLevel != "" {
+		args = append(args, "-O"+config.OptLevel)
+	}
+	if config.Memory == MemoryModeARC {
+		args = append(args, "-DRUNTIME_ARC")
+	}
+	return args
+}
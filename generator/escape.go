@@ -0,0 +1,179 @@
+package generator
+
+import (
+	"github.com/alexmarchant/compiler/ir"
+)
+
+// currentStackStructs are the locals of the function being
+// generated that hold stack allocated structs, mapped to the
+// new instruction they're initialised with
+var currentStackStructs = map[*ir.Local]*ir.Instruction{}
+
+// selfEscapes caches whether a method lets self escape,
+// keyed by Type__method
+var selfEscapes = map[string]bool{}
+
+// stackStructs finds the struct locals of a function that can be
+// stack allocated. A local is a candidate when its only store is
+// the struct it's declared with, e.g. var p: Point = Point(), and
+// it's stack allocated unless it escapes, see localEscapes.
+func stackStructs(module *ir.Module, function *ir.Function) map[*ir.Local]*ir.Instruction {
+	stack := map[*ir.Local]*ir.Instruction{}
+	if !currentConfig.EscapeAnalysis {
+		return stack
+	}
+
+	uses := function.Uses()
+	stores := map[*ir.Local][]*ir.Instruction{}
+	for _, block := range function.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction.Op == ir.OpStore {
+				stores[instruction.Local] = append(stores[instruction.Local], instruction)
+			}
+		}
+	}
+
+	loops := loopBlocks(function)
+	for _, local := range function.Locals {
+		// Reassigned locals may hold different structs, and so may
+		// those declared in a loop
+		if len(stores[local]) != 1 || loops[stores[local][0]] {
+			continue
+		}
+		init := stores[local][0].Args[0]
+		if init.Op != ir.OpNew || init.Name != string(local.Type) || len(uses[init]) != 1 {
+			continue
+		}
+		if !canStackAllocate(module, local.Type) {
+			continue
+		}
+		if !localEscapes(module, function, local, init, uses) {
+			stack[local] = init
+		}
+	}
+	return stack
+}
+
+// loopBlocks finds the instructions of a function that can run
+// more than once a call, those in blocks that can reach themselves
+func loopBlocks(function *ir.Function) map[*ir.Instruction]bool {
+	successors := map[*ir.Block][]*ir.Block{}
+	for _, block := range function.Blocks {
+		if terminator := block.Terminator(); terminator != nil {
+			successors[block] = terminator.Targets
+		}
+	}
+
+	loops := map[*ir.Instruction]bool{}
+	for _, block := range function.Blocks {
+		visited := map[*ir.Block]bool{}
+		worklist := append([]*ir.Block{}, successors[block]...)
+		for len(worklist) > 0 {
+			next := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			if next == block {
+				for _, instruction := range block.Instructions {
+					loops[instruction] = true
+				}
+				break
+			}
+			if !visited[next] {
+				visited[next] = true
+				worklist = append(worklist, successors[next]...)
+			}
+		}
+	}
+	return loops
+}
+
+// isStackStructInit reports whether an instruction is the new
+// that creates a stack allocated struct, which is declared with
+// the frame instead
+func isStackStructInit(instruction *ir.Instruction) bool {
+	for _, init := range currentStackStructs {
+		if init == instruction {
+			return true
+		}
+	}
+	return false
+}
+
+// canStackAllocate rules out structs with weak props, which the
+// reference counting runtime tracks by their address
+func canStackAllocate(module *ir.Module, structType ir.Type) bool {
+	str := module.Struct(string(structType))
+	if str == nil {
+		return false
+	}
+	for _, field := range str.Fields {
+		if field.Weak {
+			return false
+		}
+	}
+	return true
+}
+
+// localEscapes reports whether the struct held by a local can
+// outlive the function. It escapes when it's returned, stored
+// anywhere, passed to a function or has a method called on it
+// that lets self escape. Reading and writing its fields and
+// comparing it don't, and neither does storing init, the struct
+// it's declared with.
+func localEscapes(module *ir.Module, function *ir.Function, local *ir.Local, init *ir.Instruction, uses map[*ir.Instruction][]*ir.Instruction) bool {
+	for _, block := range function.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction.Local != local {
+				continue
+			}
+			if instruction.Op == ir.OpStore {
+				if instruction.Args[0] != init {
+					return true
+				}
+				continue
+			}
+			for _, use := range uses[instruction] {
+				if valueEscapes(module, local, instruction, use) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// valueEscapes reports whether use lets the struct value escape
+func valueEscapes(module *ir.Module, local *ir.Local, value *ir.Instruction, use *ir.Instruction) bool {
+	switch use.Op {
+	case ir.OpGetField, ir.OpEqual, ir.OpNotEqual:
+		return false
+	case ir.OpSetField:
+		return use.Args[1] == value
+	case ir.OpCall:
+		for i, arg := range use.Args {
+			if arg == value && i > 0 {
+				return true
+			}
+		}
+		callee := module.Function(use.Name)
+		if callee == nil || callee.Receiver != string(local.Type) {
+			return true
+		}
+		return methodSelfEscapes(module, callee)
+	default:
+		return true
+	}
+}
+
+// methodSelfEscapes analyses a struct method as though self
+// were one of its locals
+func methodSelfEscapes(module *ir.Module, method *ir.Function) bool {
+	if escapes, ok := selfEscapes[method.Name]; ok {
+		return escapes
+	}
+
+	// Recursive calls are assumed to escape until proven otherwise
+	selfEscapes[method.Name] = true
+	escapes := localEscapes(module, method, method.Params[0], nil, method.Uses())
+	selfEscapes[method.Name] = escapes
+	return escapes
+}
@@ -0,0 +1,60 @@
+package generator
+
+import (
+	"fmt"
+)
+
+// Generated code keeps the collector informed through a
+// GCFrame per function call, see runtime/gc.h
+
+// generateGCFrameEnter registers the params and locals
+// holding objects as roots of the function's frame
+func generateGCFrameEnter() string {
+	code := ""
+	rootsArg := "NULL"
+	if len(currentLocals) > 0 {
+		code += fmt.Sprintf("\tvoid** __gc_roots[%d];\n", len(currentLocals))
+		rootsArg = "__gc_roots"
+	}
+	code += "\tGCFrame __gc_frame;\n"
+	code += fmt.Sprintf("\tgc_enter(&__gc_frame, %s, %d);\n", rootsArg, len(currentLocals))
+	for _, root := range currentLocals {
+		code += fmt.Sprintf("\tgc_root(&__gc_frame, (void**)&%s);\n", root)
+	}
+
+	return code
+}
+
+// generateGCReturn leaves the frame before returning, objects
+// are handed to the caller's frame to keep them alive until
+// the caller's statement finishes
+func generateGCReturn(value string, indent string) string {
+	code := fmt.Sprintf("%sgc_leave(&__gc_frame);\n", indent)
+	if currentFunction.ReturnType.IsRef() {
+		return code + fmt.Sprintf("%sreturn gc_keep(%s);\n", indent, value)
+	}
+	return code + fmt.Sprintf("%sreturn %s;\n", indent, value)
+}
+
+// The globals holding objects are the roots of a frame entered
+// before main's, which is never left
+
+func generateGCGlobalRoots(roots []string) string {
+	if len(roots) == 0 {
+		return ""
+	}
+	code := fmt.Sprintf("static void** __gc_global_roots[%d];\n", len(roots))
+	code += "static GCFrame __gc_globals;\n"
+	return code
+}
+
+func generateGCGlobalsEnter(roots []string) string {
+	if len(roots) == 0 {
+		return ""
+	}
+	code := fmt.Sprintf("\tgc_enter(&__gc_globals, __gc_global_roots, %d);\n", len(roots))
+	for _, root := range roots {
+		code += fmt.Sprintf("\tgc_root(&__gc_globals, (void**)&%s);\n", root)
+	}
+	return code
+}
@@ -3,117 +3,228 @@ package generator
 import (
 	"fmt"
 	"strings"
-	"io/ioutil"
-	"os/exec"
-	"bytes"
 
+	"github.com/alexmarchant/compiler/ir"
 	"github.com/alexmarchant/compiler/parser"
 )
 
 var customTypes = map[string]string{}
 
-// GenerateC ...
-func GenerateC(nodes []parser.Node) string {
+// currentModule and currentFunction are what's being generated,
+// currentConfig the options it's generated with
+var currentModule *ir.Module
+var currentFunction *ir.Function
+var currentConfig *BuildConfig
+
+// GenerateC lowers a program to IR and generates C from it, it
+// returns the names of the unused code Lower removed too
+func GenerateC(nodes []parser.Node, config *BuildConfig) (string, []string) {
+	module, removed := Lower(nodes, config)
+	return GenerateCFromIR(module, config), removed
+}
+
+// Check lowers a program to IR and verifies it, which checks its
+// types, and its function attributes for the backends and modes
+// that run its AST
+func Check(nodes []parser.Node) {
+	module := ir.Lower(nodes)
+	verify(module)
+	ir.CheckAttributes(module)
+}
+
+// Lower turns a program into the verified IR the C backend
+// generates code from. It's verified before it's optimised too, so
+// errors are reported before the passes can remove their code.
+// The names of the unused code dead code elimination removed are
+// returned with the module.
+func Lower(nodes []parser.Node, config *BuildConfig) (*ir.Module, []string) {
+	module := ir.Lower(nodes)
+	verify(module)
+	// Attributes are checked whether or not the passes they're
+	// for are enabled
+	ir.CheckAttributes(module)
+	removed := []string{}
+	if config.DeadCodeElimination {
+		removed = ir.EliminateDeadCode(module, config.Exports...)
+	}
+	if config.Inlining {
+		ir.Inline(module)
+		// Functions that were only called where they're now
+		// inlined aren't reported as unused
+		if config.DeadCodeElimination {
+			ir.EliminateDeadCode(module, config.Exports...)
+		}
+	}
+	ir.EliminateTailCalls(module)
+	verify(module)
+	return module, removed
+}
+
+func verify(module *ir.Module) {
+	if err := ir.Verify(module); err != nil {
+		msg := fmt.Sprintf("Invalid IR: %s", err)
+		panic(msg)
+	}
+}
+
+// GenerateCFromIR generates C for a module. Each value is held in
+// a C variable named after it, blocks become labels and control
+// flow between them gotos.
+func GenerateCFromIR(module *ir.Module, config *BuildConfig) string {
+	currentModule = module
+	currentConfig = config
+	selfEscapes = map[string]bool{}
+
 	code := "#include <stdio.h>\n"
 	code += "#include <stdlib.h>\n"
 	code += "#include \"runtime/runtime.h\"\n"
 	code += "\n"
 
-	for _, node := range nodes {
-		code += generateNode(node)
+	// Forward declare structs and functions so they can refer to each other
+	for _, str := range module.Structs {
+		code += fmt.Sprintf("typedef struct _%s %s;\n", str.Name, str.Name)
+	}
+	code += "\n"
+	for _, function := range module.Functions {
+		code += generatePrototype(function) + ";\n"
 	}
+	code += "\n"
 
-	ioutil.WriteFile("./out.c", []byte(code), 0644)
-	return code
-}
+	if len(module.Globals) > 0 {
+		for _, global := range module.Globals {
+			code += generateGlobal(global)
+		}
+		code += generateGlobalRoots(module)
+		code += "\n"
+	}
 
-// CompileC ...
-func CompileC() {
-	cmd := exec.Command("sh", "-c", "clang out.c runtime/*.c -o out")
-	var errLog bytes.Buffer
-	cmd.Stderr = &errLog
-	err := cmd.Run()
-	if len(errLog.String()) > 0 {
-		fmt.Println("\n--COMPILATION--")
-		fmt.Printf(errLog.String())
+	for _, str := range module.Structs {
+		code += generateStruct(str)
 	}
-	if err != nil {
-		panic(err)
+	for _, function := range module.Functions {
+		code += generateFunction(module, function)
 	}
+
+	return code
 }
 
-func generateNode(node parser.Node) string {
-	switch node.NodeType() {
-	case parser.NodeTypeFunction:
-		function := node.(*parser.Function)
-		return generateFunction(function)
-	case parser.NodeTypeStruct:
-		str := node.(*parser.Struct)
-		return generateStruct(str)
+// cValueType is the C type holding values of an IR type,
+// structs are always passed by pointer
+func cValueType(valueType ir.Type) string {
+	switch valueType {
+	case ir.Void:
+		return "void"
+	case ir.Int:
+		return "int"
+	case ir.Bool:
+		return "bool"
 	default:
-		panic("Invalid NodeType")
+		return string(valueType) + "*"
 	}
 }
 
-func generateFunction(function *parser.Function) string {
-	variables := map[string]string{}
+// cName is the C name of a local, lowering names redeclared
+// locals e.g. x.1
+func cName(local *ir.Local) string {
+	return strings.Replace(local.Name, ".", "__", -1)
+}
 
-	code := ""
-	code += fmt.Sprintf("%s ", function.Prototype.ReturnType)
-	code += fmt.Sprintf("%s(", function.Prototype.Name)
+// cValue is the C variable holding an instruction's value
+func cValue(instruction *ir.Instruction) string {
+	return fmt.Sprintf("__t%d", instruction.ID)
+}
 
-	props := []string{}
-	for _, prop := range function.Prototype.Props {
-		variables[prop.Name] = prop.Type
-		prop := fmt.Sprintf("%s %s", prop.Type, prop.Name)
-		props = append(props, prop)
-	}
-	code += strings.Join(props, ", ")
-	code += ") {\n"
+// cGlobalName is the C name of a global, prefixed so the locals
+// of a function, declared at its start, don't hide it
+func cGlobalName(name string) string {
+	return "__global_" + name
+}
 
-	body := ""
+func cLabel(block *ir.Block) string {
+	return strings.Replace(block.Name, ".", "_", -1)
+}
 
-	for _, expression := range function.Expressions {
-		body += fmt.Sprintf("\t%s;\n", generateExpression(expression, &variables))
+func generatePrototype(function *ir.Function) string {
+	params := []string{}
+	for _, param := range function.Params {
+		params = append(params, fmt.Sprintf("%s %s", cValueType(param.Type), cName(param)))
 	}
+	return fmt.Sprintf(
+		"%s %s(%s)",
+		cValueType(function.ReturnType),
+		function.Name,
+		strings.Join(params, ", "))
+}
+
+func generateFunction(module *ir.Module, function *ir.Function) string {
+	currentFunction = function
+	currentStackStructs = stackStructs(module, function)
 
-	code += body
+	code := generatePrototype(function) + " {\n"
+	if function.Name == "main" {
+		code += generateGlobalsEnter(module)
+	}
+	code += generateFrameEnter(function)
+	if function.Name == "main" && module.Function(ir.GlobalsInit) != nil {
+		code += fmt.Sprintf("\t%s();\n", ir.GlobalsInit)
+	}
+	for i, block := range function.Blocks {
+		// The entry block is never jumped to
+		if i > 0 {
+			code += fmt.Sprintf("%s:;\n", cLabel(block))
+		}
+		for _, instruction := range block.Instructions {
+			code += generateInstruction(instruction)
+		}
+	}
 	code += "}\n\n"
 
 	return code
 }
 
-func generateStruct(str *parser.Struct) string {
-	customTypes[str.Name] = "struct"
+// generateGlobal declares a global with its initial value, those
+// without one are zero until the GlobalsInit function stores it
+func generateGlobal(global *ir.Global) string {
+	value := "0"
+	switch {
+	case global.Init != nil:
+		value = fmt.Sprint(global.Init)
+	case global.Type.IsRef():
+		value = "NULL"
+	}
+	return fmt.Sprintf("static %s %s = %s;\n", cValueType(global.Type), cGlobalName(global.Name), value)
+}
 
+func generateStruct(str *ir.Struct) string {
 	// Struct def
-	code := fmt.Sprintf("typedef struct _%s {\n", str.Name)
-	for _, prop := range str.Props {
-		code += fmt.Sprintf("\t%s %s;\n", prop.Type, prop.Name)
+	code := fmt.Sprintf("struct _%s {\n", str.Name)
+	for _, field := range str.Fields {
+		code += fmt.Sprintf("\t%s %s;\n", cValueType(field.Type), field.Name)
+	}
+	code += "};\n\n"
+
+	code += generateStructType(str)
+	if str.Unconstructed {
+		return code
 	}
-	code += fmt.Sprintf("} %s;\n\n", str.Name)
 
 	// Make struct
 	code += fmt.Sprintf("%s* %s__make() {\n", str.Name, str.Name)
-	code += fmt.Sprintf("\t%s* val = malloc(sizeof(%s));\n", str.Name, str.Name)
-	code += `	if (!val) {
-		printf("Error allocating memory");
-		exit(1);
-	}
-`
-	code += "\treturn val;\n"
+	code += fmt.Sprintf(
+		"\treturn object_alloc(sizeof(%s), &%s__type);\n",
+		str.Name,
+		str.Name)
 	code += "}\n\n"
 
-	// Struct functions
-	for _, function := range str.Functions {
-		code += generateStructFunction(str, function)
-	}
-
 	return code
 }
 
-func generateStructFunction(str *parser.Struct, function *parser.Function) string {
+// methodFunction turns a struct method into a plain function
+// named Type__method taking self as its first param
+func methodFunction(str *parser.Struct, function *parser.Function) *parser.Function {
 	copy := *function
+	prototype := *function.Prototype
+	copy.Prototype = &prototype
 	copy.Prototype.Name = fmt.Sprintf("%s__%s", str.Name, function.Prototype.Name)
 	structProp := &parser.Prop{
 		Name: "self",
@@ -122,108 +233,173 @@ func generateStructFunction(str *parser.Struct, function *parser.Function) strin
 	copy.Prototype.Props = append(
 		[]*parser.Prop{structProp},
 		copy.Prototype.Props...)
-	
-	return generateFunction(&copy)
-}
-
-func generateExpression(expression parser.Expression, functionVariables *map[string]string) string {
-	switch expression.ExpressionType() {
-	case parser.ExpressionTypeInt:
-		exp := expression.(*parser.IntExpression)
-		return fmt.Sprintf("%d", exp.Value)
-	case parser.ExpressionTypeString:
-		exp := expression.(*parser.StringExpression)
-		return fmt.Sprintf("String__make(\"%s\")", exp.Value)
-	case parser.ExpressionTypeReturn:
-		exp := expression.(*parser.ReturnExpression)
-		code := "return "
-		code += generateExpression(exp.Expression, functionVariables)
-		return code
-	case parser.ExpressionTypeBinary:
-		exp := expression.(*parser.BinaryExpression)
-		code := generateExpression(exp.LHS, functionVariables)
-		code += fmt.Sprintf(" %s ", cBinaryOperator(exp.Op))
-		code += generateExpression(exp.RHS, functionVariables)
-		return code
-	case parser.ExpressionTypeCall:
-		exp := expression.(*parser.CallExpression)
 
-		if isBuiltin(exp.Callee) {
-			return generateBuiltin(exp, functionVariables)
-		}
+	return &copy
+}
 
-		// Struct declaration looks like a call expression,
-		// TODO: should catch this in the parser
-		if isTypeStruct(exp.Callee) {
-			return fmt.Sprintf("%s__make()", exp.Callee)
-		}
+// globalsInitFunction is the ir.GlobalsInit function the backends
+// generating from the AST call at the start of main, storing each
+// global's initial value in the order they're declared
+func globalsInitFunction(globals []*parser.Global) *parser.Function {
+	expressions := []parser.Expression{}
+	for _, global := range globals {
+		expressions = append(expressions, &parser.AssignmentExpression{
+			Target:     &parser.LValue{Name: global.Declaration.Name},
+			Expression: global.Declaration.Expression,
+		})
+	}
+	return &parser.Function{
+		Prototype:   &parser.Prototype{Name: ir.GlobalsInit, ReturnType: "void"},
+		Expressions: expressions,
+	}
+}
+
+func endsWithReturn(expressions []parser.Expression) bool {
+	if len(expressions) == 0 {
+		return false
+	}
+	last := expressions[len(expressions)-1]
+	return last.ExpressionType() == parser.ExpressionTypeReturn
+}
+
+// generateInstruction emits the C statement for an instruction
+func generateInstruction(instruction *ir.Instruction) string {
+	args := []string{}
+	for _, arg := range instruction.Args {
+		args = append(args, cValue(arg))
+	}
 
-		// Tradition call
-		code := fmt.Sprintf("%s(", exp.Callee)
-		paramCode := []string{}
-		for _, exp := range exp.Params {
-			paramCode = append(
-				paramCode,
-				generateExpression(exp, functionVariables))
+	switch instruction.Op {
+	case ir.OpStore:
+		if isStackStructInit(instruction.Args[0]) {
+			return ""
 		}
-		code += strings.Join(paramCode, ", ")
-		code += ")"
-		return code
-	case parser.ExpressionTypeParen:
-		exp := expression.(*parser.ParenExpression)
-		code := "("
-		code += generateExpression(exp.Expression, functionVariables)
-		code += ")"
-		return code
-	case parser.ExpressionTypeVariableDeclaration:
-		exp := expression.(*parser.VariableDeclarationExpression)
-		expType := exp.Type
-		if isTypeStruct(exp.Type) {
-			expType += "*"
+		return fmt.Sprintf(
+			"\t%s;\n",
+			generateStore(cName(instruction.Local), instruction.Local.Type, args[0], false))
+	case ir.OpStoreGlobal:
+		return fmt.Sprintf(
+			"\t%s;\n",
+			generateStore(cGlobalName(instruction.Name), instruction.Args[0].Type, args[0], false))
+	case ir.OpSetField:
+		field := fmt.Sprintf("%s->%s", args[0], instruction.Name)
+		return fmt.Sprintf(
+			"\t%s;\n",
+			generateStore(field, instruction.Args[1].Type, args[1], isFieldWeak(instruction)))
+	case ir.OpNew:
+		if isStackStructInit(instruction) {
+			return ""
 		}
-		(*functionVariables)[exp.Name] = expType
-		code := fmt.Sprintf("%s %s = ", expType, exp.Name)
-		code += generateExpression(exp.Expression, functionVariables)
-		return code
-	case parser.ExpressionTypeVariableAssignment:
-		exp := expression.(*parser.VariableAssignmentExpression)
-		code := fmt.Sprintf("%s = ", exp.Name)
-		code += generateExpression(exp.Expression, functionVariables)
-		return code
-	case parser.ExpressionTypeVariable:
-		exp := expression.(*parser.VariableExpression)
-		return exp.Name
-	case parser.ExpressionTypeAccessor:
-		exp := expression.(*parser.AccessorExpression)
-		code := ""
-
-		targetType, ok := (*functionVariables)[exp.Target]
-		if !ok {
-			msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
-			panic(msg)
+		return generateDefinition(instruction, fmt.Sprintf("%s__make()", instruction.Name))
+	case ir.OpCall:
+		call := fmt.Sprintf("%s(%s)", instruction.Name, strings.Join(args, ", "))
+		if instruction.Type == ir.Void {
+			return fmt.Sprintf("\t%s;\n", call)
 		}
-
-		if exp.Expression.ExpressionType() == parser.ExpressionTypeCall {
-			callExp := exp.Expression.(*parser.CallExpression)
-			callExp.Params = append(
-				[]parser.Expression{&parser.VariableExpression{Name: exp.Target}},
-				callExp.Params...)
-			code += fmt.Sprintf(
-				"%s__%s",
-				strings.Trim(targetType, "*"),
-				generateExpression(exp.Expression, functionVariables))
-		} else {
-			code += fmt.Sprintf(
-				"%s->%s",
-				exp.Target,
-				generateExpression(exp.Expression, functionVariables))
+		return generateDefinition(instruction, call)
+	case ir.OpPrint:
+		return fmt.Sprintf("\t%s;\n", generatePrint(instruction))
+	case ir.OpEndStatement:
+		return generateEndStatement("\t")
+	case ir.OpBranch:
+		return fmt.Sprintf(
+			"\tif (%s) goto %s; else goto %s;\n",
+			args[0],
+			cLabel(instruction.Targets[0]),
+			cLabel(instruction.Targets[1]))
+	case ir.OpJump:
+		return fmt.Sprintf("\tgoto %s;\n", cLabel(instruction.Targets[0]))
+	case ir.OpReturn:
+		if len(args) == 0 {
+			return generateReturn("", "\t")
 		}
+		return generateReturn(args[0], "\t")
+	default:
+		return generateDefinition(instruction, generateValue(instruction, args))
+	}
+}
 
-		return code
+// generateDefinition declares the variable holding a value
+func generateDefinition(instruction *ir.Instruction, value string) string {
+	return fmt.Sprintf("\t%s %s = %s;\n", cValueType(instruction.Type), cValue(instruction), value)
+}
+
+// generateValue is the C expression for instructions that only compute a value
+func generateValue(instruction *ir.Instruction, args []string) string {
+	switch instruction.Op {
+	case ir.OpConst:
+		switch value := instruction.Const.(type) {
+		case string:
+			return fmt.Sprintf("String__make(%s)", cString(value))
+		default:
+			return fmt.Sprint(value)
+		}
+	case ir.OpNull:
+		return "NULL"
+	case ir.OpLoad:
+		if _, ok := currentStackStructs[instruction.Local]; ok {
+			return "&" + cName(instruction.Local)
+		}
+		return cName(instruction.Local)
+	case ir.OpLoadGlobal:
+		return generateLoadGlobal(instruction)
+	case ir.OpGetField:
+		return fmt.Sprintf("%s->%s", args[0], instruction.Name)
+	case ir.OpNot:
+		return "!" + args[0]
 	default:
-		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
-		panic(msg)
+		return fmt.Sprintf("%s %s %s", args[0], cBinaryOperator(instruction.Op), args[1])
+	}
+}
+
+// generatePrint prints Ints, Bools and Strings separated by spaces
+func generatePrint(instruction *ir.Instruction) string {
+	format := []string{}
+	args := []string{"\"\\n\""}
+	for _, arg := range instruction.Args {
+		switch arg.Type {
+		case ir.Int:
+			format = append(format, "%d")
+			args = append(args, cValue(arg))
+		case ir.Bool:
+			format = append(format, "%s")
+			args = append(args, fmt.Sprintf("%s ? \"true\" : \"false\"", cValue(arg)))
+		default:
+			format = append(format, "%s")
+			args = append(args, fmt.Sprintf("%s->value", cValue(arg)))
+		}
+	}
+	args[0] = fmt.Sprintf("\"%s\\n\"", strings.Join(format, " "))
+	return fmt.Sprintf("printf(%s)", strings.Join(args, ", "))
+}
+
+// isFieldWeak reports whether a setfield stores to a weak field
+func isFieldWeak(instruction *ir.Instruction) bool {
+	str := currentModule.Struct(string(instruction.Args[0].Type))
+	return str.Field(instruction.Name).Weak
+}
+
+// cString writes a String constant as a C string literal
+func cString(value string) string {
+	code := "\""
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			code += "\\" + string(c)
+		case c == '\n':
+			code += "\\n"
+		case c == '\t':
+			code += "\\t"
+		case c == '\r':
+			code += "\\r"
+		case c < ' ' || c > '~':
+			code += fmt.Sprintf("\\%03o", c)
+		default:
+			code += string(c)
+		}
 	}
+	return code + "\""
 }
 
 func isTypeStruct(valueType string) bool {
@@ -231,16 +407,30 @@ func isTypeStruct(valueType string) bool {
 	return ok && val == "struct"
 }
 
-func cBinaryOperator(binOp parser.BinaryOperator) string {
-	switch binOp {
-	case parser.BinaryOperatorPlus:
+func cBinaryOperator(op ir.Op) string {
+	switch op {
+	case ir.OpAdd:
 		return "+"
-	case parser.BinaryOperatorMinus:
+	case ir.OpSub:
 		return "-"
-	case parser.BinaryOperatorMultiplication:
+	case ir.OpMul:
 		return "*"
-	case parser.BinaryOperatorDivision:
+	case ir.OpDiv:
 		return "/"
+	case ir.OpRem:
+		return "%"
+	case ir.OpEqual:
+		return "=="
+	case ir.OpNotEqual:
+		return "!="
+	case ir.OpLess:
+		return "<"
+	case ir.OpGreater:
+		return ">"
+	case ir.OpLessEqual:
+		return "<="
+	case ir.OpGreaterEqual:
+		return ">="
 	default:
 		panic("Fallthrough")
 	}
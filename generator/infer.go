@@ -0,0 +1,155 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// InferTypes fills in the types of the declarations in a program's
+// globals, fns and methods written without one, e.g. var x = 1, from
+// the types of their values. Inferred declarations are marked so tools
+// can show the types they were given, see Declarations. Globals are
+// inferred in the order they're initialised, see globals.Sort.
+func InferTypes(nodes []parser.Node) {
+	globals := map[string]string{}
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			InferBlockTypes(nodes, globals, []parser.Expression{global.Declaration})
+		}
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+		case *parser.Function:
+			InferBlockTypes(nodes, functionVariables(n, "", globals), n.Expressions)
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				InferBlockTypes(nodes, functionVariables(method, n.Name, globals), method.Expressions)
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+}
+
+// InferBlockTypes infers the types of the declarations in a list of
+// statements. variables are the names in scope mapped to their types
+// from the parser, the declarations are added to them.
+func InferBlockTypes(nodes []parser.Node, variables map[string]string, expressions []parser.Expression) {
+	registerTypes(nodes)
+	functionVariables := map[string]string{}
+	for name, valueType := range variables {
+		functionVariables[name] = cType(valueType)
+	}
+	inferBlock(expressions, &functionVariables)
+	for name, valueType := range functionVariables {
+		variables[name] = parserType(valueType)
+	}
+}
+
+// functionVariables maps the globals, a fn's params, and self for a
+// method of receiver, to their types from the parser
+func functionVariables(function *parser.Function, receiver string, globals map[string]string) map[string]string {
+	variables := map[string]string{}
+	for name, valueType := range globals {
+		variables[name] = valueType
+	}
+	if receiver != "" {
+		variables["self"] = receiver
+	}
+	for _, prop := range function.Prototype.Props {
+		variables[prop.Name] = prop.Type
+	}
+	return variables
+}
+
+// inferBlock declares variables in order, they're fn scoped so those
+// declared in an if's body are still declared after it
+func inferBlock(expressions []parser.Expression, functionVariables *map[string]string) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.IfExpression:
+			inferBlock(exp.Then, functionVariables)
+			inferBlock(exp.Else, functionVariables)
+		case *parser.VariableDeclarationExpression:
+			if exp.Type == "" {
+				exp.Type = inferType(exp, functionVariables)
+				exp.Inferred = true
+			}
+			(*functionVariables)[exp.Name] = cType(exp.Type)
+		}
+	}
+}
+
+func inferType(exp *parser.VariableDeclarationExpression, functionVariables *map[string]string) string {
+	if _, ok := exp.Expression.(*parser.ArrayExpression); ok {
+		msg := fmt.Sprintf("Can't infer the type of %s, declare it", exp.Name)
+		panic(msg)
+	}
+	valueType := expressionValueType(exp.Expression, functionVariables)
+	if valueType == "void" {
+		msg := fmt.Sprintf("Can't declare %s with a value of type Void", exp.Name)
+		panic(msg)
+	}
+	return parserType(valueType)
+}
+
+// parserType turns a C type back into the type from the parser,
+// the inverse of cType
+func parserType(valueType string) string {
+	if valueType == "String*" {
+		return valueType
+	}
+	return strings.TrimSuffix(valueType, "*")
+}
+
+// Declaration is a variable declared in a fn or method, or a global
+type Declaration struct {
+	// Function is the fn or method it's in, e.g. Person.greet, and
+	// empty for globals
+	Function string
+	Name     string
+	// Type is as it's written in source, e.g. Int or Person
+	Type     string
+	Inferred bool
+}
+
+// Declarations lists a program's globals and the variables declared
+// in its fns and methods with their types, once they've been inferred
+func Declarations(nodes []parser.Node) []Declaration {
+	declarations := []Declaration{}
+	var visit func(function string, expressions []parser.Expression)
+	visit = func(function string, expressions []parser.Expression) {
+		for _, expression := range expressions {
+			switch exp := expression.(type) {
+			case *parser.IfExpression:
+				visit(function, exp.Then)
+				visit(function, exp.Else)
+			case *parser.VariableDeclarationExpression:
+				declarations = append(declarations, Declaration{
+					Function: function,
+					Name:     exp.Name,
+					Type:     typeName(exp.Type),
+					Inferred: exp.Inferred,
+				})
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+			visit("", []parser.Expression{n.Declaration})
+		case *parser.Function:
+			visit(n.Prototype.Name, n.Expressions)
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				visit(fmt.Sprintf("%s.%s", n.Name, method.Prototype.Name), method.Expressions)
+			}
+		}
+	}
+	return declarations
+}
@@ -0,0 +1,813 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/ir"
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// The llvm backend emits LLVM IR as text, with opaque pointers.
+// Every local lives in an alloca, %name.addr, that LLVM's
+// mem2reg pass promotes to registers, every global in
+// @__global_name, structs get named struct
+// types with the same layout as the C backend's and objects are
+// managed with the same GCFrame calls, see runtime/gc.h.
+
+// llvmStrings are the string constants of the module, emitted
+// as @.str.0, @.str.1, ...
+var llvmStrings []string
+
+// llvmDeclarations are the functions called but not defined by
+// the module, mapped to their declarations
+var llvmDeclarations map[string]string
+
+// llvmDefined are the functions defined by the module
+var llvmDefined map[string]bool
+
+// llvmGlobals are the types of the globals, llvmGlobalRoots those
+// holding objects
+var llvmGlobals map[string]string
+var llvmGlobalRoots []string
+
+// llvmTailCalls are made with musttail, see flow.TailCalls
+var llvmTailCalls map[*parser.CallExpression]bool
+
+// llvmFunction is the state of the function being generated
+type llvmFunction struct {
+	code       string
+	returnType string
+	isMain     bool
+	variables  map[string]string
+	// globals are the globals no local hides
+	globals map[string]bool
+	roots   []string
+	temps   int
+	labels  int
+	// terminated is set after a ret or br until the next label
+	terminated bool
+}
+
+// GenerateLLVM ...
+func GenerateLLVM(nodes []parser.Node, config *BuildConfig) string {
+	if config.Memory != MemoryModeGC {
+		panic("The llvm backend only supports -memory=gc")
+	}
+
+	registerTypes(nodes)
+	llvmStrings = []string{}
+	llvmDeclarations = map[string]string{}
+	llvmDefined = map[string]bool{}
+	llvmTailCalls = flow.TailCalls(nodes)
+	llvmGlobals = map[string]string{}
+	llvmGlobalRoots = []string{}
+
+	globals := []*parser.Global{}
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeGlobal:
+			global := node.(*parser.Global)
+			name := global.Declaration.Name
+			globals = append(globals, global)
+			llvmGlobals[name] = cType(global.Declaration.Type)
+			if isPointerType(llvmGlobals[name]) {
+				llvmGlobalRoots = append(llvmGlobalRoots, name)
+			}
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			llvmDefined[function.Prototype.Name] = true
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			llvmDefined[str.Name+"__make"] = true
+			for _, function := range str.Functions {
+				llvmDefined[str.Name+"__"+function.Prototype.Name] = true
+			}
+		}
+	}
+
+	code := "%GCFrame = type { ptr, ptr, i32, i32, i32 }\n"
+	for _, node := range nodes {
+		if node.NodeType() == parser.NodeTypeStruct {
+			code += generateLLVMStructType(node.(*parser.Struct))
+		}
+	}
+	code += "\n"
+
+	body := ""
+	if len(globals) > 0 {
+		llvmDefined[ir.GlobalsInit] = true
+		for _, global := range globals {
+			valueType := llvmGlobals[global.Declaration.Name]
+			code += fmt.Sprintf(
+				"@%s = internal global %s %s\n",
+				cGlobalName(global.Declaration.Name), llvmType(valueType), llvmZero(valueType))
+		}
+		body += generateLLVMFunction(globalsInitFunction(globals))
+	}
+	if len(llvmGlobalRoots) > 0 {
+		// The GCFrame main enters for them, see generateGCGlobalsEnter
+		code += fmt.Sprintf("@__gc_global_roots = internal global [%d x ptr] zeroinitializer\n", len(llvmGlobalRoots))
+		code += "@__gc_globals = internal global %GCFrame zeroinitializer\n"
+	}
+	if len(globals) > 0 {
+		code += "\n"
+	}
+
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			body += generateLLVMFunction(function)
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			body += generateLLVMStruct(str)
+			for _, function := range str.Functions {
+				body += generateLLVMFunction(methodFunction(str, function))
+			}
+		case parser.NodeTypeGlobal:
+			// Declared above
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+
+	for i, value := range llvmStrings {
+		bytes := []byte(parser.Unescape(value))
+		code += fmt.Sprintf(
+			"@.str.%d = private unnamed_addr constant [%d x i8] c\"%s\\00\"\n",
+			i,
+			len(bytes)+1,
+			llvmEscape(bytes))
+	}
+	code += "\n" + body
+
+	for _, name := range sortedKeys(llvmDeclarations) {
+		code += llvmDeclarations[name] + "\n"
+	}
+	return code
+}
+
+// llvmType is the LLVM type of a C type
+func llvmType(valueType string) string {
+	switch {
+	case valueType == "int":
+		return "i32"
+	case valueType == "bool":
+		return "i1"
+	case valueType == "void":
+		return "void"
+	case isPointerType(valueType):
+		return "ptr"
+	default:
+		msg := fmt.Sprintf("Unknown type: %s", valueType)
+		panic(msg)
+	}
+}
+
+// llvmParamType adds the attribute C uses to pass bools
+func llvmParamType(valueType string) string {
+	if valueType == "bool" {
+		return "i1 zeroext"
+	}
+	return llvmType(valueType)
+}
+
+// llvmReturnType adds the attribute C uses to return bools
+func llvmReturnType(valueType string) string {
+	if valueType == "bool" {
+		return "zeroext i1"
+	}
+	return llvmType(valueType)
+}
+
+func llvmString(value string) string {
+	for i, existing := range llvmStrings {
+		if existing == value {
+			return fmt.Sprintf("@.str.%d", i)
+		}
+	}
+	llvmStrings = append(llvmStrings, value)
+	return fmt.Sprintf("@.str.%d", len(llvmStrings)-1)
+}
+
+func llvmEscape(bytes []byte) string {
+	escaped := ""
+	for _, b := range bytes {
+		if b < ' ' || b > '~' || b == '"' || b == '\\' {
+			escaped += fmt.Sprintf("\\%02X", b)
+		} else {
+			escaped += string(b)
+		}
+	}
+	return escaped
+}
+
+// llvmPropIndex is the index of a prop in its struct type
+func llvmPropIndex(structName string, prop string) int {
+	str := structTypes[typeName(structName)]
+	for i, p := range str.Props {
+		if p.Name == prop {
+			return i
+		}
+	}
+	msg := fmt.Sprintf("Unknown prop: %s.%s", structName, prop)
+	panic(msg)
+}
+
+func generateLLVMStructType(str *parser.Struct) string {
+	fields := []string{}
+	for _, prop := range str.Props {
+		fields = append(fields, llvmType(cType(prop.Type)))
+	}
+	return fmt.Sprintf("%%%s = type { %s }\n", str.Name, strings.Join(fields, ", "))
+}
+
+// generateLLVMStruct emits T__make, T__trace and the struct's
+// ObjectType, see runtime/object.h
+func generateLLVMStruct(str *parser.Struct) string {
+	llvmDeclare("gc_alloc", "declare ptr @gc_alloc(i64, ptr)")
+
+	code := fmt.Sprintf("define ptr @%s__make() {\n", str.Name)
+	code += fmt.Sprintf(
+		"\t%%size = ptrtoint ptr getelementptr (%%%s, ptr null, i32 1) to i64\n",
+		str.Name)
+	code += fmt.Sprintf("\t%%object = call ptr @gc_alloc(i64 %%size, ptr @%s__type)\n", str.Name)
+	code += "\tret ptr %object\n"
+	code += "}\n\n"
+
+	trace := "null"
+	pointerProps := []string{}
+	for _, prop := range str.Props {
+		if isPointerType(cType(prop.Type)) {
+			pointerProps = append(pointerProps, prop.Name)
+		}
+	}
+	if len(pointerProps) > 0 {
+		trace = fmt.Sprintf("@%s__trace", str.Name)
+		code += fmt.Sprintf("define void %s(ptr %%object, ptr %%visit) {\n", trace)
+		for _, prop := range pointerProps {
+			index := llvmPropIndex(str.Name, prop)
+			code += fmt.Sprintf(
+				"\t%%p.%s.addr = getelementptr %%%s, ptr %%object, i32 0, i32 %d\n",
+				prop, str.Name, index)
+			code += fmt.Sprintf("\t%%p.%s = load ptr, ptr %%p.%s.addr\n", prop, prop)
+			code += fmt.Sprintf("\tcall void %%visit(ptr %%p.%s)\n", prop)
+		}
+		code += "\tret void\n"
+		code += "}\n\n"
+	}
+
+	code += fmt.Sprintf(
+		"@%s__type = global { ptr, ptr, ptr } { ptr %s, ptr %s, ptr null }\n\n",
+		str.Name, llvmString(str.Name), trace)
+	return code
+}
+
+// llvmDeclare records a function called by the module
+func llvmDeclare(name string, declaration string) {
+	if llvmDefined[name] {
+		return
+	}
+	llvmDeclarations[name] = declaration
+}
+
+func generateLLVMFunction(function *parser.Function) string {
+	f := &llvmFunction{
+		returnType: cType(function.Prototype.ReturnType),
+		isMain:     function.Prototype.Name == "main",
+		variables:  map[string]string{},
+		globals:    map[string]bool{},
+	}
+	if f.isMain && f.returnType == "void" {
+		f.returnType = "int"
+	}
+
+	names := []string{}
+	params := []string{}
+	for _, prop := range function.Prototype.Props {
+		propType := cType(prop.Type)
+		f.variables[prop.Name] = propType
+		names = append(names, prop.Name)
+		params = append(params, fmt.Sprintf("%s %%%s.arg", llvmParamType(propType), prop.Name))
+	}
+	declarations := []*parser.VariableDeclarationExpression{}
+	asmDeclarations(function.Expressions, &declarations)
+	for _, exp := range declarations {
+		if _, ok := f.variables[exp.Name]; ok {
+			continue
+		}
+		f.variables[exp.Name] = cType(exp.Type)
+		names = append(names, exp.Name)
+	}
+	for _, name := range names {
+		if name != "self" && isPointerType(f.variables[name]) {
+			f.roots = append(f.roots, name)
+		}
+	}
+	for name, globalType := range llvmGlobals {
+		if _, ok := f.variables[name]; !ok {
+			f.variables[name] = globalType
+			f.globals[name] = true
+		}
+	}
+
+	f.code += fmt.Sprintf(
+		"define %s @%s(%s) {\n",
+		llvmReturnType(f.returnType),
+		function.Prototype.Name,
+		strings.Join(params, ", "))
+	f.code += "entry:\n"
+	for _, name := range names {
+		valueType := llvmType(f.variables[name])
+		f.emit("%%%s.addr = alloca %s", name, valueType)
+		f.emit("store %s %s, ptr %%%s.addr", valueType, llvmZero(f.variables[name]), name)
+	}
+	for _, prop := range function.Prototype.Props {
+		f.emit("store %s %%%s.arg, ptr %%%s.addr", llvmType(f.variables[prop.Name]), prop.Name, prop.Name)
+	}
+
+	llvmDeclare("gc_enter", "declare void @gc_enter(ptr, ptr, i32)")
+	llvmDeclare("gc_leave", "declare void @gc_leave(ptr)")
+	llvmDeclare("gc_root", "declare void @gc_root(ptr, ptr)")
+	llvmDeclare("gc_end_statement", "declare void @gc_end_statement(ptr)")
+	if f.isMain && len(llvmGlobalRoots) > 0 {
+		f.emit(
+			"call void @gc_enter(ptr @__gc_globals, ptr @__gc_global_roots, i32 %d)",
+			len(llvmGlobalRoots))
+		for _, root := range llvmGlobalRoots {
+			f.emit("call void @gc_root(ptr @__gc_globals, ptr @%s)", cGlobalName(root))
+		}
+	}
+	f.emit("%%gc.frame = alloca %%GCFrame")
+	f.emit("%%gc.roots = alloca [%d x ptr]", len(f.roots))
+	f.emit("call void @gc_enter(ptr %%gc.frame, ptr %%gc.roots, i32 %d)", len(f.roots))
+	for _, root := range f.roots {
+		f.emit("call void @gc_root(ptr %%gc.frame, ptr %%%s.addr)", root)
+	}
+	if f.isMain && len(llvmGlobals) > 0 {
+		f.emit("call void @%s()", ir.GlobalsInit)
+	}
+
+	f.block(function.Expressions)
+	if !f.terminated {
+		f.emit("call void @gc_leave(ptr %%gc.frame)")
+		if f.returnType == "void" {
+			f.emit("ret void")
+		} else {
+			f.emit("ret %s %s", llvmType(f.returnType), llvmZero(f.returnType))
+		}
+	}
+
+	f.code += "}\n\n"
+	return f.code
+}
+
+func llvmZero(valueType string) string {
+	switch llvmType(valueType) {
+	case "i32":
+		return "0"
+	case "i1":
+		return "false"
+	default:
+		return "null"
+	}
+}
+
+func (f *llvmFunction) emit(format string, args ...interface{}) {
+	f.code += "\t" + fmt.Sprintf(format, args...) + "\n"
+}
+
+func (f *llvmFunction) temp() string {
+	f.temps++
+	return fmt.Sprintf("%%t%d", f.temps)
+}
+
+func (f *llvmFunction) label(name string) string {
+	f.labels++
+	return fmt.Sprintf("%s%d", name, f.labels)
+}
+
+func (f *llvmFunction) startBlock(label string) {
+	f.code += label + ":\n"
+	f.terminated = false
+}
+
+func (f *llvmFunction) block(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			f.ret(exp)
+			// Anything after a return is unreachable
+			return
+		case *parser.IfExpression:
+			f.ifExpression(exp)
+		default:
+			f.expression(expression)
+			if f.terminated {
+				// A tail call ended the function
+				return
+			}
+			f.emit("call void @gc_end_statement(ptr %%gc.frame)")
+		}
+	}
+}
+
+// ret leaves the frame, handing objects to the caller's frame
+func (f *llvmFunction) ret(exp *parser.ReturnExpression) {
+	value := f.expression(exp.Expression)
+	if f.terminated {
+		return
+	}
+	f.emit("call void @gc_leave(ptr %%gc.frame)")
+	if isPointerType(f.returnType) {
+		llvmDeclare("gc_keep", "declare ptr @gc_keep(ptr)")
+		kept := f.temp()
+		f.emit("%s = call ptr @gc_keep(ptr %s)", kept, value)
+		value = kept
+	}
+	f.emit("ret %s %s", llvmType(f.returnType), value)
+	f.terminated = true
+}
+
+func (f *llvmFunction) ifExpression(exp *parser.IfExpression) {
+	thenLabel := f.label("then")
+	elseLabel := f.label("else")
+	endLabel := f.label("endif")
+
+	condition := f.expression(exp.Condition)
+	f.emit("br i1 %s, label %%%s, label %%%s", condition, thenLabel, elseLabel)
+
+	f.startBlock(thenLabel)
+	f.block(exp.Then)
+	thenTerminated := f.terminated
+	if !f.terminated {
+		f.emit("br label %%%s", endLabel)
+	}
+
+	f.startBlock(elseLabel)
+	f.block(exp.Else)
+	elseTerminated := f.terminated
+	if !f.terminated {
+		f.emit("br label %%%s", endLabel)
+	}
+
+	f.startBlock(endLabel)
+	if thenTerminated && elseTerminated {
+		f.emit("unreachable")
+		f.terminated = true
+	}
+}
+
+// call emits a call and records the callee's declaration,
+// returning the result or "" for void functions
+func (f *llvmFunction) call(name string, returnType string, argTypes []string, args []string) string {
+	call := f.callInstruction(name, returnType, argTypes, args)
+	if returnType == "void" {
+		f.emit("%s", call)
+		return ""
+	}
+	result := f.temp()
+	f.emit("%s = %s", result, call)
+	return result
+}
+
+// callInstruction records the callee's declaration and returns
+// the call instruction
+func (f *llvmFunction) callInstruction(name string, returnType string, argTypes []string, args []string) string {
+	typedArgs := []string{}
+	paramTypes := []string{}
+	for i, arg := range args {
+		typedArgs = append(typedArgs, fmt.Sprintf("%s %s", llvmParamType(argTypes[i]), arg))
+		paramTypes = append(paramTypes, llvmParamType(argTypes[i]))
+	}
+	llvmDeclare(name, fmt.Sprintf(
+		"declare %s @%s(%s)",
+		llvmReturnType(returnType),
+		name,
+		strings.Join(paramTypes, ", ")))
+
+	return fmt.Sprintf(
+		"call %s @%s(%s)",
+		llvmReturnType(returnType),
+		name,
+		strings.Join(typedArgs, ", "))
+}
+
+// tailCall leaves the frame and calls a function taking the same
+// params with musttail, which guarantees the call reuses this
+// function's stack frame, then returns its result. Nothing is
+// allocated between leaving the frame and the callee rooting its
+// params. It terminates the block.
+func (f *llvmFunction) tailCall(name string, returnType string, expressions []parser.Expression) string {
+	argTypes := []string{}
+	args := []string{}
+	for _, expression := range expressions {
+		argTypes = append(argTypes, expressionValueType(expression, &f.variables))
+		args = append(args, f.expression(expression))
+	}
+	f.emit("call void @gc_leave(ptr %%gc.frame)")
+	call := f.callInstruction(name, returnType, argTypes, args)
+	f.terminated = true
+	if returnType == "void" {
+		f.emit("musttail %s", call)
+		f.emit("ret void")
+		return ""
+	}
+	result := f.temp()
+	f.emit("%s = musttail %s", result, call)
+	f.emit("ret %s %s", llvmType(returnType), result)
+	return result
+}
+
+// callExpressions evaluates args left to right, then calls name
+func (f *llvmFunction) callExpressions(name string, returnType string, expressions []parser.Expression) string {
+	argTypes := []string{}
+	args := []string{}
+	for _, expression := range expressions {
+		argTypes = append(argTypes, expressionValueType(expression, &f.variables))
+		args = append(args, f.expression(expression))
+	}
+	return f.call(name, returnType, argTypes, args)
+}
+
+func (f *llvmFunction) expression(expression parser.Expression) string {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		return fmt.Sprintf("%d", exp.Value)
+	case *parser.BoolExpression:
+		if exp.Value {
+			return "true"
+		}
+		return "false"
+	case *parser.StringExpression:
+		return f.call("String__make", "String*", []string{"String*"}, []string{llvmString(exp.Value)})
+	case *parser.InterpolatedStringExpression:
+		value := f.toString(exp.Parts[0])
+		for _, part := range exp.Parts[1:] {
+			value = f.call(
+				"String__concat",
+				"String*",
+				[]string{"String*", "String*"},
+				[]string{value, f.toString(part)})
+		}
+		return value
+	case *parser.BinaryExpression:
+		return f.binary(exp)
+	case *parser.ParenExpression:
+		return f.expression(exp.Expression)
+	case *parser.CallExpression:
+		return f.callExpression(exp)
+	case *parser.VariableExpression:
+		return f.load(exp.Name)
+	case *parser.VariableDeclarationExpression:
+		value := f.expression(exp.Expression)
+		f.emit("store %s %s, ptr %%%s.addr", llvmType(cType(exp.Type)), value, exp.Name)
+		return value
+	case *parser.AssignmentExpression:
+		return f.assign(exp)
+	case *parser.AccessorExpression:
+		return f.accessor(exp)
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
+
+// load reads a local or global. The objects read from globals are
+// kept alive until the end of the statement, like the C backend's
+// generateLoadGlobal.
+func (f *llvmFunction) load(name string) string {
+	valueType := f.variableType(name)
+	value := f.temp()
+	f.emit("%s = load %s, ptr %s", value, llvmType(valueType), f.address(name))
+	if !f.globals[name] || !isPointerType(valueType) {
+		return value
+	}
+	llvmDeclare("gc_keep", "declare ptr @gc_keep(ptr)")
+	kept := f.temp()
+	f.emit("%s = call ptr @gc_keep(ptr %s)", kept, value)
+	return kept
+}
+
+// address is where a local or global is stored
+func (f *llvmFunction) address(name string) string {
+	if f.globals[name] {
+		return "@" + cGlobalName(name)
+	}
+	return fmt.Sprintf("%%%s.addr", name)
+}
+
+func (f *llvmFunction) variableType(name string) string {
+	valueType, ok := f.variables[name]
+	if !ok {
+		msg := fmt.Sprintf("Undeclared variable: %s", name)
+		panic(msg)
+	}
+	return valueType
+}
+
+func (f *llvmFunction) binary(exp *parser.BinaryExpression) string {
+	valueType := expressionValueType(exp.LHS, &f.variables)
+	if valueType == "String*" {
+		return f.stringBinary(exp)
+	}
+
+	lhs := f.expression(exp.LHS)
+	rhs := f.expression(exp.RHS)
+	result := f.temp()
+	if exp.Op.IsComparison() {
+		f.emit("%s = icmp %s %s %s, %s", result, llvmCondition(exp.Op), llvmType(valueType), lhs, rhs)
+		return result
+	}
+
+	instructions := map[parser.BinaryOperator]string{
+		parser.BinaryOperatorPlus:           "add",
+		parser.BinaryOperatorMinus:          "sub",
+		parser.BinaryOperatorMultiplication: "mul",
+		parser.BinaryOperatorDivision:       "sdiv",
+		parser.BinaryOperatorModulo:         "srem",
+	}
+	f.emit("%s = %s i32 %s, %s", result, instructions[exp.Op], lhs, rhs)
+	return result
+}
+
+func llvmCondition(binOp parser.BinaryOperator) string {
+	switch binOp {
+	case parser.BinaryOperatorEqual:
+		return "eq"
+	case parser.BinaryOperatorNotEqual:
+		return "ne"
+	case parser.BinaryOperatorLessThan:
+		return "slt"
+	case parser.BinaryOperatorGreaterThan:
+		return "sgt"
+	case parser.BinaryOperatorLessThanOrEqual:
+		return "sle"
+	case parser.BinaryOperatorGreaterThanOrEqual:
+		return "sge"
+	default:
+		panic("Fallthrough")
+	}
+}
+
+func (f *llvmFunction) stringBinary(exp *parser.BinaryExpression) string {
+	operands := []parser.Expression{exp.LHS, exp.RHS}
+
+	switch exp.Op {
+	case parser.BinaryOperatorPlus:
+		return f.callExpressions("String__concat", "String*", operands)
+	case parser.BinaryOperatorEqual:
+		return f.callExpressions("String__equals", "bool", operands)
+	case parser.BinaryOperatorNotEqual:
+		equal := f.callExpressions("String__equals", "bool", operands)
+		result := f.temp()
+		f.emit("%s = xor i1 %s, true", result, equal)
+		return result
+	case parser.BinaryOperatorLessThan,
+		parser.BinaryOperatorGreaterThan,
+		parser.BinaryOperatorLessThanOrEqual,
+		parser.BinaryOperatorGreaterThanOrEqual:
+		compare := f.callExpressions("String__compare", "int", operands)
+		result := f.temp()
+		f.emit("%s = icmp %s i32 %s, 0", result, llvmCondition(exp.Op), compare)
+		return result
+	default:
+		msg := fmt.Sprintf("Invalid String operator: %s", exp.Op)
+		panic(msg)
+	}
+}
+
+// toString converts any expression to a String*, like the C backend
+func (f *llvmFunction) toString(expression parser.Expression) string {
+	valueType := expressionValueType(expression, &f.variables)
+
+	switch {
+	case valueType == "String*":
+		return f.expression(expression)
+	case valueType == "int", valueType == "bool", isTypeStruct(typeName(valueType)):
+		methodType(valueType, "toString")
+		return f.callExpressions(
+			fmt.Sprintf("%s__toString", typeName(valueType)),
+			"String*",
+			[]parser.Expression{expression})
+	default:
+		msg := fmt.Sprintf("Type can't be converted to a String: %s", valueType)
+		panic(msg)
+	}
+}
+
+func (f *llvmFunction) callExpression(exp *parser.CallExpression) string {
+	if exp.Callee == "println" {
+		f.println(exp)
+		return ""
+	}
+
+	if isTypeStruct(exp.Callee) || isTypeRuntime(exp.Callee) {
+		return f.call(fmt.Sprintf("%s__make", exp.Callee), cType(exp.Callee), nil, nil)
+	}
+
+	if llvmTailCalls[exp] {
+		return f.tailCall(exp.Callee, expressionValueType(exp, &f.variables), exp.Params)
+	}
+	return f.callExpressions(exp.Callee, expressionValueType(exp, &f.variables), exp.Params)
+}
+
+func (f *llvmFunction) println(exp *parser.CallExpression) {
+	format := []string{}
+	args := []string{}
+
+	for _, param := range exp.Params {
+		val := strings.Trim(expressionValueType(param, &f.variables), "*")
+		switch {
+		case val == "int":
+			format = append(format, "%d")
+			args = append(args, "i32 "+f.expression(param))
+		case val == "bool":
+			format = append(format, "%s")
+			value := f.expression(param)
+			selected := f.temp()
+			f.emit(
+				"%s = select i1 %s, ptr %s, ptr %s",
+				selected, value, llvmString("true"), llvmString("false"))
+			args = append(args, "ptr "+selected)
+		case val == "String" || isTypeStruct(val):
+			format = append(format, "%s")
+			str := f.toString(param)
+			// String's value is its first field
+			value := f.temp()
+			f.emit("%s = load ptr, ptr %s", value, str)
+			args = append(args, "ptr "+value)
+		default:
+			msg := fmt.Sprintf("Unknown type: %s", val)
+			panic(msg)
+		}
+	}
+
+	formatString := llvmString(strings.Join(format, " ") + "\\n")
+	args = append([]string{"ptr " + formatString}, args...)
+	llvmDeclare("printf", "declare i32 @printf(ptr, ...)")
+	f.emit("call i32 (ptr, ...) @printf(%s)", strings.Join(args, ", "))
+}
+
+func (f *llvmFunction) accessor(exp *parser.AccessorExpression) string {
+	if _, ok := f.variables[exp.Target]; !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
+		panic(msg)
+	}
+	target := exp.Holder()
+	targetType := expressionValueType(target, &f.variables)
+
+	switch inner := exp.Member().(type) {
+	case *parser.CallExpression:
+		// Methods are called as Type__method(self, ...)
+		name := fmt.Sprintf("%s__%s", typeName(targetType), inner.Callee)
+		params := append([]parser.Expression{target}, inner.Params...)
+		if llvmTailCalls[inner] {
+			return f.tailCall(name, methodType(targetType, inner.Callee), params)
+		}
+		return f.callExpressions(name, methodType(targetType, inner.Callee), params)
+	case *parser.VariableExpression:
+		address := f.propAddress(target, targetType, inner.Name)
+		value := f.temp()
+		f.emit("%s = load %s, ptr %s", value, llvmType(propType(targetType, inner.Name)), address)
+		return value
+	default:
+		panic("Invalid accessor expression")
+	}
+}
+
+// assign stores to a variable or the last of a chain of fields. The
+// struct holding the field is read first, then the value, which
+// reads a compound assignment's target again.
+func (f *llvmFunction) assign(exp *parser.AssignmentExpression) string {
+	target := exp.Target
+	if len(target.Fields) == 0 {
+		value := f.expression(exp.Value())
+		f.emit("store %s %s, ptr %s", llvmType(f.variableType(target.Name)), value, f.address(target.Name))
+		return value
+	}
+
+	if _, ok := f.variables[target.Name]; !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", target.Name)
+		panic(msg)
+	}
+	last := len(target.Fields) - 1
+	holder := (&parser.LValue{Name: target.Name, Fields: target.Fields[:last]}).Read()
+	holderType := expressionValueType(holder, &f.variables)
+	prop := target.Fields[last]
+	address := f.propAddress(holder, holderType, prop)
+	value := f.expression(exp.Value())
+	f.emit("store %s %s, ptr %s", llvmType(propType(holderType, prop)), value, address)
+	return value
+}
+
+func (f *llvmFunction) propAddress(target parser.Expression, targetType string, prop string) string {
+	object := f.expression(target)
+	address := f.temp()
+	f.emit(
+		"%s = getelementptr %%%s, ptr %s, i32 0, i32 %d",
+		address, typeName(targetType), object, llvmPropIndex(targetType, prop))
+	return address
+}
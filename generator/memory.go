@@ -0,0 +1,210 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/ir"
+)
+
+// MemoryMode ...
+type MemoryMode string
+
+// MemoryModeGC et all are MemoryModes
+const (
+	// MemoryModeGC uses the tracing collector in runtime/gc.c
+	MemoryModeGC MemoryMode = "gc"
+	// MemoryModeARC inserts reference counting calls to runtime/arc.c
+	MemoryModeARC MemoryMode = "arc"
+)
+
+// currentLocals are the C lvalues of the params and locals of
+// the function being generated that hold objects
+var currentLocals []string
+
+func isPointerType(valueType string) bool {
+	return strings.HasSuffix(valueType, "*")
+}
+
+// generateFrameEnter declares the locals up front, so the memory
+// manager never sees them uninitialised, then enters the
+// function's frame
+func generateFrameEnter(function *ir.Function) string {
+	currentLocals = []string{}
+	for _, param := range managedParams(function) {
+		currentLocals = append(currentLocals, cName(param))
+	}
+
+	code := ""
+	for _, local := range function.Locals {
+		switch {
+		case currentStackStructs[local] != nil:
+			// Stack allocated structs are zeroed like the heap ones,
+			// the objects they hold are managed like any other local
+			code += fmt.Sprintf("\t%s %s = {0};\n", local.Type, cName(local))
+			for _, field := range currentModule.Struct(string(local.Type)).Fields {
+				if field.Type.IsRef() {
+					currentLocals = append(currentLocals, fmt.Sprintf("%s.%s", cName(local), field.Name))
+				}
+			}
+		case local.Type.IsRef():
+			code += fmt.Sprintf("\t%s %s = NULL;\n", cValueType(local.Type), cName(local))
+			currentLocals = append(currentLocals, cName(local))
+		default:
+			code += fmt.Sprintf("\t%s %s = 0;\n", cValueType(local.Type), cName(local))
+		}
+	}
+
+	switch currentConfig.Memory {
+	case MemoryModeARC:
+		return code + generateARCFrameEnter(function)
+	default:
+		return code + generateGCFrameEnter()
+	}
+}
+
+// managedParams are the params holding objects. A method's self is
+// left out, it's kept alive by the caller and may be stack allocated.
+func managedParams(function *ir.Function) []*ir.Local {
+	params := []*ir.Local{}
+	for i, param := range function.Params {
+		if function.Receiver != "" && i == 0 {
+			continue
+		}
+		if param.Type.IsRef() {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+func generateFrameLeave(indent string) string {
+	switch currentConfig.Memory {
+	case MemoryModeARC:
+		return generateARCFrameLeave(indent)
+	default:
+		return fmt.Sprintf("%sgc_leave(&__gc_frame);\n", indent)
+	}
+}
+
+func generateEndStatement(indent string) string {
+	switch currentConfig.Memory {
+	case MemoryModeARC:
+		return fmt.Sprintf("%sarc_end_statement(&__arc_frame);\n", indent)
+	default:
+		return fmt.Sprintf("%sgc_end_statement(&__gc_frame);\n", indent)
+	}
+}
+
+// generateReturn leaves the frame and returns value, which is
+// empty for functions returning Void
+func generateReturn(value string, indent string) string {
+	if value == "" {
+		return generateFrameLeave(indent) + indent + "return;\n"
+	}
+	switch currentConfig.Memory {
+	case MemoryModeARC:
+		return generateARCReturn(value, indent)
+	default:
+		return generateGCReturn(value, indent)
+	}
+}
+
+// generateStore assigns a value to a variable or prop
+func generateStore(target string, valueType ir.Type, value string, weak bool) string {
+	if currentConfig.Memory == MemoryModeARC && valueType.IsRef() {
+		return generateARCStore(target, value, weak)
+	}
+	return fmt.Sprintf("%s = %s", target, value)
+}
+
+// generateGlobalRoots declares what the memory manager needs to
+// find the objects held by globals
+func generateGlobalRoots(module *ir.Module) string {
+	if currentConfig.Memory == MemoryModeARC {
+		return generateARCGlobalRoots(globalRoots(module))
+	}
+	return generateGCGlobalRoots(globalRoots(module))
+}
+
+// generateGlobalsEnter registers the globals holding objects with
+// the memory manager at the start of main, before its frame
+func generateGlobalsEnter(module *ir.Module) string {
+	if currentConfig.Memory == MemoryModeARC {
+		return generateARCGlobalsEnter(globalRoots(module))
+	}
+	return generateGCGlobalsEnter(globalRoots(module))
+}
+
+// globalRoots are the C names of the globals holding objects
+func globalRoots(module *ir.Module) []string {
+	roots := []string{}
+	for _, global := range module.Globals {
+		if global.Type.IsRef() {
+			roots = append(roots, cGlobalName(global.Name))
+		}
+	}
+	return roots
+}
+
+// generateLoadGlobal reads a global. The objects read are kept alive
+// until the end of the statement, a call may reassign the global
+// before it's finished with them.
+func generateLoadGlobal(instruction *ir.Instruction) string {
+	name := cGlobalName(instruction.Name)
+	if !instruction.Type.IsRef() {
+		return name
+	}
+	switch currentConfig.Memory {
+	case MemoryModeARC:
+		return fmt.Sprintf("arc_autorelease(arc_retain(%s))", name)
+	default:
+		return fmt.Sprintf("gc_keep(%s)", name)
+	}
+}
+
+// generateStructType emits the ObjectType describing a struct's
+// allocations to the memory manager, see runtime/object.h
+func generateStructType(str *ir.Struct) string {
+	code := ""
+	trace := "NULL"
+	finalize := "NULL"
+
+	// Weak props are only skipped when reference counting,
+	// the collector handles cycles by itself
+	strongProps := []string{}
+	weakProps := []string{}
+	for _, field := range str.Fields {
+		if !field.Type.IsRef() {
+			continue
+		}
+		if field.Weak && currentConfig.Memory == MemoryModeARC {
+			weakProps = append(weakProps, field.Name)
+		} else {
+			strongProps = append(strongProps, field.Name)
+		}
+	}
+
+	if len(strongProps) > 0 {
+		trace = fmt.Sprintf("%s__trace", str.Name)
+		code += fmt.Sprintf("void %s__trace(void* object, ObjectVisitor visit) {\n", str.Name)
+		code += fmt.Sprintf("\t%s* self = object;\n", str.Name)
+		for _, prop := range strongProps {
+			code += fmt.Sprintf("\tvisit(self->%s);\n", prop)
+		}
+		code += "}\n\n"
+	}
+
+	if len(weakProps) > 0 {
+		finalize = fmt.Sprintf("%s__finalize", str.Name)
+		code += generateARCFinalize(str, weakProps)
+	}
+
+	code += fmt.Sprintf(
+		"ObjectType %s__type = {\"%s\", %s, %s};\n\n",
+		str.Name,
+		str.Name,
+		trace,
+		finalize)
+	return code
+}
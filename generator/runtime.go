@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The C runtime is embedded in the compiler (see main.go) and
+// compiled once into object files kept in a cache dir, keyed by
+// everything that changes the objects: the runtime's sources,
+// the C compiler, its flags and the memory mode.
+
+func runtimeCacheKey(cc string, config *BuildConfig) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "cc=%s\n", cc)
+	fmt.Fprintf(hash, "cflags=%s\n", strings.Join(config.CFlags, " "))
+	fmt.Fprintf(hash, "opt=%s\n", config.OptLevel)
+	fmt.Fprintf(hash, "memory=%s\n", config.Memory)
+
+	files, err := runtimeFiles(config.Runtime)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		dat, err := fs.ReadFile(config.Runtime, file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %d\n", file, len(dat))
+		hash.Write(dat)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+func runtimeFiles(runtime fs.FS) ([]string, error) {
+	files := []string{}
+	for _, pattern := range []string{"runtime/*.c", "runtime/*.h"} {
+		matches, err := fs.Glob(runtime, pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No runtime sources embedded")
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runtimeObjects returns the dir generated code should include
+// runtime/runtime.h from and the runtime objects to link with,
+// compiling them first if they aren't cached yet
+func runtimeObjects(cc string, config *BuildConfig) (string, []string, error) {
+	key, err := runtimeCacheKey(cc, config)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			userCacheDir = os.TempDir()
+		}
+		cacheDir = filepath.Join(userCacheDir, "alexmarchant-compiler")
+	}
+	dir := filepath.Join(cacheDir, "runtime-"+key)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := buildRuntime(cc, config, cacheDir, dir); err != nil {
+			return "", nil, err
+		}
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	objects, err := filepath.Glob(filepath.Join(dir, "runtime", "*.o"))
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, objects, nil
+}
+
+// buildRuntime extracts and compiles the runtime in a temp dir
+// that's renamed into place, so concurrent builds never see a
+// half built cache entry
+func buildRuntime(cc string, config *BuildConfig, cacheDir string, dir string) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(cacheDir, "building-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := os.Mkdir(filepath.Join(tmp, "runtime"), 0755); err != nil {
+		return err
+	}
+	files, err := runtimeFiles(config.Runtime)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		dat, err := fs.ReadFile(config.Runtime, file)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmp, file), dat, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".c") {
+			continue
+		}
+		source := filepath.Join(tmp, file)
+		args := compileArgs(config)
+		args = append(args, "-c", source, "-o", strings.TrimSuffix(source, ".c")+".o")
+
+		cmd := exec.Command(cc, args...)
+		var errLog bytes.Buffer
+		cmd.Stderr = &errLog
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Runtime compilation failed: %s\n%s", err, errLog.String())
+		}
+	}
+
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		// Another build finished the same runtime first
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+	}
+	return err
+}
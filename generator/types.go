@@ -0,0 +1,235 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+var functionTypes = map[string]*parser.Prototype{}
+var structTypes = map[string]*parser.Struct{}
+
+// stringMethods are the String methods provided by the C runtime,
+// mapped to their return types
+var stringMethods = map[string]string{
+	"concat":    "String*",
+	"equals":    "bool",
+	"compare":   "int",
+	"length":    "int",
+	"substring": "String*",
+	"indexOf":   "int",
+	"contains":  "bool",
+	"split":     "StringArray*",
+	"join":      "String*",
+	"trim":      "String*",
+	"upper":     "String*",
+	"lower":     "String*",
+	"toInt":     "int",
+	"toString":  "String*",
+}
+
+// stringArrayMethods are the StringArray methods provided by the C runtime
+var stringArrayMethods = map[string]string{
+	"push":   "void",
+	"get":    "String*",
+	"length": "int",
+}
+
+func registerTypes(nodes []parser.Node) {
+	customTypes = map[string]string{"StringArray": "runtime"}
+	selfEscapes = map[string]bool{}
+	functionTypes = map[string]*parser.Prototype{}
+	structTypes = map[string]*parser.Struct{}
+
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			functionTypes[function.Prototype.Name] = function.Prototype
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			customTypes[str.Name] = "struct"
+			structTypes[str.Name] = str
+		}
+	}
+}
+
+// cType turns a value type from the parser into the C type
+// used to hold it, structs are always passed by pointer
+func cType(valueType string) string {
+	if isTypeStruct(valueType) || isTypeRuntime(valueType) {
+		return valueType + "*"
+	}
+	return valueType
+}
+
+// typeName is the name used to prefix methods of a C type,
+// e.g. int -> Int__toString, Person* -> Person__toString
+func typeName(valueType string) string {
+	switch valueType {
+	case "int":
+		return "Int"
+	case "bool":
+		return "Bool"
+	default:
+		return strings.Trim(valueType, "*")
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TypeOf returns the type of an expression as it's written in
+// source, e.g. Int or Person. variables are the names in scope
+// mapped to their types from the parser.
+func TypeOf(nodes []parser.Node, variables map[string]string, expression parser.Expression) string {
+	registerTypes(nodes)
+	functionVariables := map[string]string{}
+	for name, valueType := range variables {
+		functionVariables[name] = cType(valueType)
+	}
+	return typeName(expressionValueType(expression, &functionVariables))
+}
+
+func isTypeRuntime(valueType string) bool {
+	val, ok := customTypes[valueType]
+	return ok && val == "runtime"
+}
+
+func methodType(targetType string, method string) string {
+	name := typeName(targetType)
+
+	if str, ok := structTypes[name]; ok {
+		for _, function := range str.Functions {
+			if function.Prototype.Name == method {
+				return cType(function.Prototype.ReturnType)
+			}
+		}
+	}
+
+	methods := map[string]string{}
+	switch name {
+	case "String":
+		methods = stringMethods
+	case "StringArray":
+		methods = stringArrayMethods
+	case "Int", "Bool":
+		methods = map[string]string{"toString": "String*"}
+	}
+	if returnType, ok := methods[method]; ok {
+		return returnType
+	}
+
+	msg := fmt.Sprintf("Unknown method: %s.%s", name, method)
+	panic(msg)
+}
+
+func propType(targetType string, prop string) string {
+	name := typeName(targetType)
+	str, ok := structTypes[name]
+	if !ok {
+		msg := fmt.Sprintf("Type has no props: %s", name)
+		panic(msg)
+	}
+
+	for _, p := range str.Props {
+		if p.Name == prop {
+			return cType(p.Type)
+		}
+	}
+
+	msg := fmt.Sprintf("Unknown prop: %s.%s", name, prop)
+	panic(msg)
+}
+
+func isPropWeak(targetType string, prop string) bool {
+	str, ok := structTypes[typeName(targetType)]
+	if !ok {
+		return false
+	}
+	for _, p := range str.Props {
+		if p.Name == prop {
+			return p.Weak
+		}
+	}
+	return false
+}
+
+// expressionValueType returns the C type an expression evaluates to
+func expressionValueType(expression parser.Expression, functionVariables *map[string]string) string {
+	switch expression.ExpressionType() {
+	case parser.ExpressionTypeInt:
+		return "int"
+	case parser.ExpressionTypeString, parser.ExpressionTypeInterpolatedString:
+		return "String*"
+	case parser.ExpressionTypeBool:
+		return "bool"
+	case parser.ExpressionTypeBinary:
+		exp := expression.(*parser.BinaryExpression)
+		if exp.Op.IsComparison() {
+			return "bool"
+		}
+		return expressionValueType(exp.LHS, functionVariables)
+	case parser.ExpressionTypeParen:
+		exp := expression.(*parser.ParenExpression)
+		return expressionValueType(exp.Expression, functionVariables)
+	case parser.ExpressionTypeCall:
+		exp := expression.(*parser.CallExpression)
+		if isBuiltin(exp.Callee) {
+			return "void"
+		}
+		if isTypeStruct(exp.Callee) || isTypeRuntime(exp.Callee) {
+			return cType(exp.Callee)
+		}
+		if prototype, ok := functionTypes[exp.Callee]; ok {
+			return cType(prototype.ReturnType)
+		}
+		msg := fmt.Sprintf("Calling undeclared function: %s", exp.Callee)
+		panic(msg)
+	case parser.ExpressionTypeVariable:
+		exp := expression.(*parser.VariableExpression)
+		val, ok := (*functionVariables)[exp.Name]
+		if !ok {
+			msg := fmt.Sprintf("Undeclared variable: %s", exp.Name)
+			panic(msg)
+		}
+		return val
+	case parser.ExpressionTypeVariableDeclaration:
+		exp := expression.(*parser.VariableDeclarationExpression)
+		return cType(exp.Type)
+	case parser.ExpressionTypeAssignment:
+		exp := expression.(*parser.AssignmentExpression)
+		return expressionValueType(exp.Expression, functionVariables)
+	case parser.ExpressionTypeAccessor:
+		exp := expression.(*parser.AccessorExpression)
+		targetType, ok := (*functionVariables)[exp.Target]
+		if !ok {
+			msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
+			panic(msg)
+		}
+		for _, name := range exp.Fields() {
+			targetType = propType(targetType, name)
+		}
+		switch inner := exp.Member().(type) {
+		case *parser.CallExpression:
+			return methodType(targetType, inner.Callee)
+		case *parser.VariableExpression:
+			return propType(targetType, inner.Name)
+		default:
+			panic("Invalid accessor expression")
+		}
+	case parser.ExpressionTypeReturn:
+		return "void"
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
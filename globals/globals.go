@@ -0,0 +1,187 @@
+// Package globals orders the initialisation of a program's globals.
+// A global is initialised after the globals its initialiser uses,
+// directly or through the fns and methods it calls, and otherwise in
+// the order it's declared, like Go's package variables. Globals that
+// depend on themselves, e.g.
+//
+//	var a = b + 1
+//	var b = f()
+//	fn f() Int { return a }
+//
+// can't be initialised and are reported as a cycle.
+package globals
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Sort returns a program's nodes with its globals first, in the
+// order they're initialised, followed by its fns and structs
+func Sort(nodes []parser.Node) []parser.Node {
+	s := &sorter{
+		globals:   map[string]*parser.Global{},
+		functions: map[string][]*parser.Function{},
+		methods:   map[string][]*parser.Function{},
+	}
+	declared := []*parser.Global{}
+	rest := []parser.Node{}
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+			name := n.Declaration.Name
+			if _, ok := s.globals[name]; ok {
+				msg := fmt.Sprintf("Global declared twice: %s", name)
+				panic(msg)
+			}
+			s.globals[name] = n
+			declared = append(declared, n)
+			continue
+		case *parser.Function:
+			s.functions[n.Prototype.Name] = append(s.functions[n.Prototype.Name], n)
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				name := method.Prototype.Name
+				s.methods[name] = append(s.methods[name], method)
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+		rest = append(rest, node)
+	}
+
+	dependencies := map[string]map[string]bool{}
+	for _, global := range declared {
+		expressions := []parser.Expression{global.Declaration.Expression}
+		dependencies[global.Declaration.Name] = s.uses(expressions, map[string]bool{})
+	}
+
+	// The earliest declared global whose dependencies are all
+	// initialised goes next
+	sorted := []parser.Node{}
+	initialised := map[string]bool{}
+	for len(sorted) < len(declared) {
+		var next *parser.Global
+		for _, global := range declared {
+			name := global.Declaration.Name
+			if !initialised[name] && ready(dependencies[name], initialised) {
+				next = global
+				break
+			}
+		}
+		if next == nil {
+			panic(cycle(declared, dependencies, initialised))
+		}
+		initialised[next.Declaration.Name] = true
+		sorted = append(sorted, next)
+	}
+	return append(sorted, rest...)
+}
+
+type sorter struct {
+	globals map[string]*parser.Global
+	// functions and methods are the fns and methods by name, calls
+	// are resolved without types so a method call could be to any
+	// struct's method with its name
+	functions map[string][]*parser.Function
+	methods   map[string][]*parser.Function
+}
+
+// uses finds the globals used by expressions, directly or through
+// the fns and methods they call. locals are the names declared
+// where the expressions are, which aren't globals.
+func (s *sorter) uses(expressions []parser.Expression, locals map[string]bool) map[string]bool {
+	used := map[string]bool{}
+	visited := map[*parser.Function]bool{}
+
+	var visit func(expressions []parser.Expression, locals map[string]bool)
+	call := func(functions []*parser.Function) {
+		for _, function := range functions {
+			if !visited[function] {
+				visited[function] = true
+				visit(function.Expressions, functionLocals(function))
+			}
+		}
+	}
+	use := func(name string, locals map[string]bool) {
+		if _, ok := s.globals[name]; ok && !locals[name] {
+			used[name] = true
+		}
+	}
+	visit = func(expressions []parser.Expression, locals map[string]bool) {
+		parser.Inspect(expressions, func(expression parser.Expression) {
+			switch exp := expression.(type) {
+			case *parser.VariableExpression:
+				use(exp.Name, locals)
+			case *parser.AssignmentExpression:
+				use(exp.Target.Name, locals)
+			case *parser.CallExpression:
+				call(s.functions[exp.Callee])
+			case *parser.AccessorExpression:
+				use(exp.Target, locals)
+				if method, ok := exp.Member().(*parser.CallExpression); ok {
+					call(s.methods[method.Callee])
+				}
+			}
+		})
+	}
+
+	visit(expressions, locals)
+	return used
+}
+
+// functionLocals are the names of a fn's params and variables, and
+// self, variables are fn scoped so they hide globals everywhere in it
+func functionLocals(function *parser.Function) map[string]bool {
+	locals := map[string]bool{"self": true}
+	for _, prop := range function.Prototype.Props {
+		locals[prop.Name] = true
+	}
+	parser.Inspect(function.Expressions, func(expression parser.Expression) {
+		if declaration, ok := expression.(*parser.VariableDeclarationExpression); ok {
+			locals[declaration.Name] = true
+		}
+	})
+	return locals
+}
+
+func ready(dependencies map[string]bool, initialised map[string]bool) bool {
+	for name := range dependencies {
+		if !initialised[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// cycle describes a cycle between the globals that can't be
+// initialised, each has a dependency that isn't initialised either.
+// It's followed from the earliest declared until it repeats.
+func cycle(declared []*parser.Global, dependencies map[string]map[string]bool, initialised map[string]bool) string {
+	path := []string{}
+	seen := map[string]int{}
+	for _, global := range declared {
+		if !initialised[global.Declaration.Name] {
+			path = append(path, global.Declaration.Name)
+			break
+		}
+	}
+	for {
+		name := path[len(path)-1]
+		if start, ok := seen[name]; ok {
+			return fmt.Sprintf("Initialization cycle: %s", strings.Join(path[start:], " -> "))
+		}
+		seen[name] = len(path) - 1
+		// Dependencies are followed in the order they're declared
+		// so the cycle reported doesn't change between runs
+		for _, global := range declared {
+			next := global.Declaration.Name
+			if dependencies[name][next] && !initialised[next] {
+				path = append(path, next)
+				break
+			}
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/alexmarchant/compiler
 
-go 1.12
+go 1.16
 
 require (
 	github.com/sanity-io/litter v1.1.0
@@ -0,0 +1,572 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// The interpreter runs the AST directly, without a C compiler.
+// It follows the C backend's semantics, Ints are 32 bit and wrap,
+// structs are references and runtime errors print the same
+// messages, so it's a reference to test the backends against.
+
+// Value is an Int (int32), Bool (bool), String (*String),
+// StringArray (*StringArray) or struct (*Object). Strings and
+// objects are nil until assigned, like the NULL pointers of
+// the C backend.
+type Value interface{}
+
+// String is a reference to an immutable string
+type String struct {
+	Value string
+}
+
+// Object is an instance of a struct
+type Object struct {
+	Type   *parser.Struct
+	Fields map[string]Value
+}
+
+// exit unwinds the interpreter when the program exits early
+type exit struct {
+	code int
+}
+
+// Interpreter holds the declarations of a program
+type Interpreter struct {
+	Functions map[string]*parser.Function
+	Structs   map[string]*parser.Struct
+	// Globals holds the values of the globals, which are
+	// initialised by Run before it calls main
+	Globals Frame
+	Stdout  io.Writer
+
+	globals []*parser.Global
+	// depth is the number of function calls running
+	depth int
+	// tailCalls are the calls that replace the call making them,
+	// see flow.TailCalls
+	tailCalls map[*parser.CallExpression]bool
+}
+
+// tailCall is the value of a call in tailCalls, callFunction makes
+// it once the function making it has returned
+type tailCall struct {
+	function *parser.Function
+	args     []Value
+}
+
+// maxDepth stops runaway recursion before it overflows the Go stack,
+// each call uses a few Go frames per expression it's nested in
+const maxDepth = 1 << 17
+
+// Frame holds the variables of a function call
+type Frame map[string]Value
+
+// New ...
+func New(nodes []parser.Node) *Interpreter {
+	in := &Interpreter{
+		Functions: map[string]*parser.Function{},
+		Structs:   map[string]*parser.Struct{},
+		Globals:   Frame{},
+		Stdout:    os.Stdout,
+	}
+	in.Declare(nodes)
+	return in
+}
+
+// Declare adds functions, structs and globals, replacing any
+// functions and structs with the same name
+func (in *Interpreter) Declare(nodes []parser.Node) {
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeGlobal:
+			in.globals = append(in.globals, node.(*parser.Global))
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			in.Functions[function.Prototype.Name] = function
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			in.Structs[str.Name] = str
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+
+	declared := []parser.Node{}
+	for _, function := range in.Functions {
+		declared = append(declared, function)
+	}
+	for _, str := range in.Structs {
+		declared = append(declared, str)
+	}
+	in.tailCalls = flow.TailCalls(declared)
+}
+
+// Run calls main and returns the program's exit code. Errors
+// in the program panic, like the rest of the compiler.
+func (in *Interpreter) Run() (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(exit)
+			if !ok {
+				panic(r)
+			}
+			code = e.code
+		}
+	}()
+
+	// Globals are declared in the order they're initialised
+	for _, global := range in.globals {
+		in.Eval(global.Declaration, in.Globals)
+	}
+	in.globals = nil
+
+	result := in.Call("main", nil)
+	if value, ok := result.(int32); ok {
+		return int(value)
+	}
+	return 0
+}
+
+// Call runs a function with args, returning nil for void functions
+func (in *Interpreter) Call(name string, args []Value) Value {
+	function, ok := in.Functions[name]
+	if !ok {
+		msg := fmt.Sprintf("Calling undeclared function: %s", name)
+		panic(msg)
+	}
+	return in.callFunction(function, args)
+}
+
+// callFunction runs a function, then the functions it tail calls
+// in its place
+func (in *Interpreter) callFunction(function *parser.Function, args []Value) Value {
+	if in.depth == maxDepth {
+		panic("Stack overflow")
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	for {
+		props := function.Prototype.Props
+		if len(args) != len(props) {
+			msg := fmt.Sprintf(
+				"%s takes %d args, called with %d",
+				function.Prototype.Name, len(props), len(args))
+			panic(msg)
+		}
+
+		f := Frame{}
+		for i, prop := range props {
+			f[prop.Name] = args[i]
+		}
+		result, _ := in.block(function.Expressions, f)
+		call, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+		function, args = call.function, call.args
+	}
+}
+
+// Exec runs statements with the variables in f, returning the
+// value of the last one, or of the return that ended them
+func (in *Interpreter) Exec(expressions []parser.Expression, f Frame) Value {
+	var result Value
+	for _, expression := range expressions {
+		switch expression.(type) {
+		case *parser.ReturnExpression, *parser.IfExpression:
+			if returned, ok := in.block([]parser.Expression{expression}, f); ok {
+				return returned
+			}
+			result = nil
+		default:
+			result = in.statement(expression, f)
+		}
+	}
+	return result
+}
+
+// block runs expressions until one returns, the bool reports
+// whether the block returned
+func (in *Interpreter) block(expressions []parser.Expression, f Frame) (Value, bool) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			return in.Eval(exp.Expression, f), true
+		case *parser.IfExpression:
+			condition := in.Eval(exp.Condition, f).(bool)
+			body := exp.Else
+			if condition {
+				body = exp.Then
+			}
+			if result, returned := in.block(body, f); returned {
+				return result, true
+			}
+		default:
+			// Tail calls end the block, like returns
+			if call, ok := in.statement(expression, f).(*tailCall); ok {
+				return call, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// statement runs an expression in a block, assignments can only be
+// statements, like the IR they have no value
+func (in *Interpreter) statement(expression parser.Expression, f Frame) Value {
+	if exp, ok := expression.(*parser.AssignmentExpression); ok {
+		in.assign(exp, f)
+		return nil
+	}
+	return in.Eval(expression, f)
+}
+
+// Eval evaluates an expression with the variables in f
+func (in *Interpreter) Eval(expression parser.Expression, f Frame) Value {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		return int32(exp.Value)
+	case *parser.BoolExpression:
+		return exp.Value
+	case *parser.StringExpression:
+		return &String{Value: parser.Unescape(exp.Value)}
+	case *parser.InterpolatedStringExpression:
+		value := ""
+		for _, part := range exp.Parts {
+			value += in.toString(in.Eval(part, f)).Value
+		}
+		return &String{Value: value}
+	case *parser.BinaryExpression:
+		return binary(exp.Op, in.Eval(exp.LHS, f), in.Eval(exp.RHS, f))
+	case *parser.ParenExpression:
+		return in.Eval(exp.Expression, f)
+	case *parser.CallExpression:
+		return in.call(exp, f)
+	case *parser.VariableExpression:
+		if value, ok := f[exp.Name]; ok {
+			return value
+		}
+		value, ok := in.Globals[exp.Name]
+		if !ok {
+			msg := fmt.Sprintf("Undeclared variable: %s", exp.Name)
+			panic(msg)
+		}
+		return value
+	case *parser.VariableDeclarationExpression:
+		value := in.Eval(exp.Expression, f)
+		f[exp.Name] = value
+		return value
+	case *parser.AssignmentExpression:
+		msg := fmt.Sprintf("Expression has no value: %v", exp.ExpressionType())
+		panic(msg)
+	case *parser.AccessorExpression:
+		return in.accessor(exp, f)
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
+
+func (in *Interpreter) call(exp *parser.CallExpression, f Frame) Value {
+	args := []Value{}
+	for _, param := range exp.Params {
+		args = append(args, in.Eval(param, f))
+	}
+
+	if exp.Callee == "println" {
+		in.println(args)
+		return nil
+	}
+	if exp.Callee == "StringArray" {
+		return &StringArray{}
+	}
+	if str, ok := in.Structs[exp.Callee]; ok {
+		return newObject(str)
+	}
+	if in.tailCalls[exp] {
+		return &tailCall{function: in.Functions[exp.Callee], args: args}
+	}
+	return in.Call(exp.Callee, args)
+}
+
+// newObject zeroes the fields of a struct, like the allocators
+func newObject(str *parser.Struct) *Object {
+	object := &Object{Type: str, Fields: map[string]Value{}}
+	for _, prop := range str.Props {
+		object.Fields[prop.Name] = zero(prop.Type)
+	}
+	return object
+}
+
+// zero is the value of an unassigned prop of a type
+func zero(valueType string) Value {
+	switch valueType {
+	case "int":
+		return int32(0)
+	case "bool":
+		return false
+	case "String*":
+		return (*String)(nil)
+	case "StringArray":
+		return (*StringArray)(nil)
+	default:
+		return (*Object)(nil)
+	}
+}
+
+func (in *Interpreter) println(args []Value) {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, in.toString(arg).Value)
+	}
+	fmt.Fprintln(in.Stdout, strings.Join(parts, " "))
+}
+
+// Format converts a value to text the way println does
+func (in *Interpreter) Format(value Value) string {
+	return in.toString(value).Value
+}
+
+// toString converts a value the way println and interpolation do
+func (in *Interpreter) toString(value Value) *String {
+	switch val := value.(type) {
+	case *String:
+		if val == nil {
+			panic("Null String")
+		}
+		return val
+	case *Object:
+		result := in.method(val, "toString", nil)
+		str, ok := result.(*String)
+		if !ok || str == nil {
+			msg := fmt.Sprintf("%s.toString didn't return a String", val.Type.Name)
+			panic(msg)
+		}
+		return str
+	default:
+		return &String{Value: format(value)}
+	}
+}
+
+func format(value Value) string {
+	switch val := value.(type) {
+	case int32:
+		return fmt.Sprintf("%d", val)
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	default:
+		msg := fmt.Sprintf("Type can't be converted to a String: %T", value)
+		panic(msg)
+	}
+}
+
+func (in *Interpreter) accessor(exp *parser.AccessorExpression, f Frame) Value {
+	target := in.Eval(&parser.VariableExpression{Name: exp.Target}, f)
+	for _, name := range exp.Fields() {
+		target = objectTarget(target, name).Fields[name]
+	}
+
+	switch inner := exp.Member().(type) {
+	case *parser.CallExpression:
+		args := []Value{}
+		for _, param := range inner.Params {
+			args = append(args, in.Eval(param, f))
+		}
+		if in.tailCalls[inner] {
+			method := in.structMethod(target.(*Object), inner.Callee)
+			return &tailCall{function: method, args: append([]Value{target}, args...)}
+		}
+		return in.method(target, inner.Callee, args)
+	case *parser.VariableExpression:
+		object := objectTarget(target, inner.Name)
+		return object.Fields[inner.Name]
+	default:
+		panic("Invalid accessor expression")
+	}
+}
+
+// assign stores to a variable or the last of a chain of fields. Like
+// the lowering it reads the structs holding the fields, then the value
+// of a compound assignment's target, then the value assigned.
+func (in *Interpreter) assign(exp *parser.AssignmentExpression, f Frame) {
+	target := exp.Target
+	if len(target.Fields) == 0 {
+		variables := f
+		if _, ok := f[target.Name]; !ok {
+			variables = in.Globals
+		}
+		current, ok := variables[target.Name]
+		if !ok {
+			msg := fmt.Sprintf("Undeclared variable: %s", target.Name)
+			panic(msg)
+		}
+		value := in.Eval(exp.Expression, f)
+		if exp.Op != "" {
+			value = binary(exp.Op, current, value)
+		}
+		variables[target.Name] = value
+		return
+	}
+
+	holder := in.Eval(&parser.VariableExpression{Name: target.Name}, f)
+	last := len(target.Fields) - 1
+	for _, name := range target.Fields[:last] {
+		holder = objectTarget(holder, name).Fields[name]
+	}
+	name := target.Fields[last]
+	object := objectTarget(holder, name)
+	current := object.Fields[name]
+	value := in.Eval(exp.Expression, f)
+	if exp.Op != "" {
+		value = binary(exp.Op, current, value)
+	}
+	object.Fields[name] = value
+}
+
+func objectTarget(target Value, prop string) *Object {
+	object, ok := target.(*Object)
+	if !ok {
+		msg := fmt.Sprintf("Type has no props: %T", target)
+		panic(msg)
+	}
+	if object == nil {
+		msg := fmt.Sprintf("Accessing %s of a null struct", prop)
+		panic(msg)
+	}
+	if _, ok := object.Fields[prop]; !ok {
+		msg := fmt.Sprintf("Unknown prop: %s.%s", object.Type.Name, prop)
+		panic(msg)
+	}
+	return object
+}
+
+// method calls a method on a value, struct methods get the
+// object as self
+func (in *Interpreter) method(target Value, name string, args []Value) Value {
+	switch val := target.(type) {
+	case *Object:
+		return in.callFunction(in.structMethod(val, name), append([]Value{val}, args...))
+	case *String:
+		if val == nil {
+			msg := fmt.Sprintf("Calling %s on a null String", name)
+			panic(msg)
+		}
+		return in.stringMethod(val, name, args)
+	case *StringArray:
+		if val == nil {
+			msg := fmt.Sprintf("Calling %s on a null StringArray", name)
+			panic(msg)
+		}
+		return in.stringArrayMethod(val, name, args)
+	case int32, bool:
+		if name == "toString" && len(args) == 0 {
+			return &String{Value: format(val)}
+		}
+		msg := fmt.Sprintf("Unknown method: %T.%s", val, name)
+		panic(msg)
+	default:
+		msg := fmt.Sprintf("Unknown method: %T.%s", val, name)
+		panic(msg)
+	}
+}
+
+// structMethod is a struct's method as a function taking the
+// object as self
+func (in *Interpreter) structMethod(object *Object, name string) *parser.Function {
+	if object == nil {
+		msg := fmt.Sprintf("Calling %s on a null struct", name)
+		panic(msg)
+	}
+	for _, function := range object.Type.Functions {
+		if function.Prototype.Name != name {
+			continue
+		}
+		props := append([]*parser.Prop{{Name: "self", Type: object.Type.Name}}, function.Prototype.Props...)
+		return &parser.Function{
+			Prototype: &parser.Prototype{
+				Name:       object.Type.Name + "__" + name,
+				Props:      props,
+				ReturnType: function.Prototype.ReturnType,
+			},
+			Expressions: function.Expressions,
+		}
+	}
+	msg := fmt.Sprintf("Unknown method: %s.%s", object.Type.Name, name)
+	panic(msg)
+}
+
+func binary(op parser.BinaryOperator, lhs Value, rhs Value) Value {
+	switch l := lhs.(type) {
+	case int32:
+		r := rhs.(int32)
+		switch op {
+		case parser.BinaryOperatorPlus:
+			return l + r
+		case parser.BinaryOperatorMinus:
+			return l - r
+		case parser.BinaryOperatorMultiplication:
+			return l * r
+		case parser.BinaryOperatorDivision:
+			if r == 0 {
+				panic("Division by zero")
+			}
+			return l / r
+		case parser.BinaryOperatorModulo:
+			if r == 0 {
+				panic("Division by zero")
+			}
+			return l % r
+		}
+		return compare(op, int(l), int(r))
+	case *String:
+		r := rhs.(*String)
+		if l == nil || r == nil {
+			panic("Null String")
+		}
+		if op == parser.BinaryOperatorPlus {
+			return &String{Value: l.Value + r.Value}
+		}
+		return compare(op, strings.Compare(l.Value, r.Value), 0)
+	default:
+		// Bools and references are compared by identity
+		switch op {
+		case parser.BinaryOperatorEqual:
+			return lhs == rhs
+		case parser.BinaryOperatorNotEqual:
+			return lhs != rhs
+		}
+		msg := fmt.Sprintf("Invalid operator for %T: %s", lhs, op)
+		panic(msg)
+	}
+}
+
+func compare(op parser.BinaryOperator, l int, r int) bool {
+	switch op {
+	case parser.BinaryOperatorEqual:
+		return l == r
+	case parser.BinaryOperatorNotEqual:
+		return l != r
+	case parser.BinaryOperatorLessThan:
+		return l < r
+	case parser.BinaryOperatorGreaterThan:
+		return l > r
+	case parser.BinaryOperatorLessThanOrEqual:
+		return l <= r
+	case parser.BinaryOperatorGreaterThanOrEqual:
+		return l >= r
+	default:
+		msg := fmt.Sprintf("Invalid operator: %s", op)
+		panic(msg)
+	}
+}
@@ -0,0 +1,213 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The String and StringArray methods of runtime/string.c
+
+// StringArray is a growable list of Strings
+type StringArray struct {
+	Items []*String
+}
+
+// stringArgs checks the args of a runtime method
+func stringArgs(method string, args []Value, count int) {
+	if len(args) != count {
+		msg := fmt.Sprintf("%s takes %d args, called with %d", method, count, len(args))
+		panic(msg)
+	}
+}
+
+func stringArg(args []Value, i int) *String {
+	str, ok := args[i].(*String)
+	if !ok {
+		msg := fmt.Sprintf("Expected a String, got %T", args[i])
+		panic(msg)
+	}
+	if str == nil {
+		panic("Null String")
+	}
+	return str
+}
+
+func intArg(args []Value, i int) int {
+	val, ok := args[i].(int32)
+	if !ok {
+		msg := fmt.Sprintf("Expected an Int, got %T", args[i])
+		panic(msg)
+	}
+	return int(val)
+}
+
+func (in *Interpreter) stringMethod(self *String, method string, args []Value) Value {
+	value := self.Value
+
+	switch method {
+	case "concat":
+		stringArgs(method, args, 1)
+		return &String{Value: value + stringArg(args, 0).Value}
+	case "equals":
+		stringArgs(method, args, 1)
+		return value == stringArg(args, 0).Value
+	case "compare":
+		stringArgs(method, args, 1)
+		return int32(strings.Compare(value, stringArg(args, 0).Value))
+	case "length":
+		stringArgs(method, args, 0)
+		return int32(len(value))
+	case "substring":
+		stringArgs(method, args, 2)
+		return &String{Value: Substring(value, intArg(args, 0), intArg(args, 1))}
+	case "indexOf":
+		stringArgs(method, args, 1)
+		return int32(strings.Index(value, stringArg(args, 0).Value))
+	case "contains":
+		stringArgs(method, args, 1)
+		return strings.Contains(value, stringArg(args, 0).Value)
+	case "split":
+		stringArgs(method, args, 1)
+		parts := &StringArray{}
+		for _, part := range Split(value, stringArg(args, 0).Value) {
+			parts.Items = append(parts.Items, &String{Value: part})
+		}
+		return parts
+	case "join":
+		stringArgs(method, args, 1)
+		array, ok := args[0].(*StringArray)
+		if !ok || array == nil {
+			panic("join expects a StringArray")
+		}
+		parts := []string{}
+		for _, item := range array.Items {
+			parts = append(parts, item.Value)
+		}
+		return &String{Value: strings.Join(parts, value)}
+	case "trim":
+		stringArgs(method, args, 0)
+		return &String{Value: Trim(value)}
+	case "upper":
+		stringArgs(method, args, 0)
+		return &String{Value: Upper(value)}
+	case "lower":
+		stringArgs(method, args, 0)
+		return &String{Value: Lower(value)}
+	case "toInt":
+		stringArgs(method, args, 0)
+		return ToInt(value)
+	case "toString":
+		stringArgs(method, args, 0)
+		return self
+	default:
+		msg := fmt.Sprintf("Unknown method: String.%s", method)
+		panic(msg)
+	}
+}
+
+func (in *Interpreter) stringArrayMethod(self *StringArray, method string, args []Value) Value {
+	switch method {
+	case "push":
+		stringArgs(method, args, 1)
+		self.Items = append(self.Items, stringArg(args, 0))
+		return nil
+	case "get":
+		stringArgs(method, args, 1)
+		index := intArg(args, 0)
+		if index < 0 || index >= len(self.Items) {
+			fmt.Fprintf(in.Stdout, "Index out of range: %d\n", index)
+			panic(exit{code: 1})
+		}
+		return self.Items[index]
+	case "length":
+		stringArgs(method, args, 0)
+		return int32(len(self.Items))
+	default:
+		msg := fmt.Sprintf("Unknown method: StringArray.%s", method)
+		panic(msg)
+	}
+}
+
+// The helpers below follow runtime/string.c byte for byte, the
+// VM uses them too
+
+// Substring clamps its indexes to the string, so out of range
+// requests return a shorter (possibly empty) string
+func Substring(value string, start int, end int) string {
+	start = clamp(start, 0, len(value))
+	end = clamp(end, start, len(value))
+	return value[start:end]
+}
+
+// Split splits into bytes when separator is empty
+func Split(value string, separator string) []string {
+	if separator != "" {
+		return strings.Split(value, separator)
+	}
+	parts := []string{}
+	for i := 0; i < len(value); i++ {
+		parts = append(parts, value[i:i+1])
+	}
+	return parts
+}
+
+// Trim removes the whitespace C's isspace matches
+func Trim(value string) string {
+	return strings.Trim(value, cSpace)
+}
+
+// Upper only changes ASCII letters, like toupper
+func Upper(value string) string {
+	return mapASCII(value, 'a', 'z', 'A')
+}
+
+// Lower only changes ASCII letters, like tolower
+func Lower(value string) string {
+	return mapASCII(value, 'A', 'Z', 'a')
+}
+
+// ToInt parses a leading base 10 integer like strtol, returning
+// 0 when there isn't one
+func ToInt(value string) int32 {
+	value = strings.TrimLeft(value, cSpace)
+	negative := false
+	if value != "" && (value[0] == '-' || value[0] == '+') {
+		negative = value[0] == '-'
+		value = value[1:]
+	}
+	result := int64(0)
+	for i := 0; i < len(value) && value[i] >= '0' && value[i] <= '9'; i++ {
+		if result < 1<<40 {
+			result = result*10 + int64(value[i]-'0')
+		}
+	}
+	if negative {
+		result = -result
+	}
+	return int32(result)
+}
+
+// cSpace are the characters C's isspace matches
+const cSpace = " \t\n\v\f\r"
+
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// mapASCII moves the bytes between from and to so from maps to
+// target, leaving other bytes alone
+func mapASCII(value string, from byte, to byte, target byte) string {
+	bytes := []byte(value)
+	for i, b := range bytes {
+		if b >= from && b <= to {
+			bytes[i] = b - from + target
+		}
+	}
+	return string(bytes)
+}
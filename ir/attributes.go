@@ -0,0 +1,46 @@
+package ir
+
+import "fmt"
+
+// CheckAttributes reports the function attributes that can't be
+// honoured, whichever passes run afterwards and whichever backend
+// generates code: @inline on a function that's recursive, directly
+// or through others, which Inline never inlines, and @tailrec on one
+// whose recursive calls aren't all tail calls EliminateTailCalls can
+// turn into jumps. It panics with a message like the passes.
+func CheckAttributes(module *Module) {
+	reachable := reachableFunctions(module)
+	for _, function := range module.Functions {
+		if function.Inline && reachable[function.Name][function.Name] {
+			msg := fmt.Sprintf("Can't inline recursive function %s", function.Name)
+			panic(msg)
+		}
+		if function.TailRec {
+			checkTailRec(function, module, reachable)
+		}
+	}
+}
+
+// checkTailRec checks the calls a @tailrec function makes to itself,
+// or to functions that call it back, can be eliminated
+func checkTailRec(function *Function, module *Module, reachable map[string]map[string]bool) {
+	for _, block := range function.Blocks {
+		for position, instruction := range block.Instructions {
+			if instruction.Op != OpCall {
+				continue
+			}
+			callee := module.Function(instruction.Name)
+			if callee != function && !reachable[callee.Name][function.Name] {
+				continue
+			}
+			if !isTailCall(block, position) {
+				msg := fmt.Sprintf("@tailrec %s: call to %s isn't in tail position", function.Name, callee.Name)
+				panic(msg)
+			}
+			if !canJump(function, callee, instruction) {
+				msg := fmt.Sprintf("@tailrec %s: tail call to %s can't be eliminated", function.Name, callee.Name)
+				panic(msg)
+			}
+		}
+	}
+}
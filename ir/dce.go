@@ -0,0 +1,109 @@
+package ir
+
+import "fmt"
+
+// EliminateDeadCode removes the functions, methods and structs that
+// can't be reached from main, GlobalsInit or the exported functions,
+// or the globals' types, and the
+// runtime declarations only they called. Structs that are kept but
+// never created are marked Unconstructed. It returns the names of
+// what it removed, constructors as Type__make.
+func EliminateDeadCode(module *Module, exports ...string) []string {
+	functions := map[string]bool{}
+	structs := map[string]bool{}
+	constructed := map[string]bool{}
+	worklist := []*Function{}
+
+	var useType func(valueType Type)
+	useType = func(valueType Type) {
+		str := module.Struct(string(valueType))
+		if str == nil || structs[str.Name] {
+			return
+		}
+		structs[str.Name] = true
+		for _, field := range str.Fields {
+			useType(field.Type)
+		}
+	}
+	useFunction := func(name string) {
+		function := module.Function(name)
+		if function == nil || functions[name] {
+			return
+		}
+		functions[name] = true
+		worklist = append(worklist, function)
+	}
+
+	useFunction("main")
+	useFunction(GlobalsInit)
+	for _, global := range module.Globals {
+		useType(global.Type)
+	}
+	for _, name := range exports {
+		if module.Function(name) == nil {
+			msg := fmt.Sprintf("Exported function not found: %s", name)
+			panic(msg)
+		}
+		useFunction(name)
+	}
+
+	for len(worklist) > 0 {
+		function := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		useType(function.ReturnType)
+		for _, locals := range [][]*Local{function.Params, function.Locals} {
+			for _, local := range locals {
+				useType(local.Type)
+			}
+		}
+		for _, block := range function.Blocks {
+			for _, instruction := range block.Instructions {
+				useType(instruction.Type)
+				switch instruction.Op {
+				case OpCall:
+					useFunction(instruction.Name)
+				case OpNew:
+					useType(Type(instruction.Name))
+					constructed[instruction.Name] = true
+				}
+			}
+		}
+	}
+
+	removed := []string{}
+	keptStructs := []*Struct{}
+	for _, str := range module.Structs {
+		if !structs[str.Name] {
+			removed = append(removed, str.Name)
+			continue
+		}
+		if !constructed[str.Name] {
+			str.Unconstructed = true
+			removed = append(removed, str.Name+"__make")
+		}
+		keptStructs = append(keptStructs, str)
+	}
+	module.Structs = keptStructs
+
+	keptFunctions := []*Function{}
+	for _, function := range module.Functions {
+		if !functions[function.Name] {
+			removed = append(removed, function.Name)
+			continue
+		}
+		keptFunctions = append(keptFunctions, function)
+	}
+	module.Functions = keptFunctions
+
+	// Declarations are the runtime's, they aren't reported
+	keptDeclarations := []*Function{}
+	for _, function := range module.Declarations {
+		if functions[function.Name] {
+			keptDeclarations = append(keptDeclarations, function)
+		}
+	}
+	module.Declarations = keptDeclarations
+
+	return removed
+}
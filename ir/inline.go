@@ -0,0 +1,233 @@
+package ir
+
+import "fmt"
+
+// InlineThreshold is the most instructions a function can have to
+// be inlined without @inline
+var InlineThreshold = 12
+
+// Inline replaces calls to small functions and methods, and those
+// marked @inline, with a copy of their body. Recursive functions
+// and those marked @noinline are always called, CheckAttributes
+// reports recursive ones marked @inline.
+//
+// The callee's statements become part of the caller's, so the
+// temporaries they create live until the end of the statement
+// making the call. Its params are substituted by the call's args,
+// except references other than self, which the callee would have
+// kept alive, and params that are assigned to, which are stored
+// in locals like its variables.
+func Inline(module *Module) {
+	recursive := recursiveFunctions(module)
+	for _, caller := range module.Functions {
+		inlined := 0
+		// Blocks are inserted after the one being scanned, so the
+		// calls in the copied bodies are inlined too
+		for i := 0; i < len(caller.Blocks); i++ {
+			block := caller.Blocks[i]
+			for position, instruction := range block.Instructions {
+				if instruction.Op != OpCall {
+					continue
+				}
+				callee := module.Function(instruction.Name)
+				if !shouldInline(callee, recursive) {
+					continue
+				}
+				prefix := fmt.Sprintf("%s.%d", callee.Name, inlined)
+				inlined++
+				blocks := inlineCall(caller, block, position, callee, prefix)
+				caller.Blocks = append(caller.Blocks[:i+1], append(blocks, caller.Blocks[i+1:]...)...)
+				break
+			}
+		}
+	}
+}
+
+func shouldInline(callee *Function, recursive map[string]bool) bool {
+	if callee == nil || len(callee.Blocks) == 0 {
+		return false
+	}
+	if callee.NoInline || recursive[callee.Name] {
+		return false
+	}
+	return callee.Inline || size(callee) <= InlineThreshold
+}
+
+// size counts the instructions a function would add to its callers
+func size(function *Function) int {
+	count := 0
+	for _, block := range function.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction.Op != OpEndStatement {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// recursiveFunctions finds the functions that can call themselves,
+// directly or through others
+func recursiveFunctions(module *Module) map[string]bool {
+	recursive := map[string]bool{}
+	for name, callees := range reachableFunctions(module) {
+		if callees[name] {
+			recursive[name] = true
+		}
+	}
+	return recursive
+}
+
+// reachableFunctions maps the name of each function to the
+// functions it can end up calling
+func reachableFunctions(module *Module) map[string]map[string]bool {
+	calls := map[string][]string{}
+	for _, function := range module.Functions {
+		for _, block := range function.Blocks {
+			for _, instruction := range block.Instructions {
+				if instruction.Op == OpCall {
+					calls[function.Name] = append(calls[function.Name], instruction.Name)
+				}
+			}
+		}
+	}
+
+	reachable := map[string]map[string]bool{}
+	for _, function := range module.Functions {
+		visited := map[string]bool{}
+		worklist := append([]string{}, calls[function.Name]...)
+		for len(worklist) > 0 {
+			name := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			if !visited[name] {
+				visited[name] = true
+				worklist = append(worklist, calls[name]...)
+			}
+		}
+		reachable[function.Name] = visited
+	}
+	return reachable
+}
+
+// inlineCall replaces the call at position in block with a jump to
+// a copy of the callee's blocks, which continue in a block holding
+// the instructions after the call. It returns the new blocks.
+func inlineCall(caller *Function, block *Block, position int, callee *Function, prefix string) []*Block {
+	call := block.Instructions[position]
+	rest := append([]*Instruction{}, block.Instructions[position+1:]...)
+	block.Instructions = block.Instructions[:position]
+	cont := &Block{Name: prefix + ".cont", Instructions: rest}
+
+	newLocal := func(local *Local) *Local {
+		copy := &Local{Name: prefix + "." + local.Name, Type: local.Type}
+		caller.Locals = append(caller.Locals, copy)
+		return copy
+	}
+
+	assigned := map[*Local]bool{}
+	for _, b := range callee.Blocks {
+		for _, instruction := range b.Instructions {
+			if instruction.Op == OpStore {
+				assigned[instruction.Local] = true
+			}
+		}
+	}
+
+	locals := map[*Local]*Local{}
+	args := map[*Local]*Instruction{}
+	for i, param := range callee.Params {
+		isSelf := i == 0 && callee.Receiver != ""
+		if !assigned[param] && (isSelf || !param.Type.IsRef()) {
+			args[param] = call.Args[i]
+			continue
+		}
+		locals[param] = newLocal(param)
+		store := caller.NewInstruction(OpStore, Void, call.Args[i])
+		store.Local = locals[param]
+		block.Instructions = append(block.Instructions, store)
+	}
+	for _, local := range callee.Locals {
+		locals[local] = newLocal(local)
+	}
+	// The returned value's local is named after a keyword so it
+	// can't clash with the callee's variables
+	var result *Local
+	if callee.ReturnType != Void {
+		result = newLocal(&Local{Name: "return", Type: callee.ReturnType})
+	}
+
+	// Instructions are copied before their args are mapped, a value
+	// may be defined in a block after the ones using it
+	blocks := map[*Block]*Block{}
+	copies := []*Block{}
+	for _, b := range callee.Blocks {
+		blocks[b] = &Block{Name: prefix + "." + b.Name}
+		copies = append(copies, blocks[b])
+	}
+	values := map[*Instruction]*Instruction{}
+	for _, b := range callee.Blocks {
+		copy := blocks[b]
+		for _, instruction := range b.Instructions {
+			switch {
+			case instruction.Op == OpEndStatement:
+			case instruction.Op == OpLoad && args[instruction.Local] != nil:
+				values[instruction] = args[instruction.Local]
+			case instruction.Op == OpReturn:
+				if result != nil {
+					store := caller.NewInstruction(OpStore, Void, instruction.Args[0])
+					store.Local = result
+					copy.Instructions = append(copy.Instructions, store)
+				}
+				jump := caller.NewInstruction(OpJump, Void)
+				jump.Targets = []*Block{cont}
+				copy.Instructions = append(copy.Instructions, jump)
+			default:
+				operands := append([]*Instruction{}, instruction.Args...)
+				clone := caller.NewInstruction(instruction.Op, instruction.Type, operands...)
+				clone.Local = locals[instruction.Local]
+				clone.Name = instruction.Name
+				clone.Const = instruction.Const
+				for _, target := range instruction.Targets {
+					clone.Targets = append(clone.Targets, blocks[target])
+				}
+				values[instruction] = clone
+				copy.Instructions = append(copy.Instructions, clone)
+			}
+		}
+	}
+	for _, copy := range copies {
+		for _, instruction := range copy.Instructions {
+			for i, arg := range instruction.Args {
+				if value, ok := values[arg]; ok {
+					instruction.Args[i] = value
+				}
+			}
+		}
+	}
+
+	jump := caller.NewInstruction(OpJump, Void)
+	jump.Targets = []*Block{copies[0]}
+	block.Instructions = append(block.Instructions, jump)
+
+	if result != nil {
+		load := caller.NewInstruction(OpLoad, callee.ReturnType)
+		load.Local = result
+		cont.Instructions = append([]*Instruction{load}, cont.Instructions...)
+		for _, b := range caller.Blocks {
+			replaceArg(b, call, load)
+		}
+		replaceArg(cont, call, load)
+	}
+
+	return append(copies, cont)
+}
+
+func replaceArg(block *Block, old *Instruction, value *Instruction) {
+	for _, instruction := range block.Instructions {
+		for i, arg := range instruction.Args {
+			if arg == old {
+				instruction.Args[i] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package ir is a typed intermediate representation between the
+// AST and the backends. Functions are control flow graphs of basic
+// blocks whose instructions are in SSA form, each defines at most
+// one value and is referred to by the instructions using it.
+//
+// Source variables live in Locals, read and written with load and
+// store like LLVM before mem2reg. That keeps every variable holding
+// an object addressable, so the memory managers can use them as
+// roots, while the values computed from them are SSA.
+package ir
+
+import "fmt"
+
+// Type is the type of a value as it's written in source: Int,
+// Bool, String, StringArray or the name of a struct. Void is the
+// type of instructions that don't produce a value.
+type Type string
+
+// Int et al are the builtin Types
+const (
+	Void        Type = "Void"
+	Int         Type = "Int"
+	Bool        Type = "Bool"
+	String      Type = "String"
+	StringArray Type = "StringArray"
+)
+
+// IsRef reports whether values of the type are references to
+// objects managed by the runtime
+func (t Type) IsRef() bool {
+	return t != Void && t != Int && t != Bool
+}
+
+// Op ...
+type Op string
+
+// OpConst et al are the instructions of the IR, see Instruction
+const (
+	// OpConst is an Int, Bool or String literal held in Const
+	OpConst Op = "const"
+	// OpNull is a reference to no object, the zero value of references
+	OpNull Op = "null"
+	// OpLoad reads Local
+	OpLoad Op = "load"
+	// OpStore writes Args[0] to Local
+	OpStore Op = "store"
+	// OpLoadGlobal reads the global Name
+	OpLoadGlobal Op = "loadglobal"
+	// OpStoreGlobal writes Args[0] to the global Name
+	OpStoreGlobal Op = "storeglobal"
+	// OpGetField reads the field Name of the struct Args[0]
+	OpGetField Op = "getfield"
+	// OpSetField writes Args[1] to the field Name of the struct Args[0]
+	OpSetField Op = "setfield"
+	// OpNew allocates a zeroed struct or StringArray named Name
+	OpNew Op = "new"
+	// OpAdd et al take two Ints
+	OpAdd Op = "add"
+	OpSub Op = "sub"
+	OpMul Op = "mul"
+	OpDiv Op = "div"
+	OpRem Op = "rem"
+	// OpEqual and OpNotEqual compare two values of the same type,
+	// references are compared by identity
+	OpEqual    Op = "eq"
+	OpNotEqual Op = "ne"
+	// OpLess et al compare two Ints
+	OpLess         Op = "lt"
+	OpGreater      Op = "gt"
+	OpLessEqual    Op = "le"
+	OpGreaterEqual Op = "ge"
+	// OpNot negates a Bool
+	OpNot Op = "not"
+	// OpCall calls the function Name with Args
+	OpCall Op = "call"
+	// OpPrint prints Args, Ints, Bools or Strings, on one line
+	OpPrint Op = "print"
+	// OpEndStatement ends a source statement, the objects its
+	// temporaries refer to may be freed after it
+	OpEndStatement Op = "endstmt"
+	// OpBranch jumps to Targets[0] if Args[0] is true, otherwise to Targets[1]
+	OpBranch Op = "br"
+	// OpJump jumps to Targets[0]
+	OpJump Op = "jmp"
+	// OpReturn returns from the function, with Args[0] unless it returns Void
+	OpReturn Op = "ret"
+)
+
+// IsTerminator reports whether the op ends a basic block
+func (op Op) IsTerminator() bool {
+	return op == OpBranch || op == OpJump || op == OpReturn
+}
+
+// GlobalsInit is the function storing the globals' initial values
+// that aren't constants, backends call it before main
+const GlobalsInit = "globals__init"
+
+// Module is a whole program
+type Module struct {
+	Structs   []*Struct
+	Globals   []*Global
+	Functions []*Function
+	// Declarations are the runtime functions called by the
+	// module, they have no Blocks
+	Declarations []*Function
+}
+
+// Struct ...
+type Struct struct {
+	Name   string
+	Fields []*Field
+	// Unconstructed structs are never created by the module so
+	// they need no constructor, see EliminateDeadCode
+	Unconstructed bool
+}
+
+// Global is a variable outside of any function. It starts with the
+// Int or Bool in Init, or the zero value of its type when Init is
+// nil until GlobalsInit stores its value.
+type Global struct {
+	Name string
+	Type Type
+	Init interface{}
+}
+
+// Field ...
+type Field struct {
+	Name string
+	Type Type
+	// Weak fields don't keep their value alive
+	Weak bool
+}
+
+// Function ...
+type Function struct {
+	Name       string
+	Params     []*Local
+	ReturnType Type
+	// Locals are the variables declared in the function's body
+	Locals []*Local
+	// Blocks start with the entry block
+	Blocks []*Block
+	// Receiver is the struct a method was declared in, methods
+	// are named Struct__method and take self as their first param
+	Receiver string
+	// Inline and NoInline are set by @inline and @noinline,
+	// overriding Inline's size heuristic
+	Inline   bool
+	NoInline bool
+	// TailRec is set by @tailrec, EliminateTailCalls panics unless
+	// it can eliminate all of the function's recursive calls
+	TailRec bool
+
+	nextID int
+}
+
+// Local is a param or variable of a function
+type Local struct {
+	Name string
+	Type Type
+}
+
+// Block is a basic block, a list of instructions ending with
+// a terminator that is the only way control leaves it
+type Block struct {
+	Name         string
+	Instructions []*Instruction
+}
+
+// Instruction is an operation and the value it defines. Fields
+// other than Op and Type are only used by the ops that say so.
+type Instruction struct {
+	// ID numbers the instruction's value within its function,
+	// instructions without a value aren't numbered
+	ID   int
+	Op   Op
+	Type Type
+	Args []*Instruction
+	// Local is the variable of a load or store
+	Local *Local
+	// Name is the callee of a call, the field of a getfield or
+	// setfield, the global of a loadglobal or storeglobal, and the
+	// type created by new
+	Name string
+	// Const is the int, bool or string of a const
+	Const interface{}
+	// Targets are the blocks a br or jmp continues in
+	Targets []*Block
+}
+
+// Struct finds a struct by name
+func (m *Module) Struct(name string) *Struct {
+	for _, str := range m.Structs {
+		if str.Name == name {
+			return str
+		}
+	}
+	return nil
+}
+
+// Global finds a global by name
+func (m *Module) Global(name string) *Global {
+	for _, global := range m.Globals {
+		if global.Name == name {
+			return global
+		}
+	}
+	return nil
+}
+
+// Function finds a function or declaration by name
+func (m *Module) Function(name string) *Function {
+	for _, function := range m.Functions {
+		if function.Name == name {
+			return function
+		}
+	}
+	for _, function := range m.Declarations {
+		if function.Name == name {
+			return function
+		}
+	}
+	return nil
+}
+
+// Field finds a field by name
+func (s *Struct) Field(name string) *Field {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// NewInstruction creates an instruction numbered after the
+// function's others, it isn't added to any block
+func (f *Function) NewInstruction(op Op, valueType Type, args ...*Instruction) *Instruction {
+	instruction := &Instruction{Op: op, Type: valueType, Args: args}
+	if valueType != Void {
+		instruction.ID = f.nextID
+		f.nextID++
+	}
+	return instruction
+}
+
+// Terminator returns the last instruction of a block if it's a terminator
+func (b *Block) Terminator() *Instruction {
+	if len(b.Instructions) == 0 {
+		return nil
+	}
+	last := b.Instructions[len(b.Instructions)-1]
+	if !last.Op.IsTerminator() {
+		return nil
+	}
+	return last
+}
+
+// Predecessors maps each block of a function to the blocks
+// that can continue in it
+func (f *Function) Predecessors() map[*Block][]*Block {
+	predecessors := map[*Block][]*Block{}
+	for _, block := range f.Blocks {
+		if terminator := block.Terminator(); terminator != nil {
+			for _, target := range terminator.Targets {
+				predecessors[target] = append(predecessors[target], block)
+			}
+		}
+	}
+	return predecessors
+}
+
+// Uses maps each instruction of a function to the instructions
+// using its value
+func (f *Function) Uses() map[*Instruction][]*Instruction {
+	uses := map[*Instruction][]*Instruction{}
+	for _, block := range f.Blocks {
+		for _, instruction := range block.Instructions {
+			for _, arg := range instruction.Args {
+				uses[arg] = append(uses[arg], instruction)
+			}
+		}
+	}
+	return uses
+}
+
+// Value is how an instruction's value is written as an operand
+func (i *Instruction) Value() string {
+	return fmt.Sprintf("%%%d", i.ID)
+}
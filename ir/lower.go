@@ -0,0 +1,691 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// lowering builds a Module from the AST, checking the types of
+// everything it lowers. Errors panic like the parser's.
+type lowering struct {
+	module *Module
+	// structs are the program's structs by name
+	structs map[string]*parser.Struct
+	// functions are the prototypes of functions and methods,
+	// methods are named Struct__method
+	functions map[string]*parser.Prototype
+	// receivers map methods to their structs
+	receivers map[string]string
+
+	// function and block are where instructions are emitted
+	function *Function
+	block    *Block
+	// variables are the locals in scope by their source names
+	variables map[string]*Local
+	labels    int
+}
+
+// binaryOps are the instructions for operators on Ints
+var binaryOps = map[parser.BinaryOperator]Op{
+	parser.BinaryOperatorPlus:               OpAdd,
+	parser.BinaryOperatorMinus:              OpSub,
+	parser.BinaryOperatorMultiplication:     OpMul,
+	parser.BinaryOperatorDivision:           OpDiv,
+	parser.BinaryOperatorModulo:             OpRem,
+	parser.BinaryOperatorEqual:              OpEqual,
+	parser.BinaryOperatorNotEqual:           OpNotEqual,
+	parser.BinaryOperatorLessThan:           OpLess,
+	parser.BinaryOperatorGreaterThan:        OpGreater,
+	parser.BinaryOperatorLessThanOrEqual:    OpLessEqual,
+	parser.BinaryOperatorGreaterThanOrEqual: OpGreaterEqual,
+}
+
+// Lower turns a program into IR
+func Lower(nodes []parser.Node) *Module {
+	l := &lowering{
+		module:    &Module{},
+		structs:   map[string]*parser.Struct{},
+		functions: map[string]*parser.Prototype{},
+		receivers: map[string]string{},
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Function:
+			l.declareFunction(n.Prototype.Name, n.Prototype)
+		case *parser.Struct:
+			if _, ok := l.structs[n.Name]; ok {
+				msg := fmt.Sprintf("Struct declared twice: %s", n.Name)
+				panic(msg)
+			}
+			l.structs[n.Name] = n
+			for _, method := range n.Functions {
+				name := methodName(n.Name, method.Prototype.Name)
+				l.declareFunction(name, method.Prototype)
+				l.receivers[name] = n.Name
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if str, ok := node.(*parser.Struct); ok {
+			l.lowerStruct(str)
+		}
+	}
+	l.lowerGlobals(nodes)
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+		case *parser.Function:
+			l.lowerFunction(n.Prototype.Name, "", n)
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				l.lowerFunction(methodName(n.Name, method.Prototype.Name), n.Name, method)
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+
+	return l.module
+}
+
+// declareFunction rejects a fn or method declared twice, a later
+// one would replace the earlier where it's called
+func (l *lowering) declareFunction(name string, prototype *parser.Prototype) {
+	if _, ok := l.functions[name]; ok {
+		msg := fmt.Sprintf("Function declared twice: %s", name)
+		panic(msg)
+	}
+	l.functions[name] = prototype
+}
+
+func methodName(structName string, method string) string {
+	return fmt.Sprintf("%s__%s", structName, method)
+}
+
+// valueType converts a type from the parser, e.g. int or String*
+func (l *lowering) valueType(parserType string) Type {
+	switch parserType {
+	case "int":
+		return Int
+	case "bool":
+		return Bool
+	case "String*":
+		return String
+	case "void":
+		return Void
+	case "StringArray":
+		return StringArray
+	}
+	if _, ok := l.structs[parserType]; ok {
+		return Type(parserType)
+	}
+	msg := fmt.Sprintf("Unknown type: %s", parserType)
+	panic(msg)
+}
+
+func (l *lowering) lowerStruct(str *parser.Struct) {
+	irStruct := &Struct{Name: str.Name}
+	for _, prop := range str.Props {
+		irStruct.Fields = append(irStruct.Fields, &Field{
+			Name: prop.Name,
+			Type: l.valueType(prop.Type),
+			Weak: prop.Weak,
+		})
+	}
+	l.module.Structs = append(l.module.Structs, irStruct)
+}
+
+// lowerGlobals declares the globals in the order they're
+// initialised. Those initialised with an Int or Bool literal start
+// with it, the others are stored by the GlobalsInit function.
+func (l *lowering) lowerGlobals(nodes []parser.Node) {
+	l.function = &Function{Name: GlobalsInit, ReturnType: Void}
+	l.variables = map[string]*Local{}
+	l.labels = 0
+	l.start(&Block{Name: "entry"})
+
+	for _, node := range nodes {
+		declaration, ok := node.(*parser.Global)
+		if !ok {
+			continue
+		}
+		exp := declaration.Declaration
+		global := &Global{Name: exp.Name, Type: l.valueType(exp.Type)}
+		switch literal := exp.Expression.(type) {
+		case *parser.IntExpression:
+			expectType(Int, global.Type, exp.Name)
+			global.Init = literal.Value
+		case *parser.BoolExpression:
+			expectType(Bool, global.Type, exp.Name)
+			global.Init = literal.Value
+		default:
+			value := l.value(exp.Expression)
+			expectType(value.Type, global.Type, exp.Name)
+			l.emit(OpStoreGlobal, Void, value).Name = global.Name
+			l.emit(OpEndStatement, Void)
+		}
+		// Declared after its initialiser, which can't use it
+		l.module.Globals = append(l.module.Globals, global)
+	}
+
+	if len(l.block.Instructions) > 0 {
+		l.emit(OpReturn, Void)
+		l.module.Functions = append(l.module.Functions, l.function)
+	}
+}
+
+func (l *lowering) lowerFunction(name string, receiver string, function *parser.Function) {
+	l.function = &Function{
+		Name:       name,
+		ReturnType: l.valueType(function.Prototype.ReturnType),
+		Receiver:   receiver,
+		Inline:     function.HasAttribute("inline"),
+		NoInline:   function.HasAttribute("noinline"),
+		TailRec:    function.HasAttribute("tailrec"),
+	}
+	l.variables = map[string]*Local{}
+	l.labels = 0
+
+	if receiver != "" {
+		l.param("self", Type(receiver))
+	}
+	for _, prop := range function.Prototype.Props {
+		l.param(prop.Name, l.valueType(prop.Type))
+	}
+
+	l.start(&Block{Name: "entry"})
+	l.statements(function.Expressions)
+	if l.block.Terminator() == nil {
+		l.returnZero()
+	}
+
+	removeUnreachable(l.function)
+	l.module.Functions = append(l.module.Functions, l.function)
+}
+
+func (l *lowering) param(name string, valueType Type) {
+	local := &Local{Name: name, Type: valueType}
+	l.function.Params = append(l.function.Params, local)
+	l.variables[name] = local
+}
+
+// declare returns the local for a variable declaration. Variables
+// are in scope for the rest of the function, like C's locals,
+// redeclaring one with a new type gives it a new local.
+func (l *lowering) declare(name string, valueType Type) *Local {
+	if local, ok := l.variables[name]; ok && local.Type == valueType {
+		return local
+	}
+
+	unique := name
+	for i := 1; l.isLocal(unique); i++ {
+		unique = fmt.Sprintf("%s.%d", name, i)
+	}
+	local := &Local{Name: unique, Type: valueType}
+	l.function.Locals = append(l.function.Locals, local)
+	l.variables[name] = local
+	return local
+}
+
+func (l *lowering) isLocal(name string) bool {
+	for _, locals := range [][]*Local{l.function.Params, l.function.Locals} {
+		for _, local := range locals {
+			if local.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// load reads a variable, which is a local unless there's only a
+// global with its name
+func (l *lowering) load(name string) *Instruction {
+	if local, ok := l.variables[name]; ok {
+		load := l.emit(OpLoad, local.Type)
+		load.Local = local
+		return load
+	}
+	if global := l.module.Global(name); global != nil {
+		load := l.emit(OpLoadGlobal, global.Type)
+		load.Name = global.Name
+		return load
+	}
+	msg := fmt.Sprintf("Undeclared variable: %s", name)
+	panic(msg)
+}
+
+// store assigns a value to a local or global variable
+func (l *lowering) store(name string, value *Instruction) {
+	if local, ok := l.variables[name]; ok {
+		expectType(value.Type, local.Type, name)
+		l.emit(OpStore, Void, value).Local = local
+		return
+	}
+	if global := l.module.Global(name); global != nil {
+		expectType(value.Type, global.Type, name)
+		l.emit(OpStoreGlobal, Void, value).Name = global.Name
+		return
+	}
+	msg := fmt.Sprintf("Undeclared variable: %s", name)
+	panic(msg)
+}
+
+// start continues emitting in a new block
+func (l *lowering) start(block *Block) {
+	l.function.Blocks = append(l.function.Blocks, block)
+	l.block = block
+}
+
+func (l *lowering) emit(op Op, valueType Type, args ...*Instruction) *Instruction {
+	instruction := l.function.NewInstruction(op, valueType, args...)
+	l.block.Instructions = append(l.block.Instructions, instruction)
+	return instruction
+}
+
+func (l *lowering) constant(valueType Type, value interface{}) *Instruction {
+	instruction := l.emit(OpConst, valueType)
+	instruction.Const = value
+	return instruction
+}
+
+// jump ends the current block by continuing in target, unless
+// it has already returned
+func (l *lowering) jump(target *Block) {
+	if l.block.Terminator() != nil {
+		return
+	}
+	l.emit(OpJump, Void).Targets = []*Block{target}
+}
+
+func (l *lowering) label(name string) string {
+	return fmt.Sprintf("%s.%d", name, l.labels)
+}
+
+func (l *lowering) statements(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			l.lowerReturn(exp)
+		case *parser.IfExpression:
+			l.lowerIf(exp)
+		default:
+			l.expression(expression)
+			l.emit(OpEndStatement, Void)
+		}
+	}
+}
+
+func (l *lowering) lowerReturn(exp *parser.ReturnExpression) {
+	value := l.value(exp.Expression)
+	if l.function.ReturnType == Void {
+		msg := fmt.Sprintf("%s returns nothing, returning %s", l.function.Name, value.Type)
+		panic(msg)
+	}
+	expectType(value.Type, l.function.ReturnType, "return value of "+l.function.Name)
+	l.emit(OpReturn, Void, value)
+
+	// Statements after a return are lowered to check them, then
+	// dropped with the other unreachable blocks
+	l.start(&Block{Name: "dead"})
+}
+
+// returnZero ends a function that runs off the end of its body,
+// returning the zero value of its return type
+func (l *lowering) returnZero() {
+	switch l.function.ReturnType {
+	case Void:
+		l.emit(OpReturn, Void)
+	case Int:
+		l.emit(OpReturn, Void, l.constant(Int, 0))
+	case Bool:
+		l.emit(OpReturn, Void, l.constant(Bool, false))
+	default:
+		l.emit(OpReturn, Void, l.emit(OpNull, l.function.ReturnType))
+	}
+}
+
+func (l *lowering) lowerIf(exp *parser.IfExpression) {
+	condition := l.value(exp.Condition)
+	expectType(condition.Type, Bool, "if condition")
+
+	thenBlock := &Block{Name: l.label("then")}
+	endBlock := &Block{Name: l.label("endif")}
+	elseBlock := endBlock
+	if len(exp.Else) > 0 {
+		elseBlock = &Block{Name: l.label("else")}
+	}
+	l.labels++
+
+	l.emit(OpBranch, Void, condition).Targets = []*Block{thenBlock, elseBlock}
+	l.start(thenBlock)
+	l.statements(exp.Then)
+	l.jump(endBlock)
+
+	if len(exp.Else) > 0 {
+		l.start(elseBlock)
+		l.statements(exp.Else)
+		l.jump(endBlock)
+	}
+
+	l.start(endBlock)
+}
+
+// value lowers an expression that must produce a value
+func (l *lowering) value(expression parser.Expression) *Instruction {
+	value := l.expression(expression)
+	if value == nil {
+		msg := fmt.Sprintf("Expression has no value: %v", expression.ExpressionType())
+		panic(msg)
+	}
+	return value
+}
+
+// expression lowers an expression, returning the instruction
+// defining its value or nil for those without one
+func (l *lowering) expression(expression parser.Expression) *Instruction {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		return l.constant(Int, exp.Value)
+	case *parser.StringExpression:
+		return l.constant(String, parser.Unescape(exp.Value))
+	case *parser.InterpolatedStringExpression:
+		var value *Instruction
+		for _, part := range exp.Parts {
+			str := l.toString(l.value(part))
+			if value == nil {
+				value = str
+				continue
+			}
+			value = l.call("String__concat", value, str)
+		}
+		return value
+	case *parser.BoolExpression:
+		return l.constant(Bool, exp.Value)
+	case *parser.BinaryExpression:
+		return l.binary(exp)
+	case *parser.CallExpression:
+		return l.lowerCall(exp)
+	case *parser.ParenExpression:
+		return l.expression(exp.Expression)
+	case *parser.VariableDeclarationExpression:
+		valueType := l.valueType(exp.Type)
+		value := l.value(exp.Expression)
+		expectType(value.Type, valueType, exp.Name)
+		l.emit(OpStore, Void, value).Local = l.declare(exp.Name, valueType)
+		return nil
+	case *parser.AssignmentExpression:
+		l.assign(exp)
+		return nil
+	case *parser.VariableExpression:
+		return l.load(exp.Name)
+	case *parser.AccessorExpression:
+		return l.accessor(exp)
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
+
+func expectType(actual Type, expected Type, context string) {
+	if actual != expected {
+		msg := fmt.Sprintf("Invalid type for %s: expected %s, got %s", context, expected, actual)
+		panic(msg)
+	}
+}
+
+func (l *lowering) binary(exp *parser.BinaryExpression) *Instruction {
+	lhs := l.value(exp.LHS)
+	rhs := l.value(exp.RHS)
+	return l.operator(exp.Op, lhs, rhs)
+}
+
+// operator applies a binary operator to two values
+func (l *lowering) operator(binaryOp parser.BinaryOperator, lhs *Instruction, rhs *Instruction) *Instruction {
+	if lhs.Type != rhs.Type {
+		msg := fmt.Sprintf("Invalid operands for %s: %s and %s", binaryOp, lhs.Type, rhs.Type)
+		panic(msg)
+	}
+	op := binaryOps[binaryOp]
+
+	switch {
+	case lhs.Type == Int && binaryOp.IsComparison():
+		return l.emit(op, Bool, lhs, rhs)
+	case lhs.Type == Int:
+		return l.emit(op, Int, lhs, rhs)
+	case lhs.Type == String && binaryOp == parser.BinaryOperatorPlus:
+		return l.call("String__concat", lhs, rhs)
+	case lhs.Type == String && op == OpEqual:
+		return l.call("String__equals", lhs, rhs)
+	case lhs.Type == String && op == OpNotEqual:
+		return l.emit(OpNot, Bool, l.call("String__equals", lhs, rhs))
+	case lhs.Type == String && binaryOp.IsComparison():
+		// Strings are ordered by comparing String__compare with 0
+		compare := l.call("String__compare", lhs, rhs)
+		return l.emit(op, Bool, compare, l.constant(Int, 0))
+	case op == OpEqual || op == OpNotEqual:
+		return l.emit(op, Bool, lhs, rhs)
+	default:
+		msg := fmt.Sprintf("Invalid operands for %s: %s and %s", binaryOp, lhs.Type, rhs.Type)
+		panic(msg)
+	}
+}
+
+func (l *lowering) lowerCall(exp *parser.CallExpression) *Instruction {
+	if exp.Callee == "println" {
+		args := []*Instruction{}
+		for _, param := range exp.Params {
+			value := l.value(param)
+			if value.Type != Int && value.Type != Bool {
+				value = l.toString(value)
+			}
+			args = append(args, value)
+		}
+		l.emit(OpPrint, Void, args...)
+		return nil
+	}
+
+	// Constructing a struct looks like a call
+	if _, ok := l.structs[exp.Callee]; ok || exp.Callee == string(StringArray) {
+		instruction := l.emit(OpNew, Type(exp.Callee))
+		instruction.Name = exp.Callee
+		return instruction
+	}
+
+	if _, ok := l.functions[exp.Callee]; !ok {
+		msg := fmt.Sprintf("Calling undeclared function: %s", exp.Callee)
+		panic(msg)
+	}
+	args := []*Instruction{}
+	for _, param := range exp.Params {
+		args = append(args, l.value(param))
+	}
+	return l.call(exp.Callee, args...)
+}
+
+// call calls a function of the program or the runtime, checking
+// the args match its params
+func (l *lowering) call(name string, args ...*Instruction) *Instruction {
+	params, returnType := l.signature(name)
+	if len(args) != len(params) {
+		msg := fmt.Sprintf("%s takes %d args, called with %d", name, len(params), len(args))
+		panic(msg)
+	}
+	for i, arg := range args {
+		expectType(arg.Type, params[i], fmt.Sprintf("arg %d of %s", i+1, name))
+	}
+
+	call := l.emit(OpCall, returnType, args...)
+	call.Name = name
+	if returnType == Void {
+		return nil
+	}
+	return call
+}
+
+// signature returns the param and return types of a function,
+// declaring it in the module if it's part of the runtime
+func (l *lowering) signature(name string) ([]Type, Type) {
+	params := []Type{}
+
+	if prototype, ok := l.functions[name]; ok {
+		if receiver, ok := l.receivers[name]; ok {
+			params = append(params, Type(receiver))
+		}
+		for _, prop := range prototype.Props {
+			params = append(params, l.valueType(prop.Type))
+		}
+		return params, l.valueType(prototype.ReturnType)
+	}
+
+	function, ok := runtimeFunctions[name]
+	if !ok {
+		msg := fmt.Sprintf("Calling undeclared function: %s", name)
+		panic(msg)
+	}
+	if l.module.Function(name) == nil {
+		l.module.Declarations = append(l.module.Declarations, function)
+	}
+	for _, param := range function.Params {
+		params = append(params, param.Type)
+	}
+	return params, function.ReturnType
+}
+
+// toString converts a value to a String with its toString method
+func (l *lowering) toString(value *Instruction) *Instruction {
+	switch value.Type {
+	case String:
+		return value
+	case StringArray, Void:
+		msg := fmt.Sprintf("Type can't be converted to a String: %s", value.Type)
+		panic(msg)
+	default:
+		return l.method(value, "toString", nil)
+	}
+}
+
+// method calls a method of value's type with self as the first arg
+func (l *lowering) method(self *Instruction, method string, params []parser.Expression) *Instruction {
+	name := methodName(string(self.Type), method)
+	_, declared := l.functions[name]
+	if _, ok := runtimeFunctions[name]; !ok && !declared {
+		msg := fmt.Sprintf("Unknown method: %s.%s", self.Type, method)
+		panic(msg)
+	}
+
+	args := []*Instruction{self}
+	for _, param := range params {
+		args = append(args, l.value(param))
+	}
+	return l.call(name, args...)
+}
+
+func (l *lowering) accessor(exp *parser.AccessorExpression) *Instruction {
+	if _, ok := l.variables[exp.Target]; !ok && l.module.Global(exp.Target) == nil {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
+		panic(msg)
+	}
+	target := l.load(exp.Target)
+	for _, name := range exp.Fields() {
+		target = l.getField(target, name)
+	}
+
+	switch inner := exp.Member().(type) {
+	case *parser.CallExpression:
+		return l.method(target, inner.Callee, inner.Params)
+	case *parser.VariableExpression:
+		return l.getField(target, inner.Name)
+	default:
+		panic("Invalid accessor expression")
+	}
+}
+
+// assign lowers an assignment to a variable or a chain of fields.
+// The structs holding the fields are read first, then the value of
+// a compound assignment's target, then the value assigned.
+func (l *lowering) assign(exp *parser.AssignmentExpression) {
+	target := exp.Target
+	if len(target.Fields) == 0 {
+		var current *Instruction
+		if exp.Op != "" {
+			current = l.load(target.Name)
+		}
+		value := l.value(exp.Expression)
+		if current != nil {
+			value = l.operator(exp.Op, current, value)
+		}
+		l.store(target.Name, value)
+		return
+	}
+
+	object := l.load(target.Name)
+	last := len(target.Fields) - 1
+	for _, name := range target.Fields[:last] {
+		object = l.getField(object, name)
+	}
+
+	field := l.field(object.Type, target.Fields[last])
+	var current *Instruction
+	if exp.Op != "" {
+		current = l.getField(object, field.Name)
+	}
+	value := l.value(exp.Expression)
+	if current != nil {
+		value = l.operator(exp.Op, current, value)
+	}
+	expectType(value.Type, field.Type, fmt.Sprintf("%s.%s", object.Type, field.Name))
+	l.emit(OpSetField, Void, object, value).Name = field.Name
+}
+
+func (l *lowering) getField(object *Instruction, name string) *Instruction {
+	field := l.field(object.Type, name)
+	get := l.emit(OpGetField, field.Type, object)
+	get.Name = field.Name
+	return get
+}
+
+func (l *lowering) field(structType Type, name string) *Field {
+	str := l.module.Struct(string(structType))
+	if str == nil {
+		msg := fmt.Sprintf("Type has no props: %s", structType)
+		panic(msg)
+	}
+	field := str.Field(name)
+	if field == nil {
+		msg := fmt.Sprintf("Unknown prop: %s.%s", structType, name)
+		panic(msg)
+	}
+	return field
+}
+
+// removeUnreachable drops the blocks control can't reach, e.g.
+// those after a return
+func removeUnreachable(function *Function) {
+	reachable := map[*Block]bool{}
+	var visit func(block *Block)
+	visit = func(block *Block) {
+		if reachable[block] {
+			return
+		}
+		reachable[block] = true
+		if terminator := block.Terminator(); terminator != nil {
+			for _, target := range terminator.Targets {
+				visit(target)
+			}
+		}
+	}
+	visit(function.Blocks[0])
+
+	blocks := []*Block{}
+	for _, block := range function.Blocks {
+		if reachable[block] {
+			blocks = append(blocks, block)
+		}
+	}
+	function.Blocks = blocks
+}
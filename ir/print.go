@@ -0,0 +1,121 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// String dumps a module as text, e.g.
+//
+//	fn add(a: Int, b: Int) Int {
+//	entry:
+//	  %0: Int = load a
+//	  %1: Int = load b
+//	  %2: Int = add %0, %1
+//	  ret %2
+//	}
+func (m *Module) String() string {
+	sections := []string{}
+
+	for _, str := range m.Structs {
+		code := fmt.Sprintf("struct %s {\n", str.Name)
+		for _, field := range str.Fields {
+			weak := ""
+			if field.Weak {
+				weak = "weak "
+			}
+			code += fmt.Sprintf("  %s%s: %s\n", weak, field.Name, field.Type)
+		}
+		code += "}\n"
+		sections = append(sections, code)
+	}
+
+	if len(m.Globals) > 0 {
+		code := ""
+		for _, global := range m.Globals {
+			code += fmt.Sprintf("global %s: %s", global.Name, global.Type)
+			if global.Init != nil {
+				code += fmt.Sprintf(" = %v", global.Init)
+			}
+			code += "\n"
+		}
+		sections = append(sections, code)
+	}
+
+	if len(m.Declarations) > 0 {
+		code := ""
+		for _, function := range m.Declarations {
+			code += fmt.Sprintf("declare %s\n", function.signature())
+		}
+		sections = append(sections, code)
+	}
+
+	for _, function := range m.Functions {
+		sections = append(sections, function.String())
+	}
+
+	return strings.Join(sections, "\n")
+}
+
+func (f *Function) signature() string {
+	params := []string{}
+	for _, param := range f.Params {
+		params = append(params, fmt.Sprintf("%s: %s", param.Name, param.Type))
+	}
+	return fmt.Sprintf("%s(%s) %s", f.Name, strings.Join(params, ", "), f.ReturnType)
+}
+
+func (f *Function) String() string {
+	code := fmt.Sprintf("fn %s {\n", f.signature())
+	for _, local := range f.Locals {
+		code += fmt.Sprintf("  var %s: %s\n", local.Name, local.Type)
+	}
+	for _, block := range f.Blocks {
+		code += block.Name + ":\n"
+		for _, instruction := range block.Instructions {
+			code += "  " + instruction.String() + "\n"
+		}
+	}
+	return code + "}\n"
+}
+
+func (i *Instruction) String() string {
+	operands := []string{}
+	switch i.Op {
+	case OpConst:
+		switch value := i.Const.(type) {
+		case string:
+			operands = append(operands, strconv.Quote(value))
+		default:
+			operands = append(operands, fmt.Sprint(value))
+		}
+	case OpLoad, OpStore:
+		operands = append(operands, i.Local.Name)
+	case OpNew, OpLoadGlobal, OpStoreGlobal:
+		operands = append(operands, i.Name)
+	}
+	for _, arg := range i.Args {
+		operands = append(operands, arg.Value())
+	}
+	switch i.Op {
+	case OpGetField, OpSetField:
+		// The field follows the struct it belongs to
+		operands = append(operands[:1], append([]string{i.Name}, operands[1:]...)...)
+	}
+	for _, target := range i.Targets {
+		operands = append(operands, target.Name)
+	}
+
+	code := string(i.Op)
+	if i.Op == OpCall {
+		code += fmt.Sprintf(" %s(%s)", i.Name, strings.Join(operands, ", "))
+	} else if len(operands) > 0 {
+		code += " " + strings.Join(operands, ", ")
+	}
+
+	if i.Type == Void {
+		return code
+	}
+	return fmt.Sprintf("%s: %s = %s", i.Value(), i.Type, code)
+}
@@ -0,0 +1,37 @@
+package ir
+
+// runtimeFunctions are the functions of runtime/string.h that
+// programs call, as the methods of String, StringArray, Int and
+// Bool or to implement operators
+var runtimeFunctions = map[string]*Function{}
+
+func init() {
+	declare := func(name string, returnType Type, params ...Type) {
+		function := &Function{Name: name, ReturnType: returnType}
+		names := []string{"self", "a", "b"}
+		for i, param := range params {
+			function.Params = append(function.Params, &Local{Name: names[i], Type: param})
+		}
+		runtimeFunctions[name] = function
+	}
+
+	declare("String__concat", String, String, String)
+	declare("String__equals", Bool, String, String)
+	declare("String__compare", Int, String, String)
+	declare("String__length", Int, String)
+	declare("String__substring", String, String, Int, Int)
+	declare("String__indexOf", Int, String, String)
+	declare("String__contains", Bool, String, String)
+	declare("String__split", StringArray, String, String)
+	declare("String__join", String, String, StringArray)
+	declare("String__trim", String, String)
+	declare("String__upper", String, String)
+	declare("String__lower", String, String)
+	declare("String__toInt", Int, String)
+	declare("String__toString", String, String)
+	declare("Int__toString", String, Int)
+	declare("Bool__toString", String, Bool)
+	declare("StringArray__push", Void, StringArray, String)
+	declare("StringArray__get", String, StringArray, Int)
+	declare("StringArray__length", Int, StringArray)
+}
@@ -0,0 +1,328 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EliminateTailCalls turns recursive calls whose result is returned
+// straight away into jumps, so recursion runs in constant stack
+// space. Calls a function makes to itself reassign its params and
+// loop back to its start. Functions that tail call each other are
+// merged into one function holding all their bodies, see
+// mergeTailCalls, so the calls become jumps between them.
+//
+// Only calls to functions taking the same params and returning the
+// same type are eliminated, and calls to methods only when they're
+// on the caller's own self, which the memory managers rely on the
+// caller keeping alive. CheckAttributes makes sure functions marked
+// @tailrec have all their recursive calls eliminated.
+func EliminateTailCalls(module *Module) {
+	reachable := reachableFunctions(module)
+	tailCalls := map[*Function][]*Instruction{}
+	// groups links the functions that tail call each other, directly
+	// or through others, group finds the one they're merged under
+	groups := map[*Function]*Function{}
+	group := func(function *Function) *Function {
+		for groups[function] != nil && groups[function] != function {
+			function = groups[function]
+		}
+		return function
+	}
+
+	for _, function := range module.Functions {
+		for _, block := range function.Blocks {
+			for position, instruction := range block.Instructions {
+				if instruction.Op != OpCall {
+					continue
+				}
+				callee := module.Function(instruction.Name)
+				if callee != function && !reachable[callee.Name][function.Name] {
+					continue
+				}
+
+				if isTailCall(block, position) && canJump(function, callee, instruction) {
+					tailCalls[function] = append(tailCalls[function], instruction)
+					if callee != function {
+						groups[group(callee)] = group(function)
+					}
+				}
+			}
+		}
+	}
+
+	members := map[*Function][]*Function{}
+	for _, function := range module.Functions {
+		if len(tailCalls[function]) > 0 || groups[function] != nil {
+			first := group(function)
+			members[first] = append(members[first], function)
+		}
+	}
+	for _, function := range module.Functions {
+		switch len(members[function]) {
+		case 0:
+		case 1:
+			loopTailCalls(function, tailCalls[function])
+		default:
+			mergeTailCalls(module, members[function], tailCalls)
+		}
+	}
+}
+
+// isTailCall reports whether the call at position in block is
+// followed by returning its result. Calls returning Void are
+// statements of their own, they're tail calls when the function
+// returns once they end, e.g. at the end of an if's body.
+func isTailCall(block *Block, position int) bool {
+	call := block.Instructions[position]
+	rest := block.Instructions[position+1:]
+	if call.Type != Void {
+		return len(rest) == 1 &&
+			rest[0].Op == OpReturn &&
+			len(rest[0].Args) == 1 &&
+			rest[0].Args[0] == call
+	}
+
+	if len(rest) > 0 && rest[0].Op == OpEndStatement {
+		rest = rest[1:]
+	}
+	visited := map[*Block]bool{}
+	for len(rest) == 1 && rest[0].Op == OpJump && !visited[rest[0].Targets[0]] {
+		visited[rest[0].Targets[0]] = true
+		rest = rest[0].Targets[0].Instructions
+	}
+	return len(rest) == 1 && rest[0].Op == OpReturn && len(rest[0].Args) == 0
+}
+
+// canJump reports whether a tail call can become a jump to the
+// start of the callee, reassigning the params it shares with the
+// caller. A method's self can't be reassigned, so calls to methods
+// must be on the caller's self.
+func canJump(function *Function, callee *Function, call *Instruction) bool {
+	if function.ReturnType != callee.ReturnType || len(function.Params) != len(callee.Params) {
+		return false
+	}
+	for i, param := range function.Params {
+		if callee.Params[i].Type != param.Type {
+			return false
+		}
+	}
+	if function.Receiver != callee.Receiver {
+		return false
+	}
+	if function.Receiver == "" {
+		return true
+	}
+
+	self := function.Params[0]
+	for _, block := range function.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction.Op == OpStore && instruction.Local == self {
+				return false
+			}
+		}
+	}
+	return call.Args[0].Op == OpLoad && call.Args[0].Local == self
+}
+
+// loopTailCalls turns the calls a function makes to itself into
+// jumps back to its start. The entry block can't be jumped to, so
+// a new one is added in front of it.
+func loopTailCalls(function *Function, calls []*Instruction) {
+	start := function.Blocks[0]
+	start.Name = "loop"
+	entry := &Block{Name: "entry"}
+	function.Blocks = append([]*Block{entry}, function.Blocks...)
+	jump(function, entry, start)
+
+	params := map[*Function][]*Local{function: function.Params}
+	starts := map[*Function]*Block{function: start}
+	for _, block := range function.Blocks {
+		replaceTailCall(function, block, calls, params, starts)
+	}
+}
+
+// mergeTailCalls moves the bodies of functions that tail call each
+// other into one function named after all of them, e.g.
+//
+//	fn even__odd__tailcalls(n: Int, tail.function: Int) Bool
+//
+// taking their params and the index of the function to run. Their
+// params and locals become its locals, prefixed by their names,
+// and they're left calling it.
+func mergeTailCalls(module *Module, functions []*Function, tailCalls map[*Function][]*Instruction) {
+	first := functions[0]
+	names := []string{}
+	for _, function := range functions {
+		names = append(names, function.Name)
+	}
+	merged := &Function{
+		Name:       strings.Join(names, "__") + "__tailcalls",
+		ReturnType: first.ReturnType,
+		Receiver:   first.Receiver,
+	}
+	for _, param := range first.Params {
+		merged.Params = append(merged.Params, &Local{Name: param.Name, Type: param.Type})
+	}
+	which := &Local{Name: "tail.function", Type: Int}
+	merged.Params = append(merged.Params, which)
+
+	// params are the locals each function's params become, a
+	// method's self is the merged function's
+	params := map[*Function][]*Local{}
+	starts := map[*Function]*Block{}
+	for _, function := range functions {
+		locals := map[*Local]*Local{}
+		for i, param := range function.Params {
+			if i == 0 && function.Receiver != "" {
+				locals[param] = merged.Params[0]
+				continue
+			}
+			locals[param] = &Local{Name: function.Name + "." + param.Name, Type: param.Type}
+			merged.Locals = append(merged.Locals, locals[param])
+		}
+		for _, local := range function.Locals {
+			locals[local] = &Local{Name: function.Name + "." + local.Name, Type: local.Type}
+			merged.Locals = append(merged.Locals, locals[local])
+		}
+		for _, param := range function.Params {
+			params[function] = append(params[function], locals[param])
+		}
+
+		for _, block := range function.Blocks {
+			block.Name = function.Name + "." + block.Name
+			for _, instruction := range block.Instructions {
+				if instruction.Local != nil {
+					instruction.Local = locals[instruction.Local]
+				}
+				if instruction.Type != Void {
+					instruction.ID = merged.nextID
+					merged.nextID++
+				}
+			}
+		}
+		starts[function] = function.Blocks[0]
+	}
+
+	// The entry block picks the body to run, which starts by
+	// copying the params to its locals
+	dispatch := &Block{Name: "entry"}
+	merged.Blocks = append(merged.Blocks, dispatch)
+	for i, function := range functions {
+		start := &Block{Name: function.Name + ".start"}
+		if i < len(functions)-1 {
+			next := &Block{Name: fmt.Sprintf("dispatch.%d", i+1)}
+			load := merged.NewInstruction(OpLoad, Int)
+			load.Local = which
+			index := merged.NewInstruction(OpConst, Int)
+			index.Const = i
+			equal := merged.NewInstruction(OpEqual, Bool, load, index)
+			branch := merged.NewInstruction(OpBranch, Void, equal)
+			branch.Targets = []*Block{start, next}
+			dispatch.Instructions = append(dispatch.Instructions, load, index, equal, branch)
+			merged.Blocks = append(merged.Blocks, start, next)
+			dispatch = next
+		} else {
+			jump(merged, dispatch, start)
+			merged.Blocks = append(merged.Blocks, start)
+		}
+
+		for n, param := range params[function] {
+			if n == 0 && function.Receiver != "" {
+				continue
+			}
+			load := merged.NewInstruction(OpLoad, param.Type)
+			load.Local = merged.Params[n]
+			store := merged.NewInstruction(OpStore, Void, load)
+			store.Local = param
+			start.Instructions = append(start.Instructions, load, store)
+		}
+		jump(merged, start, starts[function])
+	}
+
+	for _, function := range functions {
+		for _, block := range function.Blocks {
+			replaceTailCall(merged, block, tailCalls[function], params, starts)
+		}
+		merged.Blocks = append(merged.Blocks, function.Blocks...)
+	}
+	for i, function := range functions {
+		callMerged(function, merged, i)
+	}
+	module.Functions = append(module.Functions, merged)
+}
+
+// callMerged replaces the body of a function merged by
+// mergeTailCalls with a call to the merged function
+func callMerged(function *Function, merged *Function, index int) {
+	function.Locals = nil
+	function.nextID = 0
+
+	entry := &Block{Name: "entry"}
+	args := []*Instruction{}
+	for _, param := range function.Params {
+		load := function.NewInstruction(OpLoad, param.Type)
+		load.Local = param
+		args = append(args, load)
+	}
+	which := function.NewInstruction(OpConst, Int)
+	which.Const = index
+	args = append(args, which)
+	call := function.NewInstruction(OpCall, function.ReturnType, args...)
+	call.Name = merged.Name
+	entry.Instructions = append(append([]*Instruction{}, args...), call)
+
+	if function.ReturnType == Void {
+		end := function.NewInstruction(OpEndStatement, Void)
+		ret := function.NewInstruction(OpReturn, Void)
+		entry.Instructions = append(entry.Instructions, end, ret)
+	} else {
+		ret := function.NewInstruction(OpReturn, Void, call)
+		entry.Instructions = append(entry.Instructions, ret)
+	}
+	function.Blocks = []*Block{entry}
+}
+
+// replaceTailCall replaces a tail call ending block with
+// assignments to the callee's params and a jump to its start
+func replaceTailCall(function *Function, block *Block, calls []*Instruction, params map[*Function][]*Local, starts map[*Function]*Block) {
+	position := -1
+	for i, instruction := range block.Instructions {
+		for _, call := range calls {
+			if instruction == call {
+				position = i
+			}
+		}
+	}
+	if position < 0 {
+		return
+	}
+
+	call := block.Instructions[position]
+	var callee *Function
+	for candidate := range params {
+		if candidate.Name == call.Name {
+			callee = candidate
+		}
+	}
+
+	// The args are all computed before any param is reassigned
+	block.Instructions = block.Instructions[:position]
+	for i, param := range params[callee] {
+		if i == 0 && callee.Receiver != "" {
+			continue
+		}
+		store := function.NewInstruction(OpStore, Void, call.Args[i])
+		store.Local = param
+		block.Instructions = append(block.Instructions, store)
+	}
+	block.Instructions = append(block.Instructions, function.NewInstruction(OpEndStatement, Void))
+	jump(function, block, starts[callee])
+}
+
+// jump ends block with a jump to target
+func jump(function *Function, block *Block, target *Block) {
+	instruction := function.NewInstruction(OpJump, Void)
+	instruction.Targets = []*Block{target}
+	block.Instructions = append(block.Instructions, instruction)
+}
@@ -0,0 +1,402 @@
+package ir
+
+import "fmt"
+
+// Verify checks a module is well formed: blocks end with exactly
+// one terminator, values are defined before they're used, every
+// instruction's operands have the types it expects and no
+// reference outlives the statement that created it. Passes run it
+// after changing a module so backends can trust what they're given.
+func Verify(module *Module) error {
+	structs := map[string]bool{}
+	for _, str := range module.Structs {
+		if structs[str.Name] {
+			return fmt.Errorf("struct %s declared twice", str.Name)
+		}
+		structs[str.Name] = true
+	}
+	validType := func(t Type) bool {
+		switch t {
+		case Void, Int, Bool, String, StringArray:
+			return true
+		}
+		return structs[string(t)]
+	}
+
+	for _, str := range module.Structs {
+		for _, field := range str.Fields {
+			if !validType(field.Type) || field.Type == Void {
+				return fmt.Errorf("%s.%s: invalid type %s", str.Name, field.Name, field.Type)
+			}
+		}
+	}
+
+	globals := map[string]bool{}
+	for _, global := range module.Globals {
+		if globals[global.Name] {
+			return fmt.Errorf("global %s declared twice", global.Name)
+		}
+		globals[global.Name] = true
+		if !validType(global.Type) || global.Type == Void {
+			return fmt.Errorf("global %s: invalid type %s", global.Name, global.Type)
+		}
+		switch global.Init.(type) {
+		case nil:
+		case int:
+			if global.Type != Int {
+				return fmt.Errorf("global %s: %s initialised with an Int", global.Name, global.Type)
+			}
+		case bool:
+			if global.Type != Bool {
+				return fmt.Errorf("global %s: %s initialised with a Bool", global.Name, global.Type)
+			}
+		default:
+			return fmt.Errorf("global %s: invalid initial value %v", global.Name, global.Init)
+		}
+	}
+
+	functions := map[string]bool{}
+	for _, list := range [][]*Function{module.Declarations, module.Functions} {
+		for _, function := range list {
+			if functions[function.Name] {
+				return fmt.Errorf("function %s declared twice", function.Name)
+			}
+			functions[function.Name] = true
+			if !validType(function.ReturnType) {
+				return fmt.Errorf("%s: invalid return type %s", function.Name, function.ReturnType)
+			}
+		}
+	}
+
+	for _, function := range module.Functions {
+		v := &verifier{module: module, function: function, validType: validType}
+		if err := v.verify(); err != nil {
+			return fmt.Errorf("%s: %s", function.Name, err)
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	module    *Module
+	function  *Function
+	validType func(Type) bool
+
+	locals map[*Local]bool
+	blocks map[*Block]bool
+	// defined maps instructions to their blocks
+	defined    map[*Instruction]*Block
+	position   map[*Instruction]int
+	dominators map[*Block]map[*Block]bool
+}
+
+func (v *verifier) verify() error {
+	f := v.function
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("no blocks")
+	}
+
+	v.locals = map[*Local]bool{}
+	names := map[string]bool{}
+	for _, locals := range [][]*Local{f.Params, f.Locals} {
+		for _, local := range locals {
+			if names[local.Name] {
+				return fmt.Errorf("local %s declared twice", local.Name)
+			}
+			if !v.validType(local.Type) || local.Type == Void {
+				return fmt.Errorf("local %s: invalid type %s", local.Name, local.Type)
+			}
+			names[local.Name] = true
+			v.locals[local] = true
+		}
+	}
+
+	v.blocks = map[*Block]bool{}
+	v.defined = map[*Instruction]*Block{}
+	v.position = map[*Instruction]int{}
+	labels := map[string]bool{}
+	ids := map[int]bool{}
+	for _, block := range f.Blocks {
+		if labels[block.Name] {
+			return fmt.Errorf("block %s declared twice", block.Name)
+		}
+		labels[block.Name] = true
+		v.blocks[block] = true
+
+		for i, instruction := range block.Instructions {
+			if _, ok := v.defined[instruction]; ok {
+				return fmt.Errorf("%s: %s is in the function twice", block.Name, instruction)
+			}
+			if instruction.Type != Void {
+				if ids[instruction.ID] {
+					return fmt.Errorf("%s: %s defined twice", block.Name, instruction.Value())
+				}
+				ids[instruction.ID] = true
+			}
+			v.defined[instruction] = block
+			v.position[instruction] = i
+
+			last := i == len(block.Instructions)-1
+			if instruction.Op.IsTerminator() != last {
+				return fmt.Errorf("%s: must end with exactly one terminator", block.Name)
+			}
+		}
+		if len(block.Instructions) == 0 {
+			return fmt.Errorf("%s: empty block", block.Name)
+		}
+	}
+	if len(f.Predecessors()[f.Blocks[0]]) > 0 {
+		return fmt.Errorf("%s: entry block has predecessors", f.Blocks[0].Name)
+	}
+
+	v.dominators = dominators(f)
+	for _, block := range f.Blocks {
+		for _, instruction := range block.Instructions {
+			if err := v.verifyOperands(block, instruction); err != nil {
+				return fmt.Errorf("%s: %s: %s", block.Name, instruction, err)
+			}
+			if err := v.verifyInstruction(instruction); err != nil {
+				return fmt.Errorf("%s: %s: %s", block.Name, instruction, err)
+			}
+		}
+		if err := v.verifyStatements(block); err != nil {
+			return fmt.Errorf("%s: %s", block.Name, err)
+		}
+	}
+	return nil
+}
+
+// verifyOperands checks the instruction's args are defined where
+// it runs, and its targets and local belong to the function
+func (v *verifier) verifyOperands(block *Block, instruction *Instruction) error {
+	for _, arg := range instruction.Args {
+		defBlock, ok := v.defined[arg]
+		if !ok {
+			return fmt.Errorf("%s isn't defined in %s", arg.Value(), v.function.Name)
+		}
+		if arg.Type == Void {
+			return fmt.Errorf("%s has no value", arg.Value())
+		}
+		if defBlock == block && v.position[arg] >= v.position[instruction] {
+			return fmt.Errorf("%s is used before it's defined", arg.Value())
+		}
+		if !v.dominators[block][defBlock] {
+			return fmt.Errorf("%s isn't defined on every path to %s", arg.Value(), block.Name)
+		}
+	}
+	for _, target := range instruction.Targets {
+		if !v.blocks[target] {
+			return fmt.Errorf("%s isn't a block of %s", target.Name, v.function.Name)
+		}
+	}
+	if instruction.Local != nil && !v.locals[instruction.Local] {
+		return fmt.Errorf("%s isn't a local of %s", instruction.Local.Name, v.function.Name)
+	}
+	return nil
+}
+
+// verifyInstruction checks the types of an instruction
+func (v *verifier) verifyInstruction(i *Instruction) error {
+	if !v.validType(i.Type) {
+		return fmt.Errorf("invalid type %s", i.Type)
+	}
+	argTypes := []Type{}
+	for _, arg := range i.Args {
+		argTypes = append(argTypes, arg.Type)
+	}
+
+	switch i.Op {
+	case OpConst:
+		switch i.Const.(type) {
+		case int:
+			return expect(i, Int, nil, argTypes)
+		case bool:
+			return expect(i, Bool, nil, argTypes)
+		case string:
+			return expect(i, String, nil, argTypes)
+		default:
+			return fmt.Errorf("invalid constant %v", i.Const)
+		}
+	case OpNull:
+		if !i.Type.IsRef() {
+			return fmt.Errorf("%s can't be null", i.Type)
+		}
+		return expect(i, i.Type, nil, argTypes)
+	case OpLoad, OpStore:
+		if i.Local == nil {
+			return fmt.Errorf("missing local")
+		}
+		if i.Op == OpLoad {
+			return expect(i, i.Local.Type, nil, argTypes)
+		}
+		return expect(i, Void, []Type{i.Local.Type}, argTypes)
+	case OpLoadGlobal, OpStoreGlobal:
+		global := v.module.Global(i.Name)
+		if global == nil {
+			return fmt.Errorf("%s isn't a global", i.Name)
+		}
+		if i.Op == OpLoadGlobal {
+			return expect(i, global.Type, nil, argTypes)
+		}
+		return expect(i, Void, []Type{global.Type}, argTypes)
+	case OpGetField, OpSetField:
+		if len(i.Args) == 0 {
+			return fmt.Errorf("missing struct")
+		}
+		str := v.module.Struct(string(i.Args[0].Type))
+		if str == nil {
+			return fmt.Errorf("%s isn't a struct", i.Args[0].Type)
+		}
+		field := str.Field(i.Name)
+		if field == nil {
+			return fmt.Errorf("%s has no field %s", str.Name, i.Name)
+		}
+		if i.Op == OpGetField {
+			return expect(i, field.Type, nil, argTypes[1:])
+		}
+		return expect(i, Void, []Type{field.Type}, argTypes[1:])
+	case OpNew:
+		if i.Name != string(i.Type) || (i.Type != StringArray && v.module.Struct(i.Name) == nil) {
+			return fmt.Errorf("can't create %s", i.Name)
+		}
+		return expect(i, i.Type, nil, argTypes)
+	case OpAdd, OpSub, OpMul, OpDiv, OpRem:
+		return expect(i, Int, []Type{Int, Int}, argTypes)
+	case OpLess, OpGreater, OpLessEqual, OpGreaterEqual:
+		return expect(i, Bool, []Type{Int, Int}, argTypes)
+	case OpEqual, OpNotEqual:
+		if len(argTypes) != 2 {
+			return fmt.Errorf("expected 2 args, got %d", len(argTypes))
+		}
+		return expect(i, Bool, []Type{argTypes[0], argTypes[0]}, argTypes)
+	case OpNot:
+		return expect(i, Bool, []Type{Bool}, argTypes)
+	case OpCall:
+		callee := v.module.Function(i.Name)
+		if callee == nil {
+			return fmt.Errorf("calling undeclared function %s", i.Name)
+		}
+		params := []Type{}
+		for _, param := range callee.Params {
+			params = append(params, param.Type)
+		}
+		return expect(i, callee.ReturnType, params, argTypes)
+	case OpPrint:
+		for _, arg := range argTypes {
+			if arg != Int && arg != Bool && arg != String {
+				return fmt.Errorf("can't print %s", arg)
+			}
+		}
+		return expect(i, Void, argTypes, argTypes)
+	case OpEndStatement:
+		return expect(i, Void, nil, argTypes)
+	case OpBranch:
+		if len(i.Targets) != 2 {
+			return fmt.Errorf("expected 2 targets")
+		}
+		return expect(i, Void, []Type{Bool}, argTypes)
+	case OpJump:
+		if len(i.Targets) != 1 {
+			return fmt.Errorf("expected 1 target")
+		}
+		return expect(i, Void, nil, argTypes)
+	case OpReturn:
+		if v.function.ReturnType == Void {
+			return expect(i, Void, nil, argTypes)
+		}
+		return expect(i, Void, []Type{v.function.ReturnType}, argTypes)
+	default:
+		return fmt.Errorf("invalid op")
+	}
+}
+
+// expect checks an instruction's type and the types of its args
+func expect(i *Instruction, valueType Type, params []Type, args []Type) error {
+	if i.Type != valueType {
+		return fmt.Errorf("expected type %s, got %s", valueType, i.Type)
+	}
+	if len(params) != len(args) {
+		return fmt.Errorf("expected %d args, got %d", len(params), len(args))
+	}
+	for n, param := range params {
+		if args[n] != param {
+			return fmt.Errorf("arg %d: expected %s, got %s", n+1, param, args[n])
+		}
+	}
+	return nil
+}
+
+// verifyStatements checks references aren't used after the end of
+// the statement that created them, when the memory manager may
+// have freed their objects
+func (v *verifier) verifyStatements(block *Block) error {
+	ended := map[*Instruction]bool{}
+	live := []*Instruction{}
+	for _, instruction := range block.Instructions {
+		for _, arg := range instruction.Args {
+			if ended[arg] {
+				return fmt.Errorf("%s: %s is used after its statement ended", instruction, arg.Value())
+			}
+		}
+		switch {
+		case instruction.Op == OpEndStatement:
+			for _, value := range live {
+				ended[value] = true
+			}
+			live = nil
+		case instruction.Type.IsRef():
+			live = append(live, instruction)
+		}
+	}
+	return nil
+}
+
+// dominators maps each block to the blocks every path from the
+// entry block to it goes through, including itself
+func dominators(f *Function) map[*Block]map[*Block]bool {
+	predecessors := f.Predecessors()
+	all := map[*Block]bool{}
+	for _, block := range f.Blocks {
+		all[block] = true
+	}
+
+	result := map[*Block]map[*Block]bool{}
+	for i, block := range f.Blocks {
+		if i == 0 {
+			result[block] = map[*Block]bool{block: true}
+			continue
+		}
+		result[block] = all
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, block := range f.Blocks[1:] {
+			// Intersect the dominators of the block's predecessors
+			var next map[*Block]bool
+			for _, predecessor := range predecessors[block] {
+				if next == nil {
+					next = map[*Block]bool{}
+					for dominator := range result[predecessor] {
+						next[dominator] = true
+					}
+					continue
+				}
+				for dominator := range next {
+					if !result[predecessor][dominator] {
+						delete(next, dominator)
+					}
+				}
+			}
+			if next == nil {
+				next = map[*Block]bool{}
+			}
+			next[block] = true
+			if len(next) != len(result[block]) {
+				result[block] = next
+				changed = true
+			}
+		}
+	}
+	return result
+}
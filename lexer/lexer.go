@@ -11,62 +11,116 @@ type TokenType string
 
 // KeywordFun et all are TokenTypes
 const (
-	KeywordFn          TokenType = "KeywordFn"
-	KeywordReturn      TokenType = "KeywordReturn"
-	KeywordVar         TokenType = "KeywordVar"
-	KeywordStruct      TokenType = "KeywordStruct"
-	KeywordInt         TokenType = "KeywordInt"
-	KeywordIntArray    TokenType = "KeywordIntArray"
-	KeywordString      TokenType = "KeywordString"
-	Identifier         TokenType = "Identifier"
-	IntegerLiteral     TokenType = "IntegerLiteral"
-	StringLiteral      TokenType = "StringLiteral"
-	Period             TokenType = "Period"
-	Colon              TokenType = "Colon"
-	Equals             TokenType = "Equals"
-	OpeningParen       TokenType = "OpeningParen"
-	ClosingParen       TokenType = "ClosingParen"
-	OpeningCurlyBrace  TokenType = "OpeningCurlyBrace"
-	ClosingCurlyBrace  TokenType = "ClosingCurlyBrace"
-	LineBreak          TokenType = "LineBreak"
-	PlusSign           TokenType = "PlusSign"
-	MinusSign          TokenType = "MinusSign"
-	MultiplicationSign TokenType = "MultiplicationSign"
-	DivisionSign       TokenType = "DivisionSign"
-	EOF                TokenType = "EOF"
-	Comma              TokenType = "Comma"
-	OpeningBracket     TokenType = "OpeningBracket"
-	ClosingBracket     TokenType = "ClosingBracket"
+	KeywordFn            TokenType = "KeywordFn"
+	KeywordReturn        TokenType = "KeywordReturn"
+	KeywordVar           TokenType = "KeywordVar"
+	KeywordConst         TokenType = "KeywordConst"
+	KeywordLet           TokenType = "KeywordLet"
+	KeywordStruct        TokenType = "KeywordStruct"
+	KeywordIf            TokenType = "KeywordIf"
+	KeywordElse          TokenType = "KeywordElse"
+	KeywordWeak          TokenType = "KeywordWeak"
+	KeywordInt           TokenType = "KeywordInt"
+	KeywordIntArray      TokenType = "KeywordIntArray"
+	KeywordString        TokenType = "KeywordString"
+	KeywordBool          TokenType = "KeywordBool"
+	KeywordTrue          TokenType = "KeywordTrue"
+	KeywordFalse         TokenType = "KeywordFalse"
+	Identifier           TokenType = "Identifier"
+	IntegerLiteral       TokenType = "IntegerLiteral"
+	StringLiteral        TokenType = "StringLiteral"
+	Period               TokenType = "Period"
+	Colon                TokenType = "Colon"
+	Equals               TokenType = "Equals"
+	DoubleEquals         TokenType = "DoubleEquals"
+	NotEquals            TokenType = "NotEquals"
+	LessThan             TokenType = "LessThan"
+	GreaterThan          TokenType = "GreaterThan"
+	LessThanOrEqual      TokenType = "LessThanOrEqual"
+	GreaterThanOrEqual   TokenType = "GreaterThanOrEqual"
+	OpeningParen         TokenType = "OpeningParen"
+	ClosingParen         TokenType = "ClosingParen"
+	OpeningCurlyBrace    TokenType = "OpeningCurlyBrace"
+	ClosingCurlyBrace    TokenType = "ClosingCurlyBrace"
+	LineBreak            TokenType = "LineBreak"
+	PlusSign             TokenType = "PlusSign"
+	MinusSign            TokenType = "MinusSign"
+	MultiplicationSign   TokenType = "MultiplicationSign"
+	DivisionSign         TokenType = "DivisionSign"
+	ModuloSign           TokenType = "ModuloSign"
+	PlusEquals           TokenType = "PlusEquals"
+	MinusEquals          TokenType = "MinusEquals"
+	MultiplicationEquals TokenType = "MultiplicationEquals"
+	DivisionEquals       TokenType = "DivisionEquals"
+	ModuloEquals         TokenType = "ModuloEquals"
+	Increment            TokenType = "Increment"
+	Decrement            TokenType = "Decrement"
+	EOF                  TokenType = "EOF"
+	Comma                TokenType = "Comma"
+	OpeningBracket       TokenType = "OpeningBracket"
+	ClosingBracket       TokenType = "ClosingBracket"
+	At                   TokenType = "At"
+	Comment              TokenType = "Comment"
+	LintIgnore           TokenType = "LintIgnore"
 )
 
 func (t TokenType) tokenTypeRegex() string {
 	switch t {
 	case KeywordFn:
-		return "^fn"
+		return "^fn\\b"
 	case KeywordReturn:
-		return "^return"
+		return "^return\\b"
 	case KeywordVar:
-		return "^var"
+		return "^var\\b"
+	case KeywordConst:
+		return "^const\\b"
+	case KeywordLet:
+		return "^let\\b"
 	case KeywordStruct:
-		return "^struct"
+		return "^struct\\b"
+	case KeywordIf:
+		return "^if\\b"
+	case KeywordElse:
+		return "^else\\b"
+	case KeywordWeak:
+		return "^weak\\b"
 	case KeywordInt:
-		return "^Int"
+		return "^Int\\b"
 	case KeywordIntArray:
-		return "^IntArray"
+		return "^IntArray\\b"
 	case KeywordString:
-		return "^String"
+		return "^String\\b"
+	case KeywordBool:
+		return "^Bool\\b"
+	case KeywordTrue:
+		return "^true\\b"
+	case KeywordFalse:
+		return "^false\\b"
 	case Identifier:
 		return "^[a-zA-Z_]+"
 	case IntegerLiteral:
 		return "^\\d+"
 	case StringLiteral:
-		return "^\"(.*?)\""
+		// Only finds the start of a string, see stringLength
+		return "^\""
 	case Period:
 		return "^\\."
 	case Colon:
 		return "^:"
 	case Equals:
 		return "^="
+	case DoubleEquals:
+		return "^=="
+	case NotEquals:
+		return "^!="
+	case LessThan:
+		return "^<"
+	case GreaterThan:
+		return "^>"
+	case LessThanOrEqual:
+		return "^<="
+	case GreaterThanOrEqual:
+		return "^>="
 	case OpeningParen:
 		return "^\\("
 	case ClosingParen:
@@ -85,12 +139,34 @@ func (t TokenType) tokenTypeRegex() string {
 		return "^\\*"
 	case DivisionSign:
 		return "^\\/"
+	case ModuloSign:
+		return "^%"
+	case PlusEquals:
+		return "^\\+="
+	case MinusEquals:
+		return "^\\-="
+	case MultiplicationEquals:
+		return "^\\*="
+	case DivisionEquals:
+		return "^\\/="
+	case ModuloEquals:
+		return "^%="
+	case Increment:
+		return "^\\+\\+"
+	case Decrement:
+		return "^\\-\\-"
 	case Comma:
 		return "^,"
 	case OpeningBracket:
 		return "^\\["
 	case ClosingBracket:
 		return "^\\]"
+	case At:
+		return "^@"
+	case Comment:
+		return "^//[^\n]*"
+	case LintIgnore:
+		return "^//\\s*lint:ignore\\b[^\n]*"
 	default:
 		msg := fmt.Sprintf("Unrecognized token: %s", t)
 		panic(msg)
@@ -115,28 +191,53 @@ func parseTokens(source string) []Token {
 		KeywordFn,
 		KeywordReturn,
 		KeywordVar,
+		KeywordConst,
+		KeywordLet,
 		KeywordStruct,
+		KeywordIf,
+		KeywordElse,
+		KeywordWeak,
 		KeywordIntArray,
 		KeywordInt,
 		KeywordString,
+		KeywordBool,
+		KeywordTrue,
+		KeywordFalse,
 		Period,
 		Colon,
+		DoubleEquals,
+		NotEquals,
+		LessThanOrEqual,
+		GreaterThanOrEqual,
+		LessThan,
+		GreaterThan,
 		Equals,
 		OpeningParen,
 		ClosingParen,
 		OpeningCurlyBrace,
 		ClosingCurlyBrace,
 		LineBreak,
+		Increment,
+		Decrement,
+		PlusEquals,
+		MinusEquals,
+		MultiplicationEquals,
 		PlusSign,
 		MinusSign,
 		MultiplicationSign,
+		LintIgnore,
+		Comment,
+		DivisionEquals,
 		DivisionSign,
+		ModuloEquals,
+		ModuloSign,
 		IntegerLiteral,
 		StringLiteral,
 		Identifier,
 		Comma,
 		OpeningBracket,
 		ClosingBracket,
+		At,
 	}
 
 	for len(source) > 0 {
@@ -144,12 +245,15 @@ func parseTokens(source string) []Token {
 		found := false
 
 		for _, tokenType := range tokenTypes {
-			regex := tokenType.tokenTypeRegex()
-			if match, indexes := match(regex, source); match {
-				tokens = append(tokens, Token{
-					Type:   tokenType,
-					Source: source[indexes[0]:indexes[1]],
-				})
+			if match, indexes := matchToken(tokenType, source); match {
+				// Comments are dropped, except // lint:ignore
+				// directives, see lint
+				if tokenType != Comment {
+					tokens = append(tokens, Token{
+						Type:   tokenType,
+						Source: source[indexes[0]:indexes[1]],
+					})
+				}
 				source = source[indexes[1]:]
 				found = true
 				break
@@ -170,6 +274,65 @@ func parseTokens(source string) []Token {
 	return tokens
 }
 
+func matchToken(tokenType TokenType, source string) (bool, []int) {
+	if tokenType == StringLiteral {
+		length := stringLength(source)
+		return length > 0, []int{0, length}
+	}
+	return match(tokenType.tokenTypeRegex(), source)
+}
+
+// stringLength is the length of the string literal source starts
+// with, or 0 when it doesn't start with one. Escaped characters and
+// ${...} interpolations may contain quotes, an interpolation that
+// isn't closed is left for the parser to report.
+func stringLength(source string) int {
+	if !strings.HasPrefix(source, "\"") {
+		return 0
+	}
+	for i := 1; i < len(source); {
+		switch {
+		case source[i] == '\\':
+			i += 2
+		case strings.HasPrefix(source[i:], "${") && InterpolationLength(source[i:]) > 0:
+			i += InterpolationLength(source[i:])
+		case source[i] == '"':
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return 0
+}
+
+// InterpolationLength is the length of the ${...} interpolation
+// source starts with, up to the } matching its {, or 0 when it isn't
+// closed. Interpolations hold any expression, so the braces and
+// string literals in it are skipped.
+func InterpolationLength(source string) int {
+	depth := 0
+	for i := 1; i < len(source); {
+		switch source[i] {
+		case '"':
+			length := stringLength(source[i:])
+			if length == 0 {
+				return 0
+			}
+			i += length
+			continue
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+		i++
+	}
+	return 0
+}
+
 func match(regexString string, source string) (bool, []int) {
 	r, _ := regexp.Compile(regexString)
 	match := r.MatchString(source)
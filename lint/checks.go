@@ -0,0 +1,201 @@
+package lint
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+func init() {
+	Register(&Check{
+		Name:    "unused",
+		Default: true,
+		Run:     unused,
+	})
+	Register(&Check{
+		Name: "shadow",
+		Run:  shadow,
+	})
+	Register(&Check{
+		Name:    "selfassign",
+		Default: true,
+		Run:     selfAssign,
+	})
+	Register(&Check{
+		Name:    "constcond",
+		Default: true,
+		Run:     constantCondition,
+	})
+	Register(&Check{
+		Name: "naming",
+		Run:  naming,
+	})
+}
+
+// unused reports variables and params that are never used, unless
+// their names start with _
+func unused(pass *Pass) {
+	if pass.Function == nil {
+		return
+	}
+
+	used := map[string]bool{}
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		switch exp := expression.(type) {
+		case *parser.VariableExpression:
+			used[exp.Name] = true
+		case *parser.AccessorExpression:
+			used[exp.Target] = true
+		case *parser.AssignmentExpression:
+			// Compound assignments read the variable, as do
+			// assignments to its fields
+			if exp.Op != "" || len(exp.Target.Fields) > 0 {
+				used[exp.Target.Name] = true
+			}
+		}
+	})
+
+	for _, prop := range pass.Function.Prototype.Props {
+		if !used[prop.Name] && !strings.HasPrefix(prop.Name, "_") {
+			pass.ReportProp(prop, "param %s is never used", prop.Name)
+		}
+	}
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		declaration, ok := expression.(*parser.VariableDeclarationExpression)
+		if ok && !used[declaration.Name] && !strings.HasPrefix(declaration.Name, "_") {
+			pass.Report(declaration, "variable %s is never used", declaration.Name)
+		}
+	})
+}
+
+// shadow reports params and variables that hide a global, variables
+// are fn scoped so they hide it for the rest of the fn. Declaring a
+// name twice in a fn is an error, see mutability.
+func shadow(pass *Pass) {
+	if pass.Function == nil {
+		return
+	}
+
+	for _, prop := range pass.Function.Prototype.Props {
+		if pass.Globals[prop.Name] {
+			pass.ReportProp(prop, "param %s shadows a global", prop.Name)
+		}
+	}
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		declaration, ok := expression.(*parser.VariableDeclarationExpression)
+		if ok && pass.Globals[declaration.Name] {
+			pass.Report(declaration, "declaration of %s shadows a global", declaration.Name)
+		}
+	})
+}
+
+// selfAssign reports assignments of a variable or field to itself,
+// e.g. person.name = person.name
+func selfAssign(pass *Pass) {
+	if pass.Function == nil {
+		return
+	}
+
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		exp, ok := expression.(*parser.AssignmentExpression)
+		if ok && exp.Op == "" && path(exp.Expression) == exp.Target.String() {
+			pass.Report(exp, "self-assignment of %s", exp.Target)
+		}
+	})
+}
+
+// path is the variable or chain of fields an expression reads, e.g.
+// person.name, or empty for other expressions
+func path(expression parser.Expression) string {
+	switch exp := unparen(expression).(type) {
+	case *parser.VariableExpression:
+		return exp.Name
+	case *parser.AccessorExpression:
+		if member := path(exp.Expression); member != "" {
+			return exp.Target + "." + member
+		}
+	}
+	return ""
+}
+
+func unparen(expression parser.Expression) parser.Expression {
+	for {
+		paren, ok := expression.(*parser.ParenExpression)
+		if !ok {
+			return expression
+		}
+		expression = paren.Expression
+	}
+}
+
+// constantCondition reports ifs whose branch is known before the
+// program runs, like if 1 > 2 or if debug where debug is a const
+func constantCondition(pass *Pass) {
+	if pass.Function == nil {
+		return
+	}
+
+	consts := map[string]bool{}
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		if declaration, ok := expression.(*parser.VariableDeclarationExpression); ok {
+			consts[declaration.Name] = declaration.Const
+		}
+	})
+
+	var isConstant func(expression parser.Expression) bool
+	isConstant = func(expression parser.Expression) bool {
+		switch exp := expression.(type) {
+		case *parser.IntExpression, *parser.BoolExpression, *parser.StringExpression:
+			return true
+		case *parser.VariableExpression:
+			return consts[exp.Name]
+		case *parser.ParenExpression:
+			return isConstant(exp.Expression)
+		case *parser.BinaryExpression:
+			return isConstant(exp.LHS) && isConstant(exp.RHS)
+		default:
+			return false
+		}
+	}
+
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		if exp, ok := expression.(*parser.IfExpression); ok && isConstant(exp.Condition) {
+			pass.Report(exp, "if condition is constant")
+		}
+	})
+}
+
+var lowerCamelCase = regexp.MustCompile("^_?[a-z][a-zA-Z]*$|^_$")
+var upperCamelCase = regexp.MustCompile("^[A-Z][a-zA-Z]*$")
+
+// naming reports names that aren't lowerCamelCase, or UpperCamelCase
+// for structs
+func naming(pass *Pass) {
+	if pass.Function == nil {
+		if !upperCamelCase.MatchString(pass.Struct.Name) {
+			pass.Report(nil, "struct name %s should be UpperCamelCase", pass.Struct.Name)
+		}
+		for _, prop := range pass.Struct.Props {
+			if !lowerCamelCase.MatchString(prop.Name) {
+				pass.ReportProp(prop, "prop name %s should be lowerCamelCase", prop.Name)
+			}
+		}
+		return
+	}
+
+	if name := pass.Function.Prototype.Name; !lowerCamelCase.MatchString(name) {
+		pass.Report(nil, "fn name %s should be lowerCamelCase", name)
+	}
+	for _, prop := range pass.Function.Prototype.Props {
+		if !lowerCamelCase.MatchString(prop.Name) {
+			pass.ReportProp(prop, "param name %s should be lowerCamelCase", prop.Name)
+		}
+	}
+	parser.Inspect(pass.Function.Expressions, func(expression parser.Expression) {
+		declaration, ok := expression.(*parser.VariableDeclarationExpression)
+		if ok && !lowerCamelCase.MatchString(declaration.Name) {
+			pass.Report(declaration, "variable name %s should be lowerCamelCase", declaration.Name)
+		}
+	})
+}
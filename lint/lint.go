@@ -0,0 +1,198 @@
+// Package lint runs checks over a program's AST that warn about code
+// that's valid but probably not what was meant, e.g. variables that
+// are never used. Each check is registered by name and can be turned
+// on or off on its own. A // lint:ignore directive before a fn,
+// struct or statement turns the checks it names off there, e.g.
+//
+//	// lint:ignore unused,shadow kept for debugging
+//	var old: Int = count
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Check is a lint check, Run looks for problems in the fn or struct
+// of a pass and reports them
+type Check struct {
+	Name string
+	// Default checks run unless others are enabled
+	Default bool
+	Run     func(pass *Pass)
+}
+
+var checks = map[string]*Check{}
+
+// enabled are the checks Program runs, nil for the default ones
+var enabled map[string]bool
+
+// Register makes a check available by name
+func Register(check *Check) {
+	if _, ok := checks[check.Name]; ok {
+		msg := fmt.Sprintf("Lint check registered twice: %s", check.Name)
+		panic(msg)
+	}
+	checks[check.Name] = check
+}
+
+// Lookup ...
+func Lookup(name string) (*Check, error) {
+	check, ok := checks[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown lint check: %s", name)
+	}
+	return check, nil
+}
+
+// Names lists the registered checks in order
+func Names() []string {
+	names := []string{}
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enable chooses the checks Program runs by name, all for every
+// check and none for no checks
+func Enable(names []string) error {
+	enabled = map[string]bool{}
+	for _, name := range names {
+		switch name {
+		case "all":
+			for name := range checks {
+				enabled[name] = true
+			}
+		case "none", "":
+		default:
+			if _, err := Lookup(name); err != nil {
+				return err
+			}
+			enabled[name] = true
+		}
+	}
+	return nil
+}
+
+func isEnabled(check *Check) bool {
+	if enabled == nil {
+		return check.Default
+	}
+	return enabled[check.Name]
+}
+
+// Pass is a check being run on a fn or a struct's declaration
+type Pass struct {
+	Check *Check
+	// Name is how the fn or struct is reported, e.g. Person.greet
+	Name string
+	// Function is nil when checking a struct's name and props,
+	// Struct is the struct being checked or the one of a method
+	Function *parser.Function
+	Struct   *parser.Struct
+	// Globals are the names of the program's globals
+	Globals map[string]bool
+
+	warnings *[]string
+	ignored  bool
+	// ignoredIn are the expressions in statements the check is
+	// ignored in, including the statements nested in them
+	ignoredIn map[parser.Expression]bool
+}
+
+// Report warns about a problem in an expression, or in the
+// declaration of the fn or struct when it's nil
+func (p *Pass) Report(expression parser.Expression, format string, args ...interface{}) {
+	if p.ignored || (expression != nil && p.ignoredIn[expression]) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	*p.warnings = append(*p.warnings, fmt.Sprintf("%s in %s (%s)", msg, p.Name, p.Check.Name))
+}
+
+// ReportProp warns about a problem in a struct prop or a param
+func (p *Pass) ReportProp(prop *parser.Prop, format string, args ...interface{}) {
+	if ignores(prop.LintIgnore, p.Check) {
+		return
+	}
+	p.Report(nil, format, args...)
+}
+
+// Program runs the enabled checks on a program's fns, structs and
+// methods, returning its warnings
+func Program(nodes []parser.Node) []string {
+	warnings := []string{}
+	globals := map[string]bool{}
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			globals[global.Declaration.Name] = true
+		}
+	}
+	for _, name := range Names() {
+		check := checks[name]
+		if !isEnabled(check) {
+			continue
+		}
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *parser.Global:
+			case *parser.Function:
+				run(check, n.Prototype.Name, n, nil, globals, &warnings)
+			case *parser.Struct:
+				run(check, n.Name, nil, n, globals, &warnings)
+				for _, method := range n.Functions {
+					name := fmt.Sprintf("%s.%s", n.Name, method.Prototype.Name)
+					run(check, name, method, n, globals, &warnings)
+				}
+			default:
+				panic("Invalid NodeType")
+			}
+		}
+	}
+	return warnings
+}
+
+func run(check *Check, name string, function *parser.Function, str *parser.Struct, globals map[string]bool, warnings *[]string) {
+	pass := &Pass{
+		Check:     check,
+		Name:      name,
+		Function:  function,
+		Struct:    str,
+		Globals:   globals,
+		warnings:  warnings,
+		ignoredIn: map[parser.Expression]bool{},
+	}
+
+	if function == nil {
+		pass.ignored = ignores(str.LintIgnore, check)
+	} else {
+		pass.ignored = ignores(function.LintIgnore, check)
+		for statement, names := range function.StatementIgnores {
+			if ignores(names, check) {
+				parser.Inspect([]parser.Expression{statement}, func(expression parser.Expression) {
+					pass.ignoredIn[expression] = true
+				})
+			}
+		}
+	}
+	check.Run(pass)
+}
+
+// ignores reports whether a directive's names include check,
+// panicking on names that aren't checks
+func ignores(names []string, check *Check) bool {
+	found := false
+	for _, name := range names {
+		if _, err := Lookup(name); err != nil {
+			panic(err.Error())
+		}
+		if name == check.Name {
+			found = true
+		}
+	}
+	return found
+}
@@ -1,37 +1,417 @@
 package main
 
 import (
+	"embed"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/fold"
 	"github.com/alexmarchant/compiler/generator"
+	"github.com/alexmarchant/compiler/globals"
+	"github.com/alexmarchant/compiler/interp"
+	"github.com/alexmarchant/compiler/ir"
 	"github.com/alexmarchant/compiler/lexer"
+	"github.com/alexmarchant/compiler/lint"
+	"github.com/alexmarchant/compiler/mutability"
 	"github.com/alexmarchant/compiler/parser"
 	"github.com/sanity-io/litter"
 )
 
+// runtimeFS embeds the C runtime so programs can be built from any directory
+//
+//go:embed runtime/*.c runtime/*.h
+var runtimeFS embed.FS
+
+const usage = `Usage: compiler <command> [flags] file
+
+Commands:
+  build     compile a program to an executable
+  run       compile and run a program, passing it any args after the file,
+            interpret it with -interp or run it in the bytecode VM with
+            -vm, .bc files always run in the VM
+  check     report errors and warnings without compiling
+  emit      print the tokens, AST, variable types, IR, C, assembly or
+            LLVM IR for a program
+  bytecode  compile a program to a .bc file for the VM
+  disasm    print the bytecode for a program or .bc file
+  repl      read and run declarations, statements and expressions
+
+Run compiler <command> -h for the flags of a command.
+`
+
 func main() {
 	if len(os.Args) < 2 {
-		panic("File arg required")
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	args := os.Args[2:]
+
+	switch command {
+	case "build":
+		os.Exit(build(args))
+	case "run":
+		os.Exit(run(args))
+	case "check":
+		os.Exit(check(args))
+	case "emit":
+		os.Exit(emit(args))
+	case "bytecode":
+		os.Exit(bytecode(args))
+	case "disasm":
+		os.Exit(disasm(args))
+	case "repl":
+		os.Exit(repl(args))
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// program holds the output of each stage of the compiler
+type program struct {
+	path      string
+	tokens    []lexer.Token
+	nodes     []parser.Node
+	warnings  []string
+	module    *ir.Module
+	artifacts generator.Artifacts
+}
+
+// backend generates code for build, run, check and emit
+var backend generator.Backend
+
+// warnUnused reports the code dead code elimination removed
+var warnUnused bool
+
+// stageFlags registers the flags shared by commands that generate
+// code, setting the options of config
+func stageFlags(flags *flag.FlagSet, config *generator.BuildConfig) func() error {
+	memory := flags.String("memory", string(config.Memory), "memory management: gc or arc")
+	flags.BoolVar(&config.EscapeAnalysis, "escape", config.EscapeAnalysis, "stack allocate structs that don't escape")
+	flags.BoolVar(&config.DeadCodeElimination, "dce", config.DeadCodeElimination, "remove functions, methods and structs main doesn't use (c backend)")
+	flags.BoolVar(&config.Inlining, "inline", config.Inlining, "inline small functions and those marked @inline (c backend)")
+	flags.Var((*listFlag)(&config.Exports), "export", "functions to keep for C callers when removing unused code")
+	flags.BoolVar(&warnUnused, "warn-unused", false, "warn about the unused code that's removed")
+	defaultChecks := []string{}
+	for _, name := range lint.Names() {
+		if check, _ := lint.Lookup(name); check.Default {
+			defaultChecks = append(defaultChecks, name)
+		}
+	}
+	lintChecks := flags.String(
+		"lint",
+		strings.Join(defaultChecks, ","),
+		"lint checks to run, separated by commas, all or none: "+strings.Join(lint.Names(), ", "))
+	backendName := flags.String(
+		"backend",
+		"c",
+		"code generator: "+strings.Join(generator.BackendNames(), ", "))
+
+	return func() error {
+		var err error
+		backend, err = generator.LookupBackend(*backendName)
+		if err != nil {
+			return err
+		}
+		switch generator.MemoryMode(*memory) {
+		case generator.MemoryModeGC, generator.MemoryModeARC:
+			config.Memory = generator.MemoryMode(*memory)
+		default:
+			return fmt.Errorf("Unknown memory mode: %s", *memory)
+		}
+		if err := lint.Enable(strings.Split(*lintChecks, ",")); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// listFlag collects space separated flags, e.g. -cflags="-g -Wall"
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, strings.Fields(value)...)
+	return nil
+}
+
+// buildFlags registers the flags configuring the C toolchain
+func buildFlags(flags *flag.FlagSet) *generator.BuildConfig {
+	config := generator.DefaultBuildConfig()
+	config.Runtime = runtimeFS
+	flags.StringVar(&config.CC, "cc", "", "C compiler name or path (default $CC, then clang, gcc, cc or tcc)")
+	flags.Var((*listFlag)(&config.CFlags), "cflags", "extra C compiler flags")
+	flags.Var((*listFlag)(&config.LDFlags), "ldflags", "extra linker flags")
+	flags.StringVar(&config.OptLevel, "O", config.OptLevel, "C optimisation level")
+	flags.StringVar(&config.BuildDir, "build-dir", "", "directory for the generated code (default a temp dir)")
+	flags.StringVar(&config.CacheDir, "cache-dir", "", "directory for the compiled runtime (default the user cache dir)")
+	return config
+}
+
+// fileArg parses a command's flags and returns the source file
+func fileArg(flags *flag.FlagSet, args []string) (string, []string) {
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "%s: file arg required\n", flags.Name())
+		flags.Usage()
+		os.Exit(2)
+	}
+	return flags.Arg(0), flags.Args()[1:]
+}
+
+// catch turns the panics the compiler reports errors with into errors
+func catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// compile runs the compiler up to and including stage,
+// one of tokens, ast, types, ir or code
+func compile(path string, stage string, config *generator.BuildConfig) (*program, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := &program{path: path}
+	removed := []string{}
+	err = catch(func() {
+		prog.tokens = lexer.Lex(string(dat))
+		if stage == "tokens" {
+			return
+		}
+		prog.nodes = globals.Sort(parser.Parse(prog.tokens))
+		generator.InferTypes(prog.nodes)
+		// Lint sees the consts fold replaces with their values
+		prog.warnings = lint.Program(prog.nodes)
+		fold.Program(prog.nodes)
+		mutability.Program(prog.nodes)
+		prog.warnings = append(prog.warnings, flow.Program(prog.nodes)...)
+		if stage == "ast" || stage == "types" {
+			return
+		}
+		if stage == "ir" {
+			prog.module, removed = generator.Lower(prog.nodes, config)
+			return
+		}
+		prog.artifacts, removed = backend.Generate(prog.nodes, config)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: error: %s", path, err)
+	}
+	for _, warning := range prog.warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, warning)
+	}
+	if warnUnused {
+		for _, name := range removed {
+			fmt.Fprintf(os.Stderr, "%s: warning: removed unused %s\n", path, name)
+		}
+	}
+	return prog, nil
+}
+
+func report(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	config := buildFlags(flags)
+	flags.StringVar(&config.Output, "o", config.Output, "path of the executable")
+	configure := stageFlags(flags, config)
+	path, _ := fileArg(flags, args)
+	if err := configure(); err != nil {
+		return report(err)
 	}
-	filepath := os.Args[1]
 
-	dat, err := ioutil.ReadFile(filepath)
+	prog, err := compile(path, "code", config)
 	if err != nil {
-		panic(err)
+		return report(err)
+	}
+	if err := backend.Link(prog.artifacts, config); err != nil {
+		return report(err)
+	}
+	return 0
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	config := buildFlags(flags)
+	configure := stageFlags(flags, config)
+	useInterp := flags.Bool("interp", false, "run the program with the interpreter instead of compiling it")
+	useVM := flags.Bool("vm", false, "run the program in the bytecode VM instead of compiling it")
+	path, programArgs := fileArg(flags, args)
+	if err := configure(); err != nil {
+		return report(err)
+	}
+
+	if *useInterp {
+		return interpret(path)
+	}
+	if *useVM || strings.HasSuffix(path, ".bc") {
+		return runBytecode(path)
+	}
+
+	prog, err := compile(path, "code", config)
+	if err != nil {
+		return report(err)
+	}
+
+	dir, err := ioutil.TempDir("", "compiler-run")
+	if err != nil {
+		return report(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Output = filepath.Join(dir, "out")
+	if err := backend.Link(prog.artifacts, config); err != nil {
+		return report(err)
+	}
+
+	cmd := exec.Command(config.Output, programArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return report(err)
+		}
+		// Like a shell, a program killed by a signal exits with
+		// 128 plus the signal's number
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			report(fmt.Errorf("%s: error: %s", path, err))
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// interpret runs a program with the interpreter, see interp
+func interpret(path string) int {
+	prog, err := compileChecked(path)
+	if err != nil {
+		return report(err)
 	}
 
-	source := string(dat)
-	tokens := lexer.Lex(source)
-	litter.Dump(tokens)
+	code := 0
+	err = catch(func() {
+		code = interp.New(prog.nodes).Run()
+	})
+	if err != nil {
+		return report(fmt.Errorf("%s: error: %s", path, err))
+	}
+	return code
+}
 
-	fmt.Println("--AST--")
-	nodes := parser.Parse(tokens)
-	litter.Dump(nodes)
+// compileChecked compiles a program to its AST for the modes that
+// run it, checking its types like the C backend
+func compileChecked(path string) (*program, error) {
+	prog, err := compile(path, "ast", generator.DefaultBuildConfig())
+	if err != nil {
+		return nil, err
+	}
+	err = catch(func() {
+		generator.Check(prog.nodes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: error: %s", path, err)
+	}
+	return prog, nil
+}
 
-	fmt.Println("\n--CODE--")
-	code := generator.GenerateC(nodes)
-	fmt.Print(code)
-	generator.CompileC()
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	config := generator.DefaultBuildConfig()
+	configure := stageFlags(flags, config)
+	path, _ := fileArg(flags, args)
+	if err := configure(); err != nil {
+		return report(err)
+	}
+
+	if _, err := compile(path, "code", config); err != nil {
+		return report(err)
+	}
+	return 0
+}
+
+func emit(args []string) int {
+	flags := flag.NewFlagSet("emit", flag.ExitOnError)
+	stage := flags.String("stage", "code", "stage to print: tokens, ast, types, ir, code or a backend name")
+	config := generator.DefaultBuildConfig()
+	configure := stageFlags(flags, config)
+	path, _ := fileArg(flags, args)
+	if err := configure(); err != nil {
+		return report(err)
+	}
+
+	switch *stage {
+	case "tokens", "ast", "types", "ir", "code":
+	default:
+		// -stage=asm is short for -stage=code -backend=asm
+		stageBackend, err := generator.LookupBackend(*stage)
+		if err != nil {
+			return report(fmt.Errorf("Unknown stage: %s", *stage))
+		}
+		backend = stageBackend
+		*stage = "code"
+	}
+
+	prog, err := compile(path, *stage, config)
+	if err != nil {
+		return report(err)
+	}
+
+	switch *stage {
+	case "tokens":
+		litter.Dump(prog.tokens)
+	case "ast":
+		litter.Dump(prog.nodes)
+	case "types":
+		// The type of each variable, for editors to show on hover
+		for _, declaration := range generator.Declarations(prog.nodes) {
+			inferred := ""
+			if declaration.Inferred {
+				inferred = " (inferred)"
+			}
+			fmt.Printf("%s\t%s: %s%s\n", declaration.Function, declaration.Name, declaration.Type, inferred)
+		}
+	case "ir":
+		fmt.Print(prog.module)
+	case "code":
+		names := []string{}
+		for name := range prog.artifacts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(names) > 1 {
+				fmt.Printf("==> %s <==\n", name)
+			}
+			fmt.Print(prog.artifacts[name])
+		}
+	}
+	return 0
 }
@@ -0,0 +1,213 @@
+// Package mutability rejects assignments to what can't be assigned
+// to. Variables declared with let or const can't be, nor can a
+// method's self. Params and struct props can't be unless they're
+// declared var, e.g.
+//
+//	struct Counter {
+//	    var count: Int
+//	    step: Int
+//	}
+//
+// A struct's immutable props still change what's in them, only the
+// assignment itself is rejected. Variables are fn scoped, so a name
+// can't be declared again in the fn that binds it, a var declared
+// later would make an earlier let assignable. Fns can hide globals.
+package mutability
+
+import (
+	"fmt"
+
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// Binding is a variable, param or self in scope
+type Binding struct {
+	// Kind is let, const, var, param or self
+	Kind    string
+	Type    string
+	Mutable bool
+	// Global bindings can be hidden by a fn's declarations
+	Global bool
+}
+
+// Scope maps the names in scope to their bindings
+type Scope map[string]*Binding
+
+// Program checks a program's globals and the bodies of its fns and
+// methods, which can assign to the globals declared var
+func Program(nodes []parser.Node) {
+	globals := Scope{}
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			Block(nodes, []parser.Expression{global.Declaration}, globals)
+		}
+	}
+	for _, binding := range globals {
+		binding.Global = true
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.Global:
+		case *parser.Function:
+			Block(nodes, n.Expressions, FunctionScope(n, "", globals))
+		case *parser.Struct:
+			for _, method := range n.Functions {
+				Block(nodes, method.Expressions, FunctionScope(method, n.Name, globals))
+			}
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+}
+
+// FunctionScope returns the bindings of the globals, a fn's params,
+// and self for a method of receiver
+func FunctionScope(function *parser.Function, receiver string, globals Scope) Scope {
+	scope := Scope{}
+	for name, binding := range globals {
+		scope[name] = binding
+	}
+	if receiver != "" {
+		scope["self"] = &Binding{Kind: "self", Type: receiver}
+	}
+	for _, prop := range function.Prototype.Props {
+		scope[prop.Name] = &Binding{Kind: "param", Type: prop.Type, Mutable: prop.Mutable}
+	}
+	return scope
+}
+
+// Block checks a list of statements, declaring their variables in
+// scope. Variables are fn scoped, like the lowering's.
+func Block(nodes []parser.Node, expressions []parser.Expression, scope Scope) {
+	c := &checker{structs: map[string]*parser.Struct{}, scope: scope}
+	for _, node := range nodes {
+		if str, ok := node.(*parser.Struct); ok {
+			c.structs[str.Name] = str
+		}
+	}
+	c.block(expressions)
+}
+
+type checker struct {
+	structs map[string]*parser.Struct
+	scope   Scope
+}
+
+func (c *checker) block(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		c.expression(expression)
+	}
+}
+
+func (c *checker) expression(expression parser.Expression) {
+	switch exp := expression.(type) {
+	case *parser.IntExpression, *parser.StringExpression, *parser.BoolExpression, *parser.VariableExpression:
+	case *parser.InterpolatedStringExpression:
+		c.block(exp.Parts)
+	case *parser.ArrayExpression:
+		c.block(exp.Elements)
+	case *parser.ReturnExpression:
+		c.expression(exp.Expression)
+	case *parser.IfExpression:
+		c.expression(exp.Condition)
+		c.block(exp.Then)
+		c.block(exp.Else)
+	case *parser.BinaryExpression:
+		c.expression(exp.LHS)
+		c.expression(exp.RHS)
+	case *parser.ParenExpression:
+		c.expression(exp.Expression)
+	case *parser.CallExpression:
+		c.block(exp.Params)
+	case *parser.VariableDeclarationExpression:
+		c.expression(exp.Expression)
+		if earlier, ok := c.scope[exp.Name]; ok && !earlier.Global {
+			c.redeclare(exp.Name, earlier)
+		}
+		binding := &Binding{Kind: "var", Type: exp.Type, Mutable: true}
+		switch {
+		case exp.Const:
+			binding = &Binding{Kind: "const", Type: exp.Type}
+		case exp.Let:
+			binding = &Binding{Kind: "let", Type: exp.Type}
+		}
+		c.scope[exp.Name] = binding
+	case *parser.AssignmentExpression:
+		c.expression(exp.Expression)
+		if len(exp.Target.Fields) == 0 {
+			c.assign(exp.Target.Name)
+		} else {
+			c.assignProp(exp.Target)
+		}
+	case *parser.AccessorExpression:
+		if method, ok := exp.Member().(*parser.CallExpression); ok {
+			c.block(method.Params)
+		}
+	default:
+		panic("Invalid ExpressionType")
+	}
+}
+
+// assign checks an assignment to a variable, undeclared ones are
+// reported when the fn's lowered
+func (c *checker) assign(name string) {
+	binding, ok := c.scope[name]
+	if !ok || binding.Mutable {
+		return
+	}
+	switch binding.Kind {
+	case "param":
+		msg := fmt.Sprintf("Can't assign to param %s, it isn't declared var", name)
+		panic(msg)
+	case "self":
+		panic("Can't assign to self")
+	default:
+		msg := fmt.Sprintf("Can't assign to %s %s", binding.Kind, name)
+		panic(msg)
+	}
+}
+
+// redeclare rejects declaring a name already bound in the fn
+func (c *checker) redeclare(name string, earlier *Binding) {
+	if earlier.Kind == "self" {
+		panic("Can't redeclare self")
+	}
+	msg := fmt.Sprintf("Can't redeclare %s %s", earlier.Kind, name)
+	panic(msg)
+}
+
+// assignProp checks an assignment to the last prop of a chain of
+// fields, the props before it are only read. Only the structs of
+// the props are followed, their types are checked when lowering.
+func (c *checker) assignProp(target *parser.LValue) {
+	binding, ok := c.scope[target.Name]
+	if !ok {
+		return
+	}
+	valueType := binding.Type
+	for i, name := range target.Fields {
+		str, ok := c.structs[valueType]
+		if !ok {
+			return
+		}
+		prop := findProp(str, name)
+		if prop == nil {
+			return
+		}
+		if i == len(target.Fields)-1 && !prop.Mutable {
+			msg := fmt.Sprintf("Can't assign to prop %s.%s, it isn't declared var", str.Name, name)
+			panic(msg)
+		}
+		valueType = prop.Type
+	}
+}
+
+func findProp(str *parser.Struct, name string) *parser.Prop {
+	for _, prop := range str.Props {
+		if prop.Name == name {
+			return prop
+		}
+	}
+	return nil
+}
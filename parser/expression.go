@@ -13,14 +13,28 @@ type BinaryOperator string
 
 // BinaryOperatorPlus ...
 const (
-	BinaryOperatorPlus           BinaryOperator = "BinaryOperatorPlus"
-	BinaryOperatorMinus          BinaryOperator = "BinaryOperatorMinus"
-	BinaryOperatorMultiplication BinaryOperator = "BinaryOperatorMultiplication"
-	BinaryOperatorDivision       BinaryOperator = "BinaryOperatorDivision"
+	BinaryOperatorPlus               BinaryOperator = "BinaryOperatorPlus"
+	BinaryOperatorMinus              BinaryOperator = "BinaryOperatorMinus"
+	BinaryOperatorMultiplication     BinaryOperator = "BinaryOperatorMultiplication"
+	BinaryOperatorDivision           BinaryOperator = "BinaryOperatorDivision"
+	BinaryOperatorModulo             BinaryOperator = "BinaryOperatorModulo"
+	BinaryOperatorEqual              BinaryOperator = "BinaryOperatorEqual"
+	BinaryOperatorNotEqual           BinaryOperator = "BinaryOperatorNotEqual"
+	BinaryOperatorLessThan           BinaryOperator = "BinaryOperatorLessThan"
+	BinaryOperatorGreaterThan        BinaryOperator = "BinaryOperatorGreaterThan"
+	BinaryOperatorLessThanOrEqual    BinaryOperator = "BinaryOperatorLessThanOrEqual"
+	BinaryOperatorGreaterThanOrEqual BinaryOperator = "BinaryOperatorGreaterThanOrEqual"
 )
 
 func (b BinaryOperator) precedence() int {
 	switch b {
+	case BinaryOperatorEqual,
+		BinaryOperatorNotEqual,
+		BinaryOperatorLessThan,
+		BinaryOperatorGreaterThan,
+		BinaryOperatorLessThanOrEqual,
+		BinaryOperatorGreaterThanOrEqual:
+		return 10
 	case BinaryOperatorPlus:
 		return 20
 	case BinaryOperatorMinus:
@@ -29,27 +43,37 @@ func (b BinaryOperator) precedence() int {
 		return 40
 	case BinaryOperatorDivision:
 		return 40
+	case BinaryOperatorModulo:
+		return 40
 	default:
 		panic("Fallthrough")
 	}
 }
 
+// IsComparison reports whether the operator produces a Bool
+func (b BinaryOperator) IsComparison() bool {
+	return b.precedence() == 10
+}
+
 // ExpressionType ...
 type ExpressionType string
 
 // ExpressionTypeInt ...
 const (
-	ExpressionTypeInt                ExpressionType = "ExpressionTypeInt"
-	ExpressionTypeString             ExpressionType = "ExpressionTypeString"
-	ExpressionTypeArray              ExpressionType = "ExpressionTypeArray"
-	ExpressionTypeReturn             ExpressionType = "ExpressionTypeReturn"
-	ExpressionTypeBinary             ExpressionType = "ExpressionTypeBinary"
-	ExpressionTypeCall               ExpressionType = "ExpressionTypeCall"
-	ExpressionTypeParen              ExpressionType = "ExpressionTypeParen"
+	ExpressionTypeInt                 ExpressionType = "ExpressionTypeInt"
+	ExpressionTypeString              ExpressionType = "ExpressionTypeString"
+	ExpressionTypeInterpolatedString  ExpressionType = "ExpressionTypeInterpolatedString"
+	ExpressionTypeBool                ExpressionType = "ExpressionTypeBool"
+	ExpressionTypeArray               ExpressionType = "ExpressionTypeArray"
+	ExpressionTypeReturn              ExpressionType = "ExpressionTypeReturn"
+	ExpressionTypeIf                  ExpressionType = "ExpressionTypeIf"
+	ExpressionTypeBinary              ExpressionType = "ExpressionTypeBinary"
+	ExpressionTypeCall                ExpressionType = "ExpressionTypeCall"
+	ExpressionTypeParen               ExpressionType = "ExpressionTypeParen"
 	ExpressionTypeVariableDeclaration ExpressionType = "ExpressionTypeVariableDeclaration"
-	ExpressionTypeVariableAssignment ExpressionType = "ExpressionTypeVariableAssignment"
-	ExpressionTypeVariable           ExpressionType = "ExpressionTypeVariable"
-	ExpressionTypeAccessor           ExpressionType = "ExpressionTypeAccessor"
+	ExpressionTypeAssignment          ExpressionType = "ExpressionTypeAssignment"
+	ExpressionTypeVariable            ExpressionType = "ExpressionTypeVariable"
+	ExpressionTypeAccessor            ExpressionType = "ExpressionTypeAccessor"
 )
 
 // Expression ...
@@ -77,6 +101,28 @@ func (e *StringExpression) ExpressionType() ExpressionType {
 	return ExpressionTypeString
 }
 
+// InterpolatedStringExpression is a string literal containing
+// ${expr} segments, Parts alternate between StringExpressions
+// and the interpolated expressions
+type InterpolatedStringExpression struct {
+	Parts []Expression
+}
+
+// ExpressionType ...
+func (e *InterpolatedStringExpression) ExpressionType() ExpressionType {
+	return ExpressionTypeInterpolatedString
+}
+
+// BoolExpression ...
+type BoolExpression struct {
+	Value bool
+}
+
+// ExpressionType ...
+func (e *BoolExpression) ExpressionType() ExpressionType {
+	return ExpressionTypeBool
+}
+
 // ArrayExpression ...
 type ArrayExpression struct {
 	Elements []Expression
@@ -97,6 +143,18 @@ func (e *ReturnExpression) ExpressionType() ExpressionType {
 	return ExpressionTypeReturn
 }
 
+// IfExpression ...
+type IfExpression struct {
+	Condition Expression
+	Then      []Expression
+	Else      []Expression
+}
+
+// ExpressionType ...
+func (e *IfExpression) ExpressionType() ExpressionType {
+	return ExpressionTypeIf
+}
+
 // BinaryExpression ...
 type BinaryExpression struct {
 	Op  BinaryOperator
@@ -142,9 +200,18 @@ func (e *VariableExpression) ExpressionType() ExpressionType {
 
 // VariableDeclarationExpression ...
 type VariableDeclarationExpression struct {
-	Name       string
+	Name string
+	// Type is empty when it isn't written, until it's inferred from
+	// Expression, see generator.InferTypes
 	Type       string
 	Expression Expression
+	// Const declarations can't be assigned to and are
+	// initialised by a constant expression, see fold
+	Const bool
+	// Let declarations can't be assigned to, see mutability
+	Let bool
+	// Inferred is set on declarations whose Type was inferred
+	Inferred bool
 }
 
 // ExpressionType ...
@@ -152,20 +219,61 @@ func (e *VariableDeclarationExpression) ExpressionType() ExpressionType {
 	return ExpressionTypeVariableDeclaration
 }
 
-// VariableAssignmentExpression ...
-type VariableAssignmentExpression struct {
-	Name       string
+// AssignmentExpression ...
+type AssignmentExpression struct {
+	Target *LValue
+	// Op is the operator of a compound assignment, e.g. + for x += 1,
+	// applied to the target's value and Expression. It's empty for =.
+	// x++ and x-- are x += 1 and x -= 1.
+	Op         BinaryOperator
 	Expression Expression
 }
 
 // ExpressionType ...
-func (e *VariableAssignmentExpression) ExpressionType() ExpressionType {
-	return ExpressionTypeVariableAssignment
+func (e *AssignmentExpression) ExpressionType() ExpressionType {
+	return ExpressionTypeAssignment
+}
+
+// LValue is what an assignment stores to, a variable or a field
+// reached from one through a chain of fields, e.g. for
+// person.address.city Name is person and Fields address and city
+type LValue struct {
+	Name   string
+	Fields []string
+}
+
+// String is the lvalue as it's written in source
+func (l *LValue) String() string {
+	return strings.Join(append([]string{l.Name}, l.Fields...), ".")
+}
+
+// Read is an expression that reads the lvalue's value, a variable or
+// an accessor of its fields
+func (l *LValue) Read() Expression {
+	if len(l.Fields) == 0 {
+		return &VariableExpression{Name: l.Name}
+	}
+	last := len(l.Fields) - 1
+	var read Expression = &VariableExpression{Name: l.Fields[last]}
+	for i := last - 1; i >= 0; i-- {
+		read = &AccessorExpression{Target: l.Fields[i], Expression: read}
+	}
+	return &AccessorExpression{Target: l.Name, Expression: read}
+}
+
+// Value is what the assignment stores as a plain assignment would,
+// e.g. x + 1 for x += 1, for backends that don't apply the operator
+// to the target's value themselves
+func (e *AssignmentExpression) Value() Expression {
+	if e.Op == "" {
+		return e.Expression
+	}
+	return &BinaryExpression{Op: e.Op, LHS: e.Target.Read(), RHS: e.Expression}
 }
 
 // AccessorExpression ...
 type AccessorExpression struct {
-	Target string
+	Target     string
 	Expression Expression
 }
 
@@ -174,6 +282,37 @@ func (e *AccessorExpression) ExpressionType() ExpressionType {
 	return ExpressionTypeAccessor
 }
 
+// Fields are the fields an accessor reads from its target before
+// its member, e.g. address for person.address.city
+func (e *AccessorExpression) Fields() []string {
+	fields := []string{}
+	inner, ok := e.Expression.(*AccessorExpression)
+	for ok {
+		fields = append(fields, inner.Target)
+		inner, ok = inner.Expression.(*AccessorExpression)
+	}
+	return fields
+}
+
+// Member is the field read or method called at the end of an
+// accessor's chain of fields, e.g. city for person.address.city
+func (e *AccessorExpression) Member() Expression {
+	member := e.Expression
+	for {
+		inner, ok := member.(*AccessorExpression)
+		if !ok {
+			return member
+		}
+		member = inner.Expression
+	}
+}
+
+// Holder is an expression reading the value an accessor's member
+// belongs to, e.g. person.address for person.address.city
+func (e *AccessorExpression) Holder() Expression {
+	return (&LValue{Name: e.Target, Fields: e.Fields()}).Read()
+}
+
 func parseExpression() Expression {
 	lhs := parsePrimaryExpression()
 	return parseBinaryOperatorRHS(0, lhs)
@@ -185,11 +324,18 @@ func parsePrimaryExpression() Expression {
 		return parseIntLiteralExpression()
 	case tokens[index].Type == lexer.StringLiteral:
 		return parseStringLiteralExpression()
+	case tokens[index].Type == lexer.KeywordTrue,
+		tokens[index].Type == lexer.KeywordFalse:
+		return parseBoolLiteralExpression()
 	case tokens[index].Type == lexer.OpeningBracket:
 		return parseArrayLiteralExpression()
 	case tokens[index].Type == lexer.KeywordReturn:
 		return parseReturnExpression()
-	case tokens[index].Type == lexer.KeywordVar:
+	case tokens[index].Type == lexer.KeywordIf:
+		return parseIfExpression()
+	case tokens[index].Type == lexer.KeywordVar,
+		tokens[index].Type == lexer.KeywordLet,
+		tokens[index].Type == lexer.KeywordConst:
 		return parseVariableDeclarationExpression()
 	case tokens[index].Type == lexer.OpeningParen:
 		return parseParenExpression()
@@ -206,27 +352,19 @@ func parseBinaryOperatorRHS(expressionPrecendence int, lhs Expression) Expressio
 		if tokens[index].Type == lexer.LineBreak || !isBinaryOperator() {
 			return lhs
 		}
-		binOp := parseBinaryOperator()
-		tokenPrecedence := binOp.precedence()
+		tokenPrecedence := peekBinaryOperator().precedence()
 		if tokenPrecedence < expressionPrecendence {
 			return lhs
 		}
+		binOp := parseBinaryOperator()
 
 		rhs := parsePrimaryExpression()
 
-		if !isBinaryOperator() {
-			return &BinaryExpression{
-				Op:  binOp,
-				LHS: lhs,
-				RHS: rhs,
-			}
-		}
-
-		binOp = parseBinaryOperator()
-		nextPrecedence := binOp.precedence()
-		if expressionPrecendence < nextPrecedence {
+		// Bind tighter operators to the rhs first
+		for isBinaryOperator() && peekBinaryOperator().precedence() > tokenPrecedence {
 			rhs = parseBinaryOperatorRHS(tokenPrecedence+1, rhs)
 		}
+
 		lhs = &BinaryExpression{
 			Op:  binOp,
 			LHS: lhs,
@@ -246,14 +384,28 @@ func isBinaryOperator() bool {
 		return true
 	case lexer.DivisionSign:
 		return true
+	case lexer.ModuloSign:
+		return true
+	case lexer.DoubleEquals,
+		lexer.NotEquals,
+		lexer.LessThan,
+		lexer.GreaterThan,
+		lexer.LessThanOrEqual,
+		lexer.GreaterThanOrEqual:
+		return true
 	default:
 		return false
 	}
 }
 
 func parseBinaryOperator() BinaryOperator {
-	token := tokens[index]
+	binOp := peekBinaryOperator()
 	index++
+	return binOp
+}
+
+func peekBinaryOperator() BinaryOperator {
+	token := tokens[index]
 
 	switch token.Type {
 	case lexer.PlusSign:
@@ -264,6 +416,20 @@ func parseBinaryOperator() BinaryOperator {
 		return BinaryOperatorMultiplication
 	case lexer.DivisionSign:
 		return BinaryOperatorDivision
+	case lexer.ModuloSign:
+		return BinaryOperatorModulo
+	case lexer.DoubleEquals:
+		return BinaryOperatorEqual
+	case lexer.NotEquals:
+		return BinaryOperatorNotEqual
+	case lexer.LessThan:
+		return BinaryOperatorLessThan
+	case lexer.GreaterThan:
+		return BinaryOperatorGreaterThan
+	case lexer.LessThanOrEqual:
+		return BinaryOperatorLessThanOrEqual
+	case lexer.GreaterThanOrEqual:
+		return BinaryOperatorGreaterThanOrEqual
 	default:
 		panic("Fallthrough")
 	}
@@ -280,12 +446,103 @@ func parseIntLiteralExpression() *IntExpression {
 	}
 }
 
-func parseStringLiteralExpression() *StringExpression {
+func parseStringLiteralExpression() Expression {
 	token := tokens[index]
-	value := strings.Trim(token.Source, "\"")
+	value := token.Source[1 : len(token.Source)-1]
 	index++
-	return &StringExpression{
-		Value: value,
+
+	if !strings.Contains(value, "${") {
+		return &StringExpression{
+			Value: value,
+		}
+	}
+	return parseInterpolatedString(value)
+}
+
+// Unescape decodes the C escapes a StringExpression's Value is
+// written with, for backends that don't emit C string literals
+func Unescape(value string) string {
+	escapes := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', '0': 0}
+	bytes := []byte{}
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			if escaped, ok := escapes[value[i]]; ok {
+				bytes = append(bytes, escaped)
+				continue
+			}
+		}
+		bytes = append(bytes, value[i])
+	}
+	return string(bytes)
+}
+
+func parseInterpolatedString(value string) *InterpolatedStringExpression {
+	exp := &InterpolatedStringExpression{}
+	literal := ""
+
+	for len(value) > 0 {
+		switch {
+		// \${ is a literal ${
+		case strings.HasPrefix(value, "\\$"):
+			literal += "$"
+			value = value[2:]
+		case strings.HasPrefix(value, "\\"):
+			literal += value[:2]
+			value = value[2:]
+		case strings.HasPrefix(value, "${"):
+			length := lexer.InterpolationLength(value)
+			if length == 0 {
+				panic("Unterminated string interpolation")
+			}
+			if len(literal) > 0 {
+				exp.Parts = append(exp.Parts, &StringExpression{Value: literal})
+				literal = ""
+			}
+			exp.Parts = append(exp.Parts, parseInterpolation(value[2:length-1]))
+			value = value[length:]
+		default:
+			literal += value[:1]
+			value = value[1:]
+		}
+	}
+
+	if len(literal) > 0 {
+		exp.Parts = append(exp.Parts, &StringExpression{Value: literal})
+	}
+
+	return exp
+}
+
+// parseInterpolation parses the source inside ${...} with its own
+// tokens, restoring the outer parser state afterwards
+func parseInterpolation(source string) Expression {
+	outerTokens := tokens
+	outerIndex := index
+	defer func() {
+		tokens = outerTokens
+		index = outerIndex
+	}()
+
+	tokens = lexer.Lex(source)
+	index = 0
+	if tokens[index].Type == lexer.EOF {
+		panic("Empty string interpolation")
+	}
+
+	exp := parseExpression()
+	if tokens[index].Type != lexer.EOF {
+		msg := fmt.Sprintf("Invalid string interpolation: %s", source)
+		panic(msg)
+	}
+	return exp
+}
+
+func parseBoolLiteralExpression() *BoolExpression {
+	token := tokens[index]
+	index++
+	return &BoolExpression{
+		Value: token.Type == lexer.KeywordTrue,
 	}
 }
 
@@ -317,10 +574,42 @@ func parseReturnExpression() *ReturnExpression {
 	return &ReturnExpression{Expression: parseExpression()}
 }
 
+func parseIfExpression() *IfExpression {
+	exp := &IfExpression{}
+
+	if tokens[index].Type != lexer.KeywordIf {
+		panic("Invalid if expression")
+	}
+	index++
+
+	exp.Condition = parseExpression()
+	exp.Then = parseBlock()
+
+	if tokens[index].Type != lexer.KeywordElse {
+		return exp
+	}
+	index++
+
+	// else if chains nest as the only expression of the else block
+	if tokens[index].Type == lexer.KeywordIf {
+		exp.Else = []Expression{parseIfExpression()}
+	} else {
+		exp.Else = parseBlock()
+	}
+
+	return exp
+}
+
 func parseVariableDeclarationExpression() *VariableDeclarationExpression {
 	exp := &VariableDeclarationExpression{}
 
-	if tokens[index].Type != lexer.KeywordVar {
+	switch tokens[index].Type {
+	case lexer.KeywordVar:
+	case lexer.KeywordLet:
+		exp.Let = true
+	case lexer.KeywordConst:
+		exp.Const = true
+	default:
 		panic("Invalid declaration expression")
 	}
 	index++
@@ -331,16 +620,15 @@ func parseVariableDeclarationExpression() *VariableDeclarationExpression {
 	exp.Name = tokens[index].Source
 	index++
 
-	if tokens[index].Type != lexer.Colon {
-		panic("Invalid declaration expression")
-	}
-	index++
-
-	expType, err := parseValueType()
-	if err != nil {
-		panic("Invalid declaration expression")
+	// The type is optional, var x = 1 declares an Int
+	if tokens[index].Type == lexer.Colon {
+		index++
+		expType, err := parseValueType()
+		if err != nil {
+			panic("Invalid declaration expression")
+		}
+		exp.Type = expType
 	}
-	exp.Type = expType
 
 	if tokens[index].Type != lexer.Equals {
 		panic("Invalid declaration expression")
@@ -352,20 +640,57 @@ func parseVariableDeclarationExpression() *VariableDeclarationExpression {
 	return exp
 }
 
-func parseVariableAssignmentExpression() *VariableAssignmentExpression {
-	exp := &VariableAssignmentExpression{}
+// assignmentOperators are the operators of assignments, mapped
+// to the operators compound assignments apply
+var assignmentOperators = map[lexer.TokenType]BinaryOperator{
+	lexer.Equals:               "",
+	lexer.PlusEquals:           BinaryOperatorPlus,
+	lexer.MinusEquals:          BinaryOperatorMinus,
+	lexer.MultiplicationEquals: BinaryOperatorMultiplication,
+	lexer.DivisionEquals:       BinaryOperatorDivision,
+	lexer.ModuloEquals:         BinaryOperatorModulo,
+	lexer.Increment:            BinaryOperatorPlus,
+	lexer.Decrement:            BinaryOperatorMinus,
+}
+
+// isAssignment reports whether the identifier at index starts an
+// lvalue followed by an assignment operator
+func isAssignment() bool {
+	i := index
+	for tokens[i+1].Type == lexer.Period && tokens[i+2].Type == lexer.Identifier {
+		i += 2
+	}
+	_, ok := assignmentOperators[tokens[i+1].Type]
+	return ok
+}
+
+func parseAssignmentExpression() *AssignmentExpression {
+	exp := &AssignmentExpression{Target: &LValue{}}
 
 	if tokens[index].Type != lexer.Identifier {
-		panic("Invalid declaration expression")
+		panic("Invalid assignment expression")
 	}
-	exp.Name = tokens[index].Source
+	exp.Target.Name = tokens[index].Source
 	index++
 
-	if tokens[index].Type != lexer.Equals {
-		panic("Invalid declaration expression")
+	for tokens[index].Type == lexer.Period {
+		index++
+		exp.Target.Fields = append(exp.Target.Fields, tokens[index].Source)
+		index++
 	}
+
+	op, ok := assignmentOperators[tokens[index].Type]
+	if !ok {
+		panic("Invalid assignment expression")
+	}
+	exp.Op = op
+	operator := tokens[index].Type
 	index++
 
+	if operator == lexer.Increment || operator == lexer.Decrement {
+		exp.Expression = &IntExpression{Value: 1}
+		return exp
+	}
 	exp.Expression = parseExpression()
 
 	return exp
@@ -387,12 +712,12 @@ func parseParenExpression() Expression {
 }
 
 func parseIdentifierExpression() Expression {
-	if tokens[index+1].Type == lexer.OpeningParen {
+	if isAssignment() {
+		return parseAssignmentExpression()
+	} else if tokens[index+1].Type == lexer.OpeningParen {
 		return parseCallExpression()
 	} else if tokens[index+1].Type == lexer.Period {
 		return parseAccessorExpression()
-	} else if tokens[index+1].Type == lexer.Equals {
-		return parseVariableAssignmentExpression()
 	} else {
 		name := tokens[index].Source
 		index++
@@ -451,7 +776,12 @@ func parseAccessorExpression() *AccessorExpression {
 	}
 	index++
 
-	exp.Expression = parseExpression()
+	// Only the member belongs to the accessor, any operators
+	// after it apply to the accessor as a whole
+	if tokens[index].Type != lexer.Identifier {
+		panic("Invalid accessor expression")
+	}
+	exp.Expression = parseIdentifierExpression()
 
 	return exp
 }
@@ -1,11 +1,15 @@
 package parser
 
-import "github.com/alexmarchant/compiler/lexer"
+import (
+	"fmt"
+
+	"github.com/alexmarchant/compiler/lexer"
+)
 
 // Prototype ...
 type Prototype struct {
 	Name       string
-	Props []*Prop
+	Props      []*Prop
 	ReturnType string
 }
 
@@ -13,6 +17,31 @@ type Prototype struct {
 type Function struct {
 	Prototype   *Prototype
 	Expressions []Expression
+	// Attributes are the names of the @attributes written before
+	// fn, e.g. inline for @inline
+	Attributes []string
+	// LintIgnore are the lint checks ignored in the whole fn, named
+	// by // lint:ignore directives before it, StatementIgnores those
+	// ignored in statements, and the statements nested in them
+	LintIgnore       []string
+	StatementIgnores map[Expression][]string
+}
+
+// attributes are the @attributes functions can have
+var attributes = map[string]bool{
+	"inline":   true,
+	"noinline": true,
+	"tailrec":  true,
+}
+
+// HasAttribute ...
+func (f *Function) HasAttribute(name string) bool {
+	for _, attribute := range f.Attributes {
+		if attribute == name {
+			return true
+		}
+	}
+	return false
 }
 
 // NodeType ...
@@ -53,7 +82,6 @@ func parsePrototype() *Prototype {
 		prototype.Props = append(prototype.Props, parseProp())
 	}
 
-
 	returnType, err := parseValueType()
 	if err != nil {
 		prototype.ReturnType = "void"
@@ -66,16 +94,35 @@ func parsePrototype() *Prototype {
 
 func parseFunction() *Function {
 	function := &Function{}
+	function.LintIgnore = takeLintIgnores()
+	function.Attributes = parseAttributes()
 	function.Prototype = parsePrototype()
 
 	if tokens[index].Type != lexer.OpeningCurlyBrace {
 		panic("Function declaration missing opening curly brace")
 	}
+	function.StatementIgnores = map[Expression][]string{}
+	statementIgnores = function.StatementIgnores
+	function.Expressions = parseBlock()
+	statementIgnores = nil
+
+	return function
+}
+
+// parseBlock parses expressions between curly braces
+func parseBlock() []Expression {
+	expressions := []Expression{}
+
+	if tokens[index].Type != lexer.OpeningCurlyBrace {
+		panic("Block missing opening curly brace")
+	}
 	index++
 
 	for {
-		// Parse expressions until we hit closing brace
+		// Parse expressions until we hit closing brace, directives
+		// at the end of a block have nothing to apply to
 		if tokens[index].Type == lexer.ClosingCurlyBrace {
+			lintIgnores = nil
 			index++
 			break
 		}
@@ -86,10 +133,48 @@ func parseFunction() *Function {
 			continue
 		}
 
-		function.Expressions = append(
-			function.Expressions,
-			parseExpression())
+		if tokens[index].Type == lexer.LintIgnore {
+			parseLintIgnore()
+			continue
+		}
+
+		ignores := takeLintIgnores()
+		expression := parseExpression()
+		if len(ignores) > 0 && statementIgnores != nil {
+			statementIgnores[expression] = ignores
+		}
+		expressions = append(expressions, expression)
 	}
 
-	return function
+	return expressions
+}
+
+// parseAttributes parses the @attributes before a fn, each may be
+// followed by a line break
+func parseAttributes() []string {
+	names := []string{}
+
+	for tokens[index].Type == lexer.At {
+		index++
+		if tokens[index].Type != lexer.Identifier {
+			panic("Attribute missing name")
+		}
+		name := tokens[index].Source
+		if !attributes[name] {
+			msg := fmt.Sprintf("Unknown attribute: @%s", name)
+			panic(msg)
+		}
+		names = append(names, name)
+		index++
+
+		for tokens[index].Type == lexer.LineBreak {
+			index++
+		}
+	}
+
+	function := &Function{Attributes: names}
+	if function.HasAttribute("inline") && function.HasAttribute("noinline") {
+		panic("Function can't be both @inline and @noinline")
+	}
+	return names
 }
@@ -0,0 +1,24 @@
+package parser
+
+// Global is a var, let or const declared outside of any fn, which
+// every fn and method can use, e.g.
+//
+//	const limit = 10
+//	var greeting = "hello"
+//
+// Globals are initialised before main runs, in the order their
+// initialisers depend on each other, see globals.Sort.
+type Global struct {
+	Declaration *VariableDeclarationExpression
+}
+
+// NodeType ...
+func (g *Global) NodeType() NodeType {
+	return NodeTypeGlobal
+}
+
+func parseGlobal() *Global {
+	// Directives only apply to fns, structs and statements
+	takeLintIgnores()
+	return &Global{Declaration: parseVariableDeclarationExpression()}
+}
@@ -0,0 +1,37 @@
+package parser
+
+// Inspect calls visit for each expression in a list of statements
+// and those nested in them, parents before their parts. The members
+// of an accessor aren't visited, their fields aren't variables and
+// their methods aren't fns, only the values they're passed are.
+func Inspect(expressions []Expression, visit func(Expression)) {
+	for _, expression := range expressions {
+		visit(expression)
+		switch exp := expression.(type) {
+		case *InterpolatedStringExpression:
+			Inspect(exp.Parts, visit)
+		case *ArrayExpression:
+			Inspect(exp.Elements, visit)
+		case *ReturnExpression:
+			Inspect([]Expression{exp.Expression}, visit)
+		case *IfExpression:
+			Inspect([]Expression{exp.Condition}, visit)
+			Inspect(exp.Then, visit)
+			Inspect(exp.Else, visit)
+		case *BinaryExpression:
+			Inspect([]Expression{exp.LHS, exp.RHS}, visit)
+		case *CallExpression:
+			Inspect(exp.Params, visit)
+		case *ParenExpression:
+			Inspect([]Expression{exp.Expression}, visit)
+		case *VariableDeclarationExpression:
+			Inspect([]Expression{exp.Expression}, visit)
+		case *AssignmentExpression:
+			Inspect([]Expression{exp.Expression}, visit)
+		case *AccessorExpression:
+			if method, ok := exp.Member().(*CallExpression); ok {
+				Inspect(method.Params, visit)
+			}
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package parser
 
 import (
-	"fmt"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/alexmarchant/compiler/lexer"
 )
@@ -14,6 +15,7 @@ type NodeType string
 const (
 	NodeTypeFunction NodeType = "NodeTypeFunction"
 	NodeTypeStruct   NodeType = "NodeTypeStruct"
+	NodeTypeGlobal   NodeType = "NodeTypeGlobal"
 )
 
 // Node ...
@@ -25,16 +27,33 @@ type Node interface {
 type Prop struct {
 	Name string
 	Type string
+	// Weak struct props don't keep their value alive
+	Weak bool
+	// Mutable props and params are declared var, others can't be
+	// assigned to, see mutability
+	Mutable bool
+	// LintIgnore are the lint checks ignored in a struct prop, see
+	// Function's
+	LintIgnore []string
 }
 
 var tokens []lexer.Token
 var index int
 
+// lintIgnores are the checks named by the // lint:ignore directives
+// waiting for the declaration or statement after them
+var lintIgnores []string
+
+// statementIgnores collects the directives for the statements of
+// the fn being parsed
+var statementIgnores map[Expression][]string
+
 // Parse returns an AST of a whole program
 func Parse(someTokens []lexer.Token) []Node {
 	nodes := []Node{}
 	tokens = someTokens
 	index = 0
+	lintIgnores = nil
 
 	for {
 		token := tokens[index]
@@ -45,7 +64,9 @@ func Parse(someTokens []lexer.Token) []Node {
 		case token.Type == lexer.LineBreak:
 			index++
 			continue
-		case token.Type == lexer.KeywordFn:
+		case token.Type == lexer.LintIgnore:
+			parseLintIgnore()
+		case token.Type == lexer.KeywordFn, token.Type == lexer.At:
 			nodes = append(
 				nodes,
 				parseFunction())
@@ -53,6 +74,12 @@ func Parse(someTokens []lexer.Token) []Node {
 			nodes = append(
 				nodes,
 				parseStruct())
+		case token.Type == lexer.KeywordVar,
+			token.Type == lexer.KeywordLet,
+			token.Type == lexer.KeywordConst:
+			nodes = append(
+				nodes,
+				parseGlobal())
 		default:
 			msg := fmt.Sprintf("Don't know how to parse: %v", token)
 			panic(msg)
@@ -60,6 +87,24 @@ func Parse(someTokens []lexer.Token) []Node {
 	}
 }
 
+// ParseExpressions parses statements outside of any function,
+// e.g. a line typed into the repl
+func ParseExpressions(someTokens []lexer.Token) []Expression {
+	expressions := []Expression{}
+	tokens = someTokens
+	index = 0
+
+	for tokens[index].Type != lexer.EOF {
+		// Statements outside a fn aren't linted
+		if tokens[index].Type == lexer.LineBreak || tokens[index].Type == lexer.LintIgnore {
+			index++
+			continue
+		}
+		expressions = append(expressions, parseExpression())
+	}
+	return expressions
+}
+
 func parseValueType() (string, error) {
 	token := tokens[index]
 
@@ -70,6 +115,9 @@ func parseValueType() (string, error) {
 	case lexer.KeywordString:
 		index++
 		return "String*", nil
+	case lexer.KeywordBool:
+		index++
+		return "bool", nil
 	case lexer.Identifier:
 		index++
 		return token.Source, nil
@@ -80,6 +128,10 @@ func parseValueType() (string, error) {
 
 func parseProp() *Prop {
 	prop := &Prop{}
+	if tokens[index].Type == lexer.KeywordVar {
+		prop.Mutable = true
+		index++
+	}
 	if tokens[index].Type != lexer.Identifier {
 		panic("Struct prop missing name")
 	}
@@ -98,4 +150,26 @@ func parseProp() *Prop {
 	prop.Type = valueType
 
 	return prop
-}
\ No newline at end of file
+}
+
+// parseLintIgnore parses a directive naming the lint checks to
+// ignore, separated by commas and optionally followed by a reason,
+// e.g. // lint:ignore unused,shadow kept for debugging
+func parseLintIgnore() {
+	directive := strings.TrimPrefix(tokens[index].Source, "//")
+	directive = strings.TrimPrefix(strings.TrimSpace(directive), "lint:ignore")
+	fields := strings.Fields(directive)
+	if len(fields) == 0 {
+		panic("lint:ignore missing checks")
+	}
+	lintIgnores = append(lintIgnores, strings.Split(fields[0], ",")...)
+	index++
+}
+
+// takeLintIgnores returns the directives waiting for the
+// declaration or statement being parsed
+func takeLintIgnores() []string {
+	ignores := lintIgnores
+	lintIgnores = nil
+	return ignores
+}
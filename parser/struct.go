@@ -9,6 +9,9 @@ type Struct struct {
 	Name      string
 	Props     []*Prop
 	Functions []*Function
+	// LintIgnore are the lint checks ignored in the struct's
+	// declaration, see Function's
+	LintIgnore []string
 }
 
 // NodeType ...
@@ -18,6 +21,7 @@ func (f *Struct) NodeType() NodeType {
 
 func parseStruct() *Struct {
 	str := &Struct{}
+	str.LintIgnore = takeLintIgnores()
 
 	if tokens[index].Type != lexer.KeywordStruct {
 		panic("Struct missing struct keyword")
@@ -42,19 +46,31 @@ func parseStruct() *Struct {
 		}
 
 		if tokens[index].Type == lexer.ClosingCurlyBrace {
+			lintIgnores = nil
 			index++
 			break
 		}
 
 		switch tokens[index].Type {
-		case lexer.KeywordFn:
+		case lexer.LintIgnore:
+			parseLintIgnore()
+			continue
+		case lexer.KeywordFn, lexer.At:
 			str.Functions = append(
 				str.Functions,
 				parseFunction())
-		case lexer.Identifier:
-			str.Props = append(
-				str.Props,
-				parseProp())
+		case lexer.Identifier, lexer.KeywordVar:
+			ignores := takeLintIgnores()
+			prop := parseProp()
+			prop.LintIgnore = ignores
+			str.Props = append(str.Props, prop)
+		case lexer.KeywordWeak:
+			ignores := takeLintIgnores()
+			index++
+			prop := parseProp()
+			prop.Weak = true
+			prop.LintIgnore = ignores
+			str.Props = append(str.Props, prop)
 		default:
 			panic("Invalid struct")
 		}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/fold"
+	"github.com/alexmarchant/compiler/generator"
+	"github.com/alexmarchant/compiler/interp"
+	"github.com/alexmarchant/compiler/lexer"
+	"github.com/alexmarchant/compiler/lint"
+	"github.com/alexmarchant/compiler/mutability"
+	"github.com/alexmarchant/compiler/parser"
+	"github.com/sanity-io/litter"
+)
+
+const replHelp = `Enter fn and struct declarations, or statements and expressions
+to run them. Values of expressions are printed like println.
+
+  :type expr  print the type of an expression
+  :ast expr   print the AST of an expression
+  :help       print this help
+  :quit       exit, as does ctrl-d
+`
+
+// session runs input with the interpreter, keeping declarations
+// and variables between inputs
+type session struct {
+	interpreter *interp.Interpreter
+	// nodes are the fns and structs declared so far
+	nodes []parser.Node
+	frame interp.Frame
+	// types are the parser types of the variables in frame
+	types map[string]string
+	// consts are the const variables in frame
+	consts fold.Consts
+	// bindings are the variables in frame, see mutability
+	bindings mutability.Scope
+	out      io.Writer
+}
+
+// repl reads and runs input until it ends
+func repl(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	flags.Parse(args)
+
+	r := &session{
+		interpreter: interp.New(nil),
+		frame:       interp.Frame{},
+		types:       map[string]string{},
+		consts:      fold.Consts{},
+		bindings:    mutability.Scope{},
+		out:         os.Stdout,
+	}
+	r.interpreter.Stdout = r.out
+	r.run(os.Stdin)
+	return 0
+}
+
+func (r *session) run(input io.Reader) {
+	scanner := bufio.NewScanner(input)
+	source := ""
+
+	for {
+		if source == "" {
+			fmt.Fprint(r.out, "> ")
+		} else {
+			fmt.Fprint(r.out, "... ")
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+
+		source += scanner.Text() + "\n"
+		if continues(source) {
+			continue
+		}
+
+		line := strings.TrimSpace(source)
+		source = ""
+		if line == ":quit" {
+			return
+		}
+		if err := catch(func() { r.eval(line) }); err != nil {
+			fmt.Fprintf(r.out, "error: %s\n", err)
+		}
+	}
+}
+
+// continues reports whether source opens more curly braces than
+// it closes, or ends with the @attributes of a fn, so the input
+// continues on the next line
+func continues(source string) bool {
+	depth := 0
+	attributes := false
+	err := catch(func() {
+		for _, token := range lexer.Lex(source) {
+			switch token.Type {
+			case lexer.OpeningCurlyBrace:
+				depth++
+			case lexer.ClosingCurlyBrace:
+				depth--
+			case lexer.At:
+				attributes = true
+			case lexer.Identifier, lexer.LineBreak, lexer.EOF:
+			default:
+				attributes = false
+			}
+		}
+	})
+	// Input that doesn't lex is reported when it's run
+	return err == nil && (depth > 0 || attributes)
+}
+
+func (r *session) eval(line string) {
+	switch {
+	case line == "":
+		return
+	case line == ":help":
+		fmt.Fprint(r.out, replHelp)
+	case strings.HasPrefix(line, ":type "):
+		expression := r.parseExpression(strings.TrimPrefix(line, ":type "))
+		fmt.Fprintln(r.out, generator.TypeOf(r.nodes, r.types, expression))
+	case strings.HasPrefix(line, ":ast "):
+		expression := r.parseExpression(strings.TrimPrefix(line, ":ast "))
+		fmt.Fprintln(r.out, litter.Sdump(expression))
+	case strings.HasPrefix(line, ":"):
+		msg := fmt.Sprintf("Unknown command: %s, try :help", line)
+		panic(msg)
+	default:
+		tokens := lexer.Lex(line)
+		switch tokens[0].Type {
+		case lexer.KeywordFn, lexer.KeywordStruct, lexer.At:
+			r.declare(parser.Parse(tokens))
+		default:
+			r.exec(parser.ParseExpressions(tokens))
+		}
+	}
+}
+
+func (r *session) parseExpression(source string) parser.Expression {
+	expressions := parser.ParseExpressions(lexer.Lex(source))
+	if len(expressions) != 1 {
+		panic("Expected one expression")
+	}
+	return expressions[0]
+}
+
+// declare adds fns and structs, replacing earlier ones with the same name
+func (r *session) declare(nodes []parser.Node) {
+	declared := r.nodes
+	for _, node := range nodes {
+		if global, ok := node.(*parser.Global); ok {
+			// Variables declared in the repl are already in scope
+			// for the rest of the session, fns can't use them
+			msg := fmt.Sprintf("Can't declare global %s in the repl", global.Declaration.Name)
+			panic(msg)
+		}
+		name := nodeName(node)
+		kept := []parser.Node{}
+		for _, existing := range declared {
+			if nodeName(existing) != name {
+				kept = append(kept, existing)
+			}
+		}
+		declared = append(kept, node)
+	}
+
+	// Earlier declarations are already checked, their types are
+	// needed to check the new ones
+	generator.InferTypes(declared)
+	warnings := lint.Program(nodes)
+	fold.Program(nodes)
+	mutability.Program(declared)
+	generator.Check(declared)
+	for _, warning := range append(warnings, flow.Program(nodes)...) {
+		fmt.Fprintf(r.out, "warning: %s\n", warning)
+	}
+	r.nodes = declared
+	r.interpreter.Declare(nodes)
+}
+
+func nodeName(node parser.Node) string {
+	switch n := node.(type) {
+	case *parser.Function:
+		return n.Prototype.Name
+	case *parser.Struct:
+		return n.Name
+	default:
+		panic("Invalid NodeType")
+	}
+}
+
+// exec runs statements, printing the value of a final expression
+func (r *session) exec(expressions []parser.Expression) {
+	declared := map[string]string{}
+	for name, valueType := range r.types {
+		declared[name] = valueType
+	}
+	generator.InferBlockTypes(r.nodes, r.types, expressions)
+	expressions = fold.Block(expressions, r.consts)
+	mutability.Block(r.nodes, expressions, r.bindings)
+	r.check(declared, expressions)
+
+	result := r.interpreter.Exec(expressions, r.frame)
+	if result == nil || !printsValue(expressions[len(expressions)-1]) {
+		return
+	}
+	fmt.Fprintln(r.out, r.interpreter.Format(result))
+}
+
+// check lowers statements to IR to check their types like the
+// compiler, as the body of a fn whose params are the variables
+// declared before them. The fn's name can't be a declared fn's.
+func (r *session) check(declared map[string]string, expressions []parser.Expression) {
+	names := []string{}
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	props := []*parser.Prop{}
+	for _, name := range names {
+		props = append(props, &parser.Prop{Name: name, Type: declared[name]})
+	}
+
+	input := &parser.Function{
+		Prototype: &parser.Prototype{
+			Name:       "<repl>",
+			Props:      props,
+			ReturnType: "void",
+		},
+		Expressions: expressions,
+	}
+	generator.Check(append(append([]parser.Node{}, r.nodes...), input))
+}
+
+// printsValue is false for statements that are run for their
+// effect, e.g. declarations and assignments
+func printsValue(expression parser.Expression) bool {
+	switch expression.(type) {
+	case *parser.VariableDeclarationExpression,
+		*parser.AssignmentExpression,
+		*parser.IfExpression:
+		return false
+	default:
+		return true
+	}
+}
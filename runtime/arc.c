@@ -0,0 +1,252 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <stdbool.h>
+#include "arc.h"
+
+#ifdef RUNTIME_ARC
+
+// Reference counting. Every object starts with a count of one
+// which is autoreleased, so values being passed around by an
+// expression are borrowed until the statement ends. Variables
+// and fields own their objects through arc_store, except weak
+// fields which are set to NULL when their object is freed.
+
+typedef struct _ARCWeakRef {
+    void** slot;
+    struct _ARCWeakRef* next;
+} ARCWeakRef;
+
+typedef struct _ARCHeader {
+    struct _ARCHeader* prev;
+    struct _ARCHeader* next;
+    ObjectType* type;
+    size_t size;
+    int refCount;
+    ARCWeakRef* weakRefs;
+} ARCHeader;
+
+static ARCHeader* objects = NULL;
+static ARCFrame* topFrame = NULL;
+static bool initialized = false;
+
+static void** pool = NULL;
+static int poolCount = 0;
+static int poolCapacity = 0;
+
+static ARCHeader** dying = NULL;
+static int dyingCount = 0;
+static int dyingCapacity = 0;
+static bool freeing = false;
+
+static void (*releaseGlobals)(void) = NULL;
+
+static void* arc_checked(void* val, const char* msg) {
+    if (!val) {
+        printf("%s", msg);
+        exit(1);
+    }
+    return val;
+}
+
+static ARCHeader* arc_header(void* object) {
+    return (ARCHeader*)object - 1;
+}
+
+static void arc_report_leaks() {
+    if (releaseGlobals) {
+        releaseGlobals();
+    }
+
+    int leaked = 0;
+    for (ARCHeader* header = objects; header; header = header->next) {
+        leaked++;
+    }
+
+    fprintf(stderr, "--ARC LEAKS--\n");
+    fprintf(stderr, "leaked objects: %d\n", leaked);
+    for (ARCHeader* header = objects; header; header = header->next) {
+        const char* name = header->type ? header->type->name : "unknown";
+        fprintf(
+            stderr,
+            "%s at %p, refs: %d, bytes: %zu\n",
+            name,
+            (void*)(header + 1),
+            header->refCount,
+            header->size);
+    }
+}
+
+void arc_globals(void (*release)(void)) {
+    releaseGlobals = release;
+}
+
+// Setting ARC_DEBUG=1 reports objects still alive at exit
+static void arc_init() {
+    initialized = true;
+    char* flag = getenv("ARC_DEBUG");
+    if (flag && strcmp(flag, "0") != 0) {
+        atexit(arc_report_leaks);
+    }
+}
+
+void* arc_alloc(size_t size, ObjectType* type) {
+    if (!initialized) {
+        arc_init();
+    }
+
+    ARCHeader* header = arc_checked(
+        calloc(1, sizeof(ARCHeader) + size),
+        "Error allocating memory");
+    header->type = type;
+    header->size = size;
+    header->refCount = 1;
+    header->next = objects;
+    if (objects) {
+        objects->prev = header;
+    }
+    objects = header;
+
+    return arc_autorelease(header + 1);
+}
+
+void* arc_retain(void* object) {
+    if (object) {
+        arc_header(object)->refCount++;
+    }
+    return object;
+}
+
+static void arc_free(ARCHeader* header) {
+    void* object = header + 1;
+
+    for (ARCWeakRef* ref = header->weakRefs; ref;) {
+        ARCWeakRef* next = ref->next;
+        *ref->slot = NULL;
+        free(ref);
+        ref = next;
+    }
+    header->weakRefs = NULL;
+
+    if (header->type && header->type->trace) {
+        header->type->trace(object, arc_release);
+    }
+    if (header->type && header->type->finalize) {
+        header->type->finalize(object);
+    }
+
+    if (header->prev) {
+        header->prev->next = header->next;
+    } else {
+        objects = header->next;
+    }
+    if (header->next) {
+        header->next->prev = header->prev;
+    }
+    free(header);
+}
+
+// Objects are freed from a worklist rather than recursively,
+// so releasing a long chain doesn't exhaust the C stack
+void arc_release(void* object) {
+    if (!object) {
+        return;
+    }
+    ARCHeader* header = arc_header(object);
+    header->refCount--;
+    if (header->refCount > 0) {
+        return;
+    }
+
+    if (dyingCount == dyingCapacity) {
+        dyingCapacity = dyingCapacity ? dyingCapacity * 2 : 64;
+        dying = arc_checked(
+            realloc(dying, sizeof(ARCHeader*) * dyingCapacity),
+            "Error resizing release stack");
+    }
+    dying[dyingCount] = header;
+    dyingCount++;
+
+    if (freeing) {
+        return;
+    }
+    freeing = true;
+    while (dyingCount > 0) {
+        dyingCount--;
+        arc_free(dying[dyingCount]);
+    }
+    freeing = false;
+}
+
+void* arc_autorelease(void* object) {
+    if (poolCount == poolCapacity) {
+        poolCapacity = poolCapacity ? poolCapacity * 2 : 64;
+        pool = arc_checked(
+            realloc(pool, sizeof(void*) * poolCapacity),
+            "Error resizing autorelease pool");
+    }
+    pool[poolCount] = object;
+    poolCount++;
+    return object;
+}
+
+void arc_store(void** slot, void* object) {
+    void* old = *slot;
+    *slot = arc_retain(object);
+    arc_release(old);
+}
+
+static void arc_unregister_weak(void** slot) {
+    if (!*slot) {
+        return;
+    }
+    ARCWeakRef** link = &arc_header(*slot)->weakRefs;
+    while (*link) {
+        ARCWeakRef* ref = *link;
+        if (ref->slot == slot) {
+            *link = ref->next;
+            free(ref);
+            return;
+        }
+        link = &ref->next;
+    }
+}
+
+void arc_store_weak(void** slot, void* object) {
+    arc_unregister_weak(slot);
+    *slot = object;
+    if (!object) {
+        return;
+    }
+
+    ARCWeakRef* ref = arc_checked(
+        malloc(sizeof(ARCWeakRef)),
+        "Error allocating memory");
+    ref->slot = slot;
+    ref->next = arc_header(object)->weakRefs;
+    arc_header(object)->weakRefs = ref;
+}
+
+static void arc_drain(int base) {
+    while (poolCount > base) {
+        poolCount--;
+        arc_release(pool[poolCount]);
+    }
+}
+
+void arc_enter(ARCFrame* frame) {
+    frame->prev = topFrame;
+    frame->poolBase = poolCount;
+    topFrame = frame;
+}
+
+void arc_leave(ARCFrame* frame) {
+    arc_drain(frame->poolBase);
+    topFrame = frame->prev;
+}
+
+void arc_end_statement(ARCFrame* frame) {
+    arc_drain(frame->poolBase);
+}
+
+#endif
@@ -0,0 +1,30 @@
+#include <stddef.h>
+#include "object.h"
+
+#ifndef ARC_H
+#define ARC_H
+
+// ARCFrame is pushed by every generated function, objects
+// autoreleased while it's on top are released when the
+// current statement ends or the frame is left
+typedef struct _ARCFrame {
+    struct _ARCFrame* prev;
+    int poolBase;
+} ARCFrame;
+
+void* arc_alloc(size_t size, ObjectType* type);
+void* arc_retain(void* object);
+void arc_release(void* object);
+void* arc_autorelease(void* object);
+void arc_store(void** slot, void* object);
+void arc_store_weak(void** slot, void* object);
+
+void arc_enter(ARCFrame* frame);
+void arc_leave(ARCFrame* frame);
+void arc_end_statement(ARCFrame* frame);
+
+// arc_globals registers the function releasing the objects held
+// by globals, it's run at exit before leaks are reported
+void arc_globals(void (*release)(void));
+
+#endif
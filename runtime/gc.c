@@ -0,0 +1,220 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include "gc.h"
+
+#ifndef RUNTIME_ARC
+
+// A mark and sweep collector. Objects are found through the
+// shadow stack of GCFrames, whose roots are the local variables
+// of generated functions, and through the temp stack, which
+// holds every object allocated by the statement that is
+// currently running in each frame.
+
+typedef struct _GCHeader {
+    struct _GCHeader* next;
+    ObjectType* type;
+    size_t size;
+    bool marked;
+} GCHeader;
+
+typedef struct _GCStats {
+    size_t allocations;
+    size_t collections;
+    size_t freed;
+    size_t liveObjects;
+    size_t liveBytes;
+    size_t peakBytes;
+} GCStats;
+
+// Building with -DGC_INITIAL_THRESHOLD=0 collects on
+// every allocation, which shakes out missing roots
+#ifndef GC_INITIAL_THRESHOLD
+#define GC_INITIAL_THRESHOLD (1024 * 1024)
+#endif
+
+static GCHeader* objects = NULL;
+static GCFrame* topFrame = NULL;
+static size_t threshold = GC_INITIAL_THRESHOLD;
+static size_t allocatedSinceCollect = 0;
+static GCStats stats = {0};
+static bool initialized = false;
+
+static void** temps = NULL;
+static int tempCount = 0;
+static int tempCapacity = 0;
+
+static GCHeader** grey = NULL;
+static int greyCount = 0;
+static int greyCapacity = 0;
+
+static void* gc_checked(void* val, const char* msg) {
+    if (!val) {
+        printf("%s", msg);
+        exit(1);
+    }
+    return val;
+}
+
+static void gc_print_stats() {
+    fprintf(stderr, "--GC STATS--\n");
+    fprintf(stderr, "allocations: %zu\n", stats.allocations);
+    fprintf(stderr, "collections: %zu\n", stats.collections);
+    fprintf(stderr, "freed: %zu\n", stats.freed);
+    fprintf(stderr, "live objects: %zu\n", stats.liveObjects);
+    fprintf(stderr, "live bytes: %zu\n", stats.liveBytes);
+    fprintf(stderr, "peak bytes: %zu\n", stats.peakBytes);
+}
+
+// Setting GC_STATS=1 prints heap statistics when the program exits
+static void gc_init() {
+    initialized = true;
+    char* flag = getenv("GC_STATS");
+    if (flag && strcmp(flag, "0") != 0) {
+        atexit(gc_print_stats);
+    }
+}
+
+void* gc_alloc(size_t size, ObjectType* type) {
+    if (!initialized) {
+        gc_init();
+    }
+    if (allocatedSinceCollect > threshold) {
+        gc_collect();
+    }
+
+    GCHeader* header = gc_checked(
+        calloc(1, sizeof(GCHeader) + size),
+        "Error allocating memory");
+    header->type = type;
+    header->size = size;
+    header->next = objects;
+    objects = header;
+
+    allocatedSinceCollect += size;
+    stats.allocations++;
+    stats.liveObjects++;
+    stats.liveBytes += size;
+    if (stats.liveBytes > stats.peakBytes) {
+        stats.peakBytes = stats.liveBytes;
+    }
+
+    return gc_keep(header + 1);
+}
+
+void gc_mark(void* object) {
+    if (!object) {
+        return;
+    }
+    GCHeader* header = (GCHeader*)object - 1;
+    if (header->marked) {
+        return;
+    }
+    header->marked = true;
+
+    if (greyCount == greyCapacity) {
+        greyCapacity = greyCapacity ? greyCapacity * 2 : 64;
+        grey = gc_checked(
+            realloc(grey, sizeof(GCHeader*) * greyCapacity),
+            "Error resizing grey stack");
+    }
+    grey[greyCount] = header;
+    greyCount++;
+}
+
+static void gc_mark_roots() {
+    for (GCFrame* frame = topFrame; frame; frame = frame->prev) {
+        for (int i = 0; i < frame->rootCount; i++) {
+            gc_mark(*frame->roots[i]);
+        }
+    }
+    for (int i = 0; i < tempCount; i++) {
+        gc_mark(temps[i]);
+    }
+
+    while (greyCount > 0) {
+        greyCount--;
+        GCHeader* header = grey[greyCount];
+        if (header->type && header->type->trace) {
+            header->type->trace(header + 1, gc_mark);
+        }
+    }
+}
+
+static void gc_sweep() {
+    GCHeader** link = &objects;
+    while (*link) {
+        GCHeader* header = *link;
+        if (header->marked) {
+            header->marked = false;
+            link = &header->next;
+            continue;
+        }
+
+        *link = header->next;
+        if (header->type && header->type->finalize) {
+            header->type->finalize(header + 1);
+        }
+        stats.freed++;
+        stats.liveObjects--;
+        stats.liveBytes -= header->size;
+        free(header);
+    }
+}
+
+void gc_collect() {
+    gc_mark_roots();
+    gc_sweep();
+
+    stats.collections++;
+    allocatedSinceCollect = 0;
+    threshold = stats.liveBytes * 2;
+    if (threshold < GC_INITIAL_THRESHOLD) {
+        threshold = GC_INITIAL_THRESHOLD;
+    }
+}
+
+void gc_enter(GCFrame* frame, void*** roots, int rootCapacity) {
+    frame->prev = topFrame;
+    frame->roots = roots;
+    frame->rootCount = 0;
+    frame->rootCapacity = rootCapacity;
+    frame->tempBase = tempCount;
+    topFrame = frame;
+}
+
+void gc_leave(GCFrame* frame) {
+    topFrame = frame->prev;
+    tempCount = frame->tempBase;
+}
+
+void gc_root(GCFrame* frame, void** slot) {
+    if (frame->rootCount == frame->rootCapacity) {
+        printf("Too many GC roots in frame");
+        exit(1);
+    }
+    frame->roots[frame->rootCount] = slot;
+    frame->rootCount++;
+}
+
+// Objects allocated while evaluating a statement stay
+// alive until the statement finishes
+void gc_end_statement(GCFrame* frame) {
+    tempCount = frame->tempBase;
+}
+
+// gc_keep protects an object that isn't stored in a root yet,
+// e.g. a value being returned to the calling frame
+void* gc_keep(void* object) {
+    if (tempCount == tempCapacity) {
+        tempCapacity = tempCapacity ? tempCapacity * 2 : 64;
+        temps = gc_checked(
+            realloc(temps, sizeof(void*) * tempCapacity),
+            "Error resizing temp stack");
+    }
+    temps[tempCount] = object;
+    tempCount++;
+    return object;
+}
+
+#endif
@@ -0,0 +1,28 @@
+#include <stddef.h>
+#include <stdbool.h>
+#include "object.h"
+
+#ifndef GC_H
+#define GC_H
+
+// GCFrame is a shadow stack entry pushed by every generated
+// function, roots point at its local variables holding objects
+typedef struct _GCFrame {
+    struct _GCFrame* prev;
+    void*** roots;
+    int rootCount;
+    int rootCapacity;
+    int tempBase;
+} GCFrame;
+
+void* gc_alloc(size_t size, ObjectType* type);
+void gc_mark(void* object);
+void gc_collect();
+
+void gc_enter(GCFrame* frame, void*** roots, int rootCapacity);
+void gc_leave(GCFrame* frame);
+void gc_root(GCFrame* frame, void** slot);
+void gc_end_statement(GCFrame* frame);
+void* gc_keep(void* object);
+
+#endif
@@ -0,0 +1,21 @@
+#ifndef MEMORY_H
+#define MEMORY_H
+
+// Programs are built against the tracing collector, or against
+// reference counting when RUNTIME_ARC is defined
+
+#ifdef RUNTIME_ARC
+
+#include "arc.h"
+#define object_alloc arc_alloc
+#define object_retain arc_retain
+
+#else
+
+#include "gc.h"
+#define object_alloc gc_alloc
+#define object_retain(object) (object)
+
+#endif
+
+#endif
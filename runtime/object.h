@@ -0,0 +1,18 @@
+#include <stddef.h>
+
+#ifndef OBJECT_H
+#define OBJECT_H
+
+typedef void (*ObjectVisitor)(void* object);
+
+// ObjectType describes how the memory manager handles an
+// allocation, trace visits every object the allocation holds
+// a strong reference to and finalize releases anything it
+// owns outside the heap
+typedef struct _ObjectType {
+    const char* name;
+    void (*trace)(void* object, ObjectVisitor visit);
+    void (*finalize)(void* object);
+} ObjectType;
+
+#endif
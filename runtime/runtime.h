@@ -1,2 +1,2 @@
-#include "array.h"
+#include "memory.h"
 #include "string.h"
\ No newline at end of file
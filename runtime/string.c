@@ -1,17 +1,227 @@
 #include <stdio.h>
 #include <stdlib.h>
-#include <strings.h>
+#include <string.h>
+#include <ctype.h>
+#include "string.h"
+#include "memory.h"
 
-typedef struct _String {
-    char* value;
-} String;
+static void StringArray__trace(void* object, ObjectVisitor visit);
+static void StringArray__finalize(void* object);
+
+ObjectType String__type = {"String", NULL, NULL};
+ObjectType StringArray__type = {
+    "StringArray",
+    StringArray__trace,
+    StringArray__finalize
+};
+
+// Computed strings keep their characters in the same
+// allocation, directly after the String
+static String* String__alloc(int length) {
+    String* val = object_alloc(sizeof(String) + length + 1, &String__type);
+    val->value = (char*)(val + 1);
+    return val;
+}
+
+static String* String__fromBuffer(const char* buffer, int length) {
+    String* val = String__alloc(length);
+    memcpy(val->value, buffer, length);
+    val->value[length] = '\0';
+    return val;
+}
 
 String* String__make(char* value) {
-    String* val = malloc(sizeof(String));
-    if (!val) {
-        printf("Error allocating memory");
-        exit(1);
-    }
+    String* val = object_alloc(sizeof(String), &String__type);
     val->value = value;
     return val;
-}
\ No newline at end of file
+}
+
+String* String__concat(String* self, String* other) {
+    int selfLength = strlen(self->value);
+    int otherLength = strlen(other->value);
+    String* val = String__alloc(selfLength + otherLength);
+    memcpy(val->value, self->value, selfLength);
+    memcpy(val->value + selfLength, other->value, otherLength + 1);
+    return val;
+}
+
+bool String__equals(String* self, String* other) {
+    return strcmp(self->value, other->value) == 0;
+}
+
+int String__compare(String* self, String* other) {
+    int result = strcmp(self->value, other->value);
+    if (result < 0) {
+        return -1;
+    }
+    if (result > 0) {
+        return 1;
+    }
+    return 0;
+}
+
+int String__length(String* self) {
+    return strlen(self->value);
+}
+
+// Indexes are clamped to the string, so out of range
+// requests return a shorter (possibly empty) string
+String* String__substring(String* self, int start, int end) {
+    int length = strlen(self->value);
+    if (start < 0) {
+        start = 0;
+    }
+    if (end > length) {
+        end = length;
+    }
+    if (end < start) {
+        end = start;
+    }
+    return String__fromBuffer(self->value + start, end - start);
+}
+
+int String__indexOf(String* self, String* needle) {
+    char* found = strstr(self->value, needle->value);
+    if (!found) {
+        return -1;
+    }
+    return found - self->value;
+}
+
+bool String__contains(String* self, String* needle) {
+    return String__indexOf(self, needle) >= 0;
+}
+
+StringArray* String__split(String* self, String* separator) {
+    StringArray* parts = StringArray__make();
+    int separatorLength = strlen(separator->value);
+    char* start = self->value;
+
+    if (separatorLength == 0) {
+        for (; *start; start++) {
+            StringArray__push(parts, String__fromBuffer(start, 1));
+        }
+        return parts;
+    }
+
+    char* found;
+    while ((found = strstr(start, separator->value))) {
+        StringArray__push(parts, String__fromBuffer(start, found - start));
+        start = found + separatorLength;
+    }
+    StringArray__push(parts, String__fromBuffer(start, strlen(start)));
+    return parts;
+}
+
+String* String__join(String* self, StringArray* parts) {
+    int separatorLength = strlen(self->value);
+    int length = 0;
+    for (int i = 0; i < parts->length; i++) {
+        if (i > 0) {
+            length += separatorLength;
+        }
+        length += strlen(parts->items[i]->value);
+    }
+
+    String* val = String__alloc(length);
+    char* cursor = val->value;
+    for (int i = 0; i < parts->length; i++) {
+        if (i > 0) {
+            memcpy(cursor, self->value, separatorLength);
+            cursor += separatorLength;
+        }
+        int partLength = strlen(parts->items[i]->value);
+        memcpy(cursor, parts->items[i]->value, partLength);
+        cursor += partLength;
+    }
+    *cursor = '\0';
+    return val;
+}
+
+String* String__trim(String* self) {
+    char* start = self->value;
+    char* end = self->value + strlen(self->value);
+    while (start < end && isspace((unsigned char)*start)) {
+        start++;
+    }
+    while (end > start && isspace((unsigned char)*(end - 1))) {
+        end--;
+    }
+    return String__fromBuffer(start, end - start);
+}
+
+String* String__upper(String* self) {
+    String* val = String__fromBuffer(self->value, strlen(self->value));
+    for (char* c = val->value; *c; c++) {
+        *c = toupper((unsigned char)*c);
+    }
+    return val;
+}
+
+String* String__lower(String* self) {
+    String* val = String__fromBuffer(self->value, strlen(self->value));
+    for (char* c = val->value; *c; c++) {
+        *c = tolower((unsigned char)*c);
+    }
+    return val;
+}
+
+// Parses a leading base 10 integer, returning 0 when there isn't one
+int String__toInt(String* self) {
+    return strtol(self->value, NULL, 10);
+}
+
+String* String__toString(String* self) {
+    return self;
+}
+
+String* Int__toString(int value) {
+    char buffer[16];
+    int length = snprintf(buffer, sizeof(buffer), "%d", value);
+    return String__fromBuffer(buffer, length);
+}
+
+String* Bool__toString(bool value) {
+    return String__make(value ? "true" : "false");
+}
+
+StringArray* StringArray__make() {
+    return object_alloc(sizeof(StringArray), &StringArray__type);
+}
+
+static void StringArray__trace(void* object, ObjectVisitor visit) {
+    StringArray* self = object;
+    for (int i = 0; i < self->length; i++) {
+        visit(self->items[i]);
+    }
+}
+
+static void StringArray__finalize(void* object) {
+    StringArray* self = object;
+    free(self->items);
+}
+
+void StringArray__push(StringArray* self, String* item) {
+    if (self->length == self->capacity) {
+        self->capacity = self->capacity ? self->capacity * 2 : 4;
+        self->items = realloc(self->items, sizeof(String*) * self->capacity);
+        if (!self->items) {
+            printf("Error resizing array");
+            exit(1);
+        }
+    }
+    self->items[self->length] = object_retain(item);
+    self->length++;
+}
+
+String* StringArray__get(StringArray* self, int index) {
+    if (index < 0 || index >= self->length) {
+        printf("Index out of range: %d\n", index);
+        exit(1);
+    }
+    return self->items[index];
+}
+
+int StringArray__length(StringArray* self) {
+    return self->length;
+}
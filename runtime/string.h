@@ -1,4 +1,5 @@
 #include <stdio.h>
+#include <stdbool.h>
 
 #ifndef STRING_H
 #define STRING_H
@@ -7,6 +8,34 @@ typedef struct _String {
     char* value;
 } String;
 
+typedef struct _StringArray {
+    String** items;
+    int length;
+    int capacity;
+} StringArray;
+
 String* String__make(char* value);
+String* String__concat(String* self, String* other);
+bool String__equals(String* self, String* other);
+int String__compare(String* self, String* other);
+int String__length(String* self);
+String* String__substring(String* self, int start, int end);
+int String__indexOf(String* self, String* needle);
+bool String__contains(String* self, String* needle);
+StringArray* String__split(String* self, String* separator);
+String* String__join(String* self, StringArray* parts);
+String* String__trim(String* self);
+String* String__upper(String* self);
+String* String__lower(String* self);
+int String__toInt(String* self);
+String* String__toString(String* self);
+
+String* Int__toString(int value);
+String* Bool__toString(bool value);
+
+StringArray* StringArray__make();
+void StringArray__push(StringArray* self, String* item);
+String* StringArray__get(StringArray* self, int index);
+int StringArray__length(StringArray* self);
 
-#endif
\ No newline at end of file
+#endif
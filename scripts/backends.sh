@@ -0,0 +1,70 @@
+#!/bin/sh
+# Runs each example in source/ with every backend, the
+# interpreter and the bytecode VM, and reports the ones whose output differs from the
+# C backend. Run from the repo root.
+#
+# An example whose first line is // error: <message> must fail to
+# build with the message. A line // unsupported: <modes> lists the
+# modes that must reject the example as unsupported, e.g.
+# // unsupported: -backend=asm -vm
+# and // skip: <modes> the modes it isn't run with. The C backend
+# must run every example without being killed by a signal.
+
+go build -o /tmp/compiler-backends . || exit 1
+status=0
+
+pass() {
+	echo "ok   $1 $2"
+}
+
+fail() {
+	echo "FAIL $1 $2"
+	status=1
+}
+
+for example in source/*; do
+	error=$(sed -n '1s|^// error: ||p' "$example")
+	if [ -n "$error" ]; then
+		output=$(/tmp/compiler-backends build -o /tmp/compiler-backends-out "$example" 2>&1)
+		if [ $? -ne 0 ] && echo "$output" | grep -qF "$error"; then
+			pass error "$example"
+		else
+			fail error "$example"
+		fi
+		continue
+	fi
+
+	# Examples the C backend can't build are skipped
+	/tmp/compiler-backends build -o /tmp/compiler-backends-out "$example" > /dev/null 2>&1 || continue
+	unsupported=$(sed -n 's|^// unsupported: ||p' "$example")
+	skipped=$(sed -n 's|^// skip: ||p' "$example")
+	expected=$(/tmp/compiler-backends run "$example" 2>&1; echo "exit $?")
+	if [ "${expected##*exit }" -gt 128 ]; then
+		fail -backend=c "$example"
+		continue
+	fi
+	pass -backend=c "$example"
+	for mode in -backend=asm -backend=llvm -interp -vm; do
+		case " $skipped " in
+		*" $mode "*) continue ;;
+		esac
+		actual=$(/tmp/compiler-backends run $mode "$example" 2>&1; echo "exit $?")
+		case " $unsupported " in
+		*" $mode "*)
+			if echo "$actual" | grep -q "supported by"; then
+				pass $mode "$example"
+			else
+				fail $mode "$example"
+			fi
+			continue
+			;;
+		esac
+		if [ "$expected" != "$actual" ]; then
+			fail $mode "$example"
+		else
+			pass $mode "$example"
+		fi
+	done
+done
+
+exit $status
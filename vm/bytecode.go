@@ -0,0 +1,206 @@
+package vm
+
+import "fmt"
+
+// Opcode is the first byte of an instruction. Operands follow
+// it as big endian u16s or single bytes, see operandWidths.
+type Opcode byte
+
+// OpConst pushes an Int from the constant pool ...
+const (
+	// OpConst index16 pushes Ints[index]
+	OpConst Opcode = iota
+	OpTrue
+	OpFalse
+	OpNull
+	// OpString index16 allocates a String holding Strings[index]
+	OpString
+	OpPop
+	// OpLoad slot16 pushes a local, OpStore slot16 sets it to
+	// the top of the stack without popping it
+	OpLoad
+	OpStore
+	// OpGetField field8 replaces an object with one of its fields,
+	// OpSetField field8 pops the value then the object and pushes
+	// the value back
+	OpGetField
+	OpSetField
+	// OpNew struct16 allocates a struct with zeroed fields
+	OpNew
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpGreater
+	OpLessEqual
+	OpGreaterEqual
+	// OpJump address16 and OpJumpIfFalse address16 move to an
+	// offset in the function's code
+	OpJump
+	OpJumpIfFalse
+	// OpCall function16 argc8 calls a function with the args on
+	// top of the stack, methods take self as their first arg
+	OpCall
+	// OpNative native8 argc8 calls a runtime method, see natives
+	OpNative
+	OpReturn
+	// OpToString converts the top of the stack to a String,
+	// calling the toString method of structs
+	OpToString
+	// OpConcat count8 joins Strings, OpPrint count8 prints them
+	// separated by spaces, like println
+	OpConcat
+	OpPrint
+	// OpMod is after the opcodes of .bc files written before it so
+	// their opcodes don't change
+	OpMod
+	// OpTailCall function16 argc8 is OpCall replacing the calling
+	// function's frame, see flow.TailCalls
+	OpTailCall
+	// OpLoadGlobal global16 pushes a global, OpStoreGlobal global16
+	// sets it to the top of the stack without popping it
+	OpLoadGlobal
+	OpStoreGlobal
+)
+
+var opcodeNames = map[Opcode]string{
+	OpConst:        "CONST",
+	OpTrue:         "TRUE",
+	OpFalse:        "FALSE",
+	OpNull:         "NULL",
+	OpString:       "STRING",
+	OpPop:          "POP",
+	OpLoad:         "LOAD",
+	OpStore:        "STORE",
+	OpGetField:     "GET_FIELD",
+	OpSetField:     "SET_FIELD",
+	OpNew:          "NEW",
+	OpAdd:          "ADD",
+	OpSub:          "SUB",
+	OpMul:          "MUL",
+	OpDiv:          "DIV",
+	OpMod:          "MOD",
+	OpEqual:        "EQUAL",
+	OpNotEqual:     "NOT_EQUAL",
+	OpLess:         "LESS",
+	OpGreater:      "GREATER",
+	OpLessEqual:    "LESS_EQUAL",
+	OpGreaterEqual: "GREATER_EQUAL",
+	OpJump:         "JUMP",
+	OpJumpIfFalse:  "JUMP_IF_FALSE",
+	OpCall:         "CALL",
+	OpTailCall:     "TAIL_CALL",
+	OpLoadGlobal:   "LOAD_GLOBAL",
+	OpStoreGlobal:  "STORE_GLOBAL",
+	OpNative:       "NATIVE",
+	OpReturn:       "RETURN",
+	OpToString:     "TO_STRING",
+	OpConcat:       "CONCAT",
+	OpPrint:        "PRINT",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_%d", byte(op))
+}
+
+// operandWidths are the sizes in bytes of each opcode's operands
+var operandWidths = map[Opcode][]int{
+	OpConst:       {2},
+	OpString:      {2},
+	OpLoad:        {2},
+	OpStore:       {2},
+	OpGetField:    {1},
+	OpSetField:    {1},
+	OpNew:         {2},
+	OpJump:        {2},
+	OpJumpIfFalse: {2},
+	OpCall:        {2, 1},
+	OpTailCall:    {2, 1},
+	OpLoadGlobal:  {2},
+	OpStoreGlobal: {2},
+	OpNative:      {1, 1},
+	OpConcat:      {1},
+	OpPrint:       {1},
+}
+
+// Program is a compiled program, the unit the VM runs and
+// .bc files hold
+type Program struct {
+	Ints      []int32
+	Strings   []string
+	Structs   []*Struct
+	Globals   []*Global
+	Functions []*Function
+}
+
+// Global is a variable declared outside any function. Each starts
+// as its zero, then the GlobalsInit function stores its value.
+type Global struct {
+	Name string
+	Zero Kind
+}
+
+// GlobalsInit is the function storing the globals' initial values,
+// which Run calls before main
+const GlobalsInit = "globals__init"
+
+// Struct describes the layout of a struct's objects
+type Struct struct {
+	Name string
+	// Fields are the struct's props in order, Zeros hold the
+	// kind each starts as
+	Fields []string
+	Zeros  []Kind
+	// ToString is the function index of the toString method, or -1
+	ToString int
+}
+
+// Function is a function or method, methods are named Type__method
+type Function struct {
+	Name string
+	// Params are the first Params of Locals
+	Params int
+	Locals int
+	Code   []byte
+}
+
+// FunctionIndex finds a function by name, -1 when there isn't one
+func (p *Program) FunctionIndex(name string) int {
+	for i, function := range p.Functions {
+		if function.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// instructionSize is the size of the instruction at offset
+func instructionSize(code []byte, offset int) int {
+	size := 1
+	for _, width := range operandWidths[Opcode(code[offset])] {
+		size += width
+	}
+	return size
+}
+
+// operands decodes the operands of the instruction at offset
+func operands(code []byte, offset int) []int {
+	values := []int{}
+	position := offset + 1
+	for _, width := range operandWidths[Opcode(code[offset])] {
+		switch width {
+		case 1:
+			values = append(values, int(code[position]))
+		case 2:
+			values = append(values, int(code[position])<<8|int(code[position+1]))
+		}
+		position += width
+	}
+	return values
+}
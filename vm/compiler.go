@@ -0,0 +1,500 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexmarchant/compiler/flow"
+	"github.com/alexmarchant/compiler/parser"
+)
+
+// compiler turns the AST into a Program. Accessor targets are
+// always variables, so their declared types pick the field
+// index or method at compile time.
+type compiler struct {
+	program   *Program
+	functions map[string]int
+	structs   map[string]int
+	// propTypes are the types of each struct's props, for
+	// the fields read from them
+	propTypes map[string]map[string]string
+	// globals are the indexes of the globals, globalTypes their
+	// declared types
+	globals     map[string]int
+	globalTypes map[string]string
+	// tailCalls are compiled to OpTailCall, see flow.TailCalls
+	tailCalls map[*parser.CallExpression]bool
+	// The function being compiled
+	code   []byte
+	locals map[string]int
+	types  map[string]string
+}
+
+// Compile ...
+func Compile(nodes []parser.Node) *Program {
+	c := &compiler{
+		program:     &Program{},
+		functions:   map[string]int{},
+		structs:     map[string]int{},
+		propTypes:   map[string]map[string]string{},
+		globals:     map[string]int{},
+		globalTypes: map[string]string{},
+		tailCalls:   flow.TailCalls(nodes),
+	}
+
+	// Declare everything first so calls can refer forwards
+	bodies := []*parser.Function{}
+	inits := []parser.Expression{}
+	for _, node := range nodes {
+		switch node.NodeType() {
+		case parser.NodeTypeFunction:
+			function := node.(*parser.Function)
+			c.declareFunction(function.Prototype.Name)
+			bodies = append(bodies, function)
+		case parser.NodeTypeStruct:
+			str := node.(*parser.Struct)
+			c.declareStruct(str)
+			for _, function := range str.Functions {
+				bodies = append(bodies, methodFunction(str, function))
+			}
+		case parser.NodeTypeGlobal:
+			// Globals are declared in the order they're initialised
+			declaration := node.(*parser.Global).Declaration
+			c.declareGlobal(declaration)
+			inits = append(inits, &parser.AssignmentExpression{
+				Target:     &parser.LValue{Name: declaration.Name},
+				Expression: declaration.Expression,
+			})
+		default:
+			panic("Invalid NodeType")
+		}
+	}
+	if len(inits) > 0 {
+		c.declareFunction(GlobalsInit)
+		bodies = append(bodies, &parser.Function{
+			Prototype:   &parser.Prototype{Name: GlobalsInit, ReturnType: "void"},
+			Expressions: inits,
+		})
+	}
+	for _, str := range c.program.Structs {
+		str.ToString = c.program.FunctionIndex(str.Name + "__toString")
+	}
+
+	for _, function := range bodies {
+		c.compileFunction(function)
+	}
+	return c.program
+}
+
+// methodFunction gives a method its Type__method name and self
+// as the first param
+func methodFunction(str *parser.Struct, function *parser.Function) *parser.Function {
+	prototype := *function.Prototype
+	prototype.Name = fmt.Sprintf("%s__%s", str.Name, prototype.Name)
+	prototype.Props = append(
+		[]*parser.Prop{{Name: "self", Type: str.Name}},
+		prototype.Props...)
+	return &parser.Function{
+		Prototype:   &prototype,
+		Expressions: function.Expressions,
+	}
+}
+
+func (c *compiler) declareFunction(name string) {
+	if _, ok := c.functions[name]; ok {
+		msg := fmt.Sprintf("Function declared twice: %s", name)
+		panic(msg)
+	}
+	c.functions[name] = len(c.program.Functions)
+	c.program.Functions = append(c.program.Functions, &Function{Name: name})
+}
+
+func (c *compiler) declareStruct(str *parser.Struct) {
+	layout := &Struct{Name: str.Name}
+	c.propTypes[str.Name] = map[string]string{}
+	for _, prop := range str.Props {
+		layout.Fields = append(layout.Fields, prop.Name)
+		layout.Zeros = append(layout.Zeros, zeroKind(prop.Type))
+		c.propTypes[str.Name][prop.Name] = prop.Type
+	}
+	c.structs[str.Name] = len(c.program.Structs)
+	c.program.Structs = append(c.program.Structs, layout)
+
+	for _, function := range str.Functions {
+		c.declareFunction(fmt.Sprintf("%s__%s", str.Name, function.Prototype.Name))
+	}
+}
+
+func (c *compiler) declareGlobal(declaration *parser.VariableDeclarationExpression) {
+	if _, ok := c.globals[declaration.Name]; ok {
+		msg := fmt.Sprintf("Global declared twice: %s", declaration.Name)
+		panic(msg)
+	}
+	c.globals[declaration.Name] = len(c.program.Globals)
+	c.globalTypes[declaration.Name] = declaration.Type
+	c.program.Globals = append(c.program.Globals, &Global{
+		Name: declaration.Name,
+		Zero: zeroKind(declaration.Type),
+	})
+}
+
+func zeroKind(valueType string) Kind {
+	switch valueType {
+	case "int":
+		return KindInt
+	case "bool":
+		return KindBool
+	default:
+		return KindNull
+	}
+}
+
+func (c *compiler) compileFunction(function *parser.Function) {
+	c.code = []byte{}
+	c.locals = map[string]int{}
+	c.types = map[string]string{}
+
+	for _, prop := range function.Prototype.Props {
+		c.declareLocal(prop.Name, prop.Type)
+	}
+	c.declareLocals(function.Expressions)
+
+	c.block(function.Expressions)
+	// Falling off the end returns nothing
+	c.emit(OpNull)
+	c.emit(OpReturn)
+
+	compiled := c.program.Functions[c.functions[function.Prototype.Name]]
+	compiled.Params = len(function.Prototype.Props)
+	compiled.Locals = len(c.locals)
+	compiled.Code = c.code
+}
+
+func (c *compiler) declareLocal(name string, valueType string) {
+	if _, ok := c.locals[name]; ok {
+		return
+	}
+	c.locals[name] = len(c.locals)
+	c.types[name] = valueType
+}
+
+// declareLocals gives every variable declared in a function a slot
+func (c *compiler) declareLocals(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.VariableDeclarationExpression:
+			c.declareLocal(exp.Name, exp.Type)
+		case *parser.IfExpression:
+			c.declareLocals(exp.Then)
+			c.declareLocals(exp.Else)
+		}
+	}
+}
+
+func (c *compiler) emit(op Opcode, args ...int) {
+	c.code = append(c.code, byte(op))
+	for i, width := range operandWidths[op] {
+		arg := args[i]
+		switch width {
+		case 1:
+			if arg > 0xff {
+				msg := fmt.Sprintf("%s operand out of range: %d", op, arg)
+				panic(msg)
+			}
+			c.code = append(c.code, byte(arg))
+		case 2:
+			if arg > 0xffff {
+				msg := fmt.Sprintf("%s operand out of range: %d", op, arg)
+				panic(msg)
+			}
+			c.code = append(c.code, byte(arg>>8), byte(arg))
+		}
+	}
+}
+
+// emitJump emits a jump with a placeholder address, returning
+// the offset to patch
+func (c *compiler) emitJump(op Opcode) int {
+	c.emit(op, 0)
+	return len(c.code) - 2
+}
+
+// patchJump points a jump at the end of the code so far
+func (c *compiler) patchJump(offset int) {
+	address := len(c.code)
+	if address > 0xffff {
+		panic("Function too large")
+	}
+	c.code[offset] = byte(address >> 8)
+	c.code[offset+1] = byte(address)
+}
+
+func (c *compiler) block(expressions []parser.Expression) {
+	for _, expression := range expressions {
+		switch exp := expression.(type) {
+		case *parser.ReturnExpression:
+			c.expression(exp.Expression)
+			c.emit(OpReturn)
+		case *parser.IfExpression:
+			c.expression(exp.Condition)
+			elseJump := c.emitJump(OpJumpIfFalse)
+			c.block(exp.Then)
+			endJump := c.emitJump(OpJump)
+			c.patchJump(elseJump)
+			c.block(exp.Else)
+			c.patchJump(endJump)
+		default:
+			c.expression(expression)
+			c.emit(OpPop)
+		}
+	}
+}
+
+func (c *compiler) intConstant(value int32) int {
+	for i, existing := range c.program.Ints {
+		if existing == value {
+			return i
+		}
+	}
+	c.program.Ints = append(c.program.Ints, value)
+	return len(c.program.Ints) - 1
+}
+
+func (c *compiler) stringConstant(value string) int {
+	for i, existing := range c.program.Strings {
+		if existing == value {
+			return i
+		}
+	}
+	c.program.Strings = append(c.program.Strings, value)
+	return len(c.program.Strings) - 1
+}
+
+func (c *compiler) local(name string) int {
+	slot, ok := c.locals[name]
+	if !ok {
+		msg := fmt.Sprintf("Undeclared variable: %s", name)
+		panic(msg)
+	}
+	return slot
+}
+
+// variableType is the declared type of a local or, when there's
+// no local with the name, a global
+func (c *compiler) variableType(name string) (string, bool) {
+	if valueType, ok := c.types[name]; ok {
+		return valueType, true
+	}
+	valueType, ok := c.globalTypes[name]
+	return valueType, ok
+}
+
+// load pushes a local or global
+func (c *compiler) load(name string) {
+	if index, ok := c.globals[name]; ok && !c.isLocal(name) {
+		c.emit(OpLoadGlobal, index)
+		return
+	}
+	c.emit(OpLoad, c.local(name))
+}
+
+// store sets a local or global to the top of the stack
+func (c *compiler) store(name string) {
+	if index, ok := c.globals[name]; ok && !c.isLocal(name) {
+		c.emit(OpStoreGlobal, index)
+		return
+	}
+	c.emit(OpStore, c.local(name))
+}
+
+func (c *compiler) isLocal(name string) bool {
+	_, ok := c.locals[name]
+	return ok
+}
+
+// expression compiles code that pushes exactly one value
+func (c *compiler) expression(expression parser.Expression) {
+	switch exp := expression.(type) {
+	case *parser.IntExpression:
+		c.emit(OpConst, c.intConstant(int32(exp.Value)))
+	case *parser.BoolExpression:
+		if exp.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+	case *parser.StringExpression:
+		c.emit(OpString, c.stringConstant(parser.Unescape(exp.Value)))
+	case *parser.InterpolatedStringExpression:
+		for _, part := range exp.Parts {
+			c.expression(part)
+			c.emit(OpToString)
+		}
+		c.emit(OpConcat, len(exp.Parts))
+	case *parser.BinaryExpression:
+		c.expression(exp.LHS)
+		c.expression(exp.RHS)
+		c.emit(binaryOpcodes[exp.Op])
+	case *parser.ParenExpression:
+		c.expression(exp.Expression)
+	case *parser.CallExpression:
+		c.call(exp)
+	case *parser.VariableExpression:
+		c.load(exp.Name)
+	case *parser.VariableDeclarationExpression:
+		c.expression(exp.Expression)
+		c.emit(OpStore, c.local(exp.Name))
+	case *parser.AssignmentExpression:
+		c.assign(exp)
+	case *parser.AccessorExpression:
+		c.accessor(exp)
+	default:
+		msg := fmt.Sprintf("Unhandled expression type: %v", expression.ExpressionType())
+		panic(msg)
+	}
+}
+
+var binaryOpcodes = map[parser.BinaryOperator]Opcode{
+	parser.BinaryOperatorPlus:               OpAdd,
+	parser.BinaryOperatorMinus:              OpSub,
+	parser.BinaryOperatorMultiplication:     OpMul,
+	parser.BinaryOperatorDivision:           OpDiv,
+	parser.BinaryOperatorModulo:             OpMod,
+	parser.BinaryOperatorEqual:              OpEqual,
+	parser.BinaryOperatorNotEqual:           OpNotEqual,
+	parser.BinaryOperatorLessThan:           OpLess,
+	parser.BinaryOperatorGreaterThan:        OpGreater,
+	parser.BinaryOperatorLessThanOrEqual:    OpLessEqual,
+	parser.BinaryOperatorGreaterThanOrEqual: OpGreaterEqual,
+}
+
+func (c *compiler) call(exp *parser.CallExpression) {
+	if exp.Callee == "println" {
+		for _, param := range exp.Params {
+			c.expression(param)
+			c.emit(OpToString)
+		}
+		c.emit(OpPrint, len(exp.Params))
+		return
+	}
+	if exp.Callee == "StringArray" {
+		c.emit(OpNative, nativeIndex("StringArray.make"), 0)
+		return
+	}
+	if index, ok := c.structs[exp.Callee]; ok {
+		c.emit(OpNew, index)
+		return
+	}
+
+	index, ok := c.functions[exp.Callee]
+	if !ok {
+		msg := fmt.Sprintf("Calling undeclared function: %s", exp.Callee)
+		panic(msg)
+	}
+	for _, param := range exp.Params {
+		c.expression(param)
+	}
+	c.emit(c.callOpcode(exp), index, len(exp.Params))
+}
+
+// callOpcode is the opcode calling a function, the RETURN or POP
+// compiled after a tail call is never reached
+func (c *compiler) callOpcode(exp *parser.CallExpression) Opcode {
+	if c.tailCalls[exp] {
+		return OpTailCall
+	}
+	return OpCall
+}
+
+func (c *compiler) accessor(exp *parser.AccessorExpression) {
+	targetType, ok := c.variableType(exp.Target)
+	if !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", exp.Target)
+		panic(msg)
+	}
+	targetType = c.fields(exp.Target, targetType, exp.Fields())
+
+	switch inner := exp.Member().(type) {
+	case *parser.CallExpression:
+		for _, param := range inner.Params {
+			c.expression(param)
+		}
+		argc := len(inner.Params) + 1
+		if _, ok := c.structs[targetType]; ok {
+			name := fmt.Sprintf("%s__%s", targetType, inner.Callee)
+			index, ok := c.functions[name]
+			if !ok {
+				msg := fmt.Sprintf("Unknown method: %s.%s", targetType, inner.Callee)
+				panic(msg)
+			}
+			c.emit(c.callOpcode(inner), index, argc)
+			return
+		}
+		name := fmt.Sprintf("%s.%s", runtimeTypeName(targetType), inner.Callee)
+		c.emit(OpNative, nativeIndex(name), argc)
+	case *parser.VariableExpression:
+		c.emit(OpGetField, c.field(targetType, inner.Name))
+	default:
+		panic("Invalid accessor expression")
+	}
+}
+
+// assign stores to a variable or the last of a chain of fields. The
+// structs holding the fields are read first, then the value, which
+// reads a compound assignment's target again.
+func (c *compiler) assign(exp *parser.AssignmentExpression) {
+	target := exp.Target
+	if len(target.Fields) == 0 {
+		c.expression(exp.Value())
+		c.store(target.Name)
+		return
+	}
+
+	targetType, ok := c.variableType(target.Name)
+	if !ok {
+		msg := fmt.Sprintf("Calling undeclared variable: %s", target.Name)
+		panic(msg)
+	}
+	last := len(target.Fields) - 1
+	targetType = c.fields(target.Name, targetType, target.Fields[:last])
+	c.expression(exp.Value())
+	c.emit(OpSetField, c.field(targetType, target.Fields[last]))
+}
+
+// fields pushes a variable, then replaces it with each of a chain of
+// fields in turn, returning the type of the last
+func (c *compiler) fields(name string, valueType string, fields []string) string {
+	c.load(name)
+	for _, field := range fields {
+		c.emit(OpGetField, c.field(valueType, field))
+		valueType = c.propTypes[valueType][field]
+	}
+	return valueType
+}
+
+func (c *compiler) field(structName string, prop string) int {
+	index, ok := c.structs[structName]
+	if !ok {
+		msg := fmt.Sprintf("Type has no props: %s", structName)
+		panic(msg)
+	}
+	for i, field := range c.program.Structs[index].Fields {
+		if field == prop {
+			return i
+		}
+	}
+	msg := fmt.Sprintf("Unknown prop: %s.%s", structName, prop)
+	panic(msg)
+}
+
+// runtimeTypeName names the types with native methods
+func runtimeTypeName(valueType string) string {
+	switch valueType {
+	case "int":
+		return "Int"
+	case "bool":
+		return "Bool"
+	default:
+		return strings.Trim(valueType, "*")
+	}
+}
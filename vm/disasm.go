@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Disassemble lists a program's structs, its globals and the instructions of
+// each function, annotated with the constants and names their
+// operands refer to
+func Disassemble(program *Program) string {
+	code := ""
+	for _, str := range program.Structs {
+		code += fmt.Sprintf("struct %s { %s }\n", str.Name, strings.Join(str.Fields, ", "))
+	}
+	if len(program.Structs) > 0 {
+		code += "\n"
+	}
+	for _, global := range program.Globals {
+		code += fmt.Sprintf("global %s\n", global.Name)
+	}
+	if len(program.Globals) > 0 {
+		code += "\n"
+	}
+
+	for i, function := range program.Functions {
+		if i > 0 {
+			code += "\n"
+		}
+		code += fmt.Sprintf(
+			"fn %s (params %d, locals %d)\n",
+			function.Name, function.Params, function.Locals)
+		for offset := 0; offset < len(function.Code); offset += instructionSize(function.Code, offset) {
+			code += disassembleInstruction(program, function.Code, offset)
+		}
+	}
+	return code
+}
+
+func disassembleInstruction(program *Program, code []byte, offset int) string {
+	op := Opcode(code[offset])
+	args := operands(code, offset)
+
+	line := fmt.Sprintf("  %04d %-14s", offset, op)
+	for _, arg := range args {
+		line += fmt.Sprintf(" %d", arg)
+	}
+
+	comment := ""
+	switch op {
+	case OpConst:
+		comment = fmt.Sprintf("%d", program.Ints[args[0]])
+	case OpString:
+		comment = strconv.Quote(program.Strings[args[0]])
+	case OpNew:
+		comment = program.Structs[args[0]].Name
+	case OpCall, OpTailCall:
+		comment = program.Functions[args[0]].Name
+	case OpLoadGlobal, OpStoreGlobal:
+		comment = program.Globals[args[0]].Name
+	case OpNative:
+		comment = natives[args[0]].name
+	}
+	if comment != "" {
+		line = fmt.Sprintf("%-30s ; %s", line, comment)
+	}
+	return strings.TrimRight(line, " ") + "\n"
+}
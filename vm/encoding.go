@@ -0,0 +1,249 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A .bc file is the magic "BC", a format version byte, then the
+// Program's sections in order: Ints, Strings, Structs, Globals
+// and Functions. Each section is a uvarint count followed by its
+// entries, numbers are varints and strings and code are a
+// uvarint length followed by their bytes.
+
+// bcMagic starts every .bc file
+const bcMagic = "BC"
+
+// bcVersion changes whenever the format or opcodes do
+const bcVersion = 2
+
+// Encode serialises a program to the .bc format
+func Encode(program *Program) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(bcMagic)
+	buf.WriteByte(bcVersion)
+
+	writeUvarint(&buf, len(program.Ints))
+	for _, value := range program.Ints {
+		writeVarint(&buf, int(value))
+	}
+
+	writeUvarint(&buf, len(program.Strings))
+	for _, value := range program.Strings {
+		writeBytes(&buf, []byte(value))
+	}
+
+	writeUvarint(&buf, len(program.Structs))
+	for _, str := range program.Structs {
+		writeBytes(&buf, []byte(str.Name))
+		writeUvarint(&buf, len(str.Fields))
+		for i, field := range str.Fields {
+			writeBytes(&buf, []byte(field))
+			buf.WriteByte(byte(str.Zeros[i]))
+		}
+		writeVarint(&buf, str.ToString)
+	}
+
+	writeUvarint(&buf, len(program.Globals))
+	for _, global := range program.Globals {
+		writeBytes(&buf, []byte(global.Name))
+		buf.WriteByte(byte(global.Zero))
+	}
+
+	writeUvarint(&buf, len(program.Functions))
+	for _, function := range program.Functions {
+		writeBytes(&buf, []byte(function.Name))
+		writeUvarint(&buf, function.Params)
+		writeUvarint(&buf, function.Locals)
+		writeBytes(&buf, function.Code)
+	}
+
+	return buf.Bytes()
+}
+
+func writeUvarint(buf *bytes.Buffer, value int) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(value))])
+}
+
+func writeVarint(buf *bytes.Buffer, value int) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutVarint(scratch[:], int64(value))])
+}
+
+func writeBytes(buf *bytes.Buffer, value []byte) {
+	writeUvarint(buf, len(value))
+	buf.Write(value)
+}
+
+// decoder reads a .bc file, keeping the first error
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+// Decode reads a program serialised by Encode
+func Decode(data []byte) (*Program, error) {
+	if len(data) < 3 || string(data[:2]) != bcMagic {
+		return nil, errors.New("Not a .bc file")
+	}
+	if data[2] != bcVersion {
+		return nil, fmt.Errorf("Unsupported .bc version: %d", data[2])
+	}
+
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(data[3:]))}
+	program := &Program{}
+
+	for i, count := 0, d.count(); i < count; i++ {
+		program.Ints = append(program.Ints, int32(d.varint()))
+	}
+
+	for i, count := 0, d.count(); i < count; i++ {
+		program.Strings = append(program.Strings, string(d.bytes()))
+	}
+
+	for i, count := 0, d.count(); i < count; i++ {
+		str := &Struct{Name: string(d.bytes())}
+		for j, fields := 0, d.count(); j < fields; j++ {
+			str.Fields = append(str.Fields, string(d.bytes()))
+			str.Zeros = append(str.Zeros, Kind(d.byte()))
+		}
+		str.ToString = d.varint()
+		program.Structs = append(program.Structs, str)
+	}
+
+	for i, count := 0, d.count(); i < count; i++ {
+		global := &Global{Name: string(d.bytes())}
+		global.Zero = Kind(d.byte())
+		program.Globals = append(program.Globals, global)
+	}
+
+	for i, count := 0, d.count(); i < count; i++ {
+		function := &Function{Name: string(d.bytes())}
+		function.Params = d.count()
+		function.Locals = d.count()
+		function.Code = d.bytes()
+		program.Functions = append(program.Functions, function)
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("Invalid .bc file: %s", d.err)
+	}
+	if err := Verify(program); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadUvarint(d.r)
+	d.err = err
+	return value
+}
+
+// count reads a uvarint that sizes something in memory
+func (d *decoder) count() int {
+	value := d.uvarint()
+	if value > 1<<24 {
+		d.err = fmt.Errorf("count too large: %d", value)
+		return 0
+	}
+	return int(value)
+}
+
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(d.r)
+	d.err = err
+	return int(value)
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	value, err := d.r.ReadByte()
+	d.err = err
+	return value
+}
+
+func (d *decoder) bytes() []byte {
+	length := d.count()
+	if d.err != nil {
+		return nil
+	}
+	value := make([]byte, length)
+	_, err := io.ReadFull(d.r, value)
+	d.err = err
+	return value
+}
+
+// Verify checks every operand of a program refers to something
+// that exists, so the VM can trust decoded programs
+func Verify(program *Program) error {
+	for _, str := range program.Structs {
+		if str.ToString < -1 || str.ToString >= len(program.Functions) {
+			return fmt.Errorf("%s: invalid toString function: %d", str.Name, str.ToString)
+		}
+	}
+
+	for _, function := range program.Functions {
+		code := function.Code
+		if function.Params > function.Locals {
+			return fmt.Errorf("%s: more params than locals", function.Name)
+		}
+		if len(code) == 0 || Opcode(code[len(code)-1]) != OpReturn {
+			return fmt.Errorf("%s: doesn't end with RETURN", function.Name)
+		}
+
+		for offset := 0; offset < len(code); {
+			op := Opcode(code[offset])
+			if _, ok := opcodeNames[op]; !ok {
+				return fmt.Errorf("%s: invalid opcode at %d: %d", function.Name, offset, code[offset])
+			}
+			size := instructionSize(code, offset)
+			if offset+size > len(code) {
+				return fmt.Errorf("%s: truncated %s at %d", function.Name, op, offset)
+			}
+			if err := verifyOperands(program, function, op, operands(code, offset)); err != nil {
+				return fmt.Errorf("%s: %s at %d: %s", function.Name, op, offset, err)
+			}
+			offset += size
+		}
+	}
+	return nil
+}
+
+func verifyOperands(program *Program, function *Function, op Opcode, args []int) error {
+	limits := map[Opcode]int{
+		OpConst:       len(program.Ints),
+		OpString:      len(program.Strings),
+		OpLoad:        function.Locals,
+		OpStore:       function.Locals,
+		OpLoadGlobal:  len(program.Globals),
+		OpStoreGlobal: len(program.Globals),
+		OpNew:         len(program.Structs),
+		OpJump:        len(function.Code),
+		OpJumpIfFalse: len(function.Code),
+		OpCall:        len(program.Functions),
+		OpTailCall:    len(program.Functions),
+		OpNative:      len(natives),
+	}
+	limit, ok := limits[op]
+	if !ok {
+		return nil
+	}
+	if args[0] >= limit {
+		return fmt.Errorf("operand out of range: %d", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package vm
+
+import "fmt"
+
+// Kind is the type of a Value
+type Kind byte
+
+// KindNull is an unassigned String, StringArray or struct ...
+const (
+	KindNull Kind = iota
+	KindInt
+	KindBool
+	KindRef
+)
+
+// Value is a slot on the VM's stack or in an object. Ints and
+// Bools (0 or 1) are held in N, references hold the index of
+// their object in the heap.
+type Value struct {
+	Kind Kind
+	N    int32
+}
+
+// Null ...
+var Null = Value{Kind: KindNull}
+
+// Int ...
+func Int(n int32) Value {
+	return Value{Kind: KindInt, N: n}
+}
+
+// Bool ...
+func Bool(b bool) Value {
+	if b {
+		return Value{Kind: KindBool, N: 1}
+	}
+	return Value{Kind: KindBool, N: 0}
+}
+
+type objectKind byte
+
+const (
+	objectString objectKind = iota
+	objectStringArray
+	objectStruct
+)
+
+// object is an allocation in the heap, Strings keep their
+// characters in str, StringArrays and structs their items or
+// fields in values
+type object struct {
+	kind   objectKind
+	str    string
+	values []Value
+	// structIndex is the object's type in Program.Structs
+	structIndex int
+	live        bool
+	marked      bool
+}
+
+// heap is a mark and sweep collected object store. Collection
+// only happens at safe points between instructions, when every
+// live value is on the stack.
+type heap struct {
+	objects []object
+	free    []int32
+	// allocated counts allocations since the last collection
+	allocated int
+	// threshold grows with the live objects from initial,
+	// initial 0 collects at every safe point
+	initial   int
+	threshold int
+	live      int
+	// Stats
+	allocations int
+	collections int
+	freed       int
+}
+
+// initialThreshold is the allocations between collections while
+// the heap is small
+const initialThreshold = 1024
+
+func newHeap(threshold int) *heap {
+	return &heap{initial: threshold, threshold: threshold}
+}
+
+func (h *heap) alloc(o object) Value {
+	h.allocated++
+	h.allocations++
+	h.live++
+	o.live = true
+
+	if len(h.free) > 0 {
+		index := h.free[len(h.free)-1]
+		h.free = h.free[:len(h.free)-1]
+		h.objects[index] = o
+		return Value{Kind: KindRef, N: index}
+	}
+	h.objects = append(h.objects, o)
+	return Value{Kind: KindRef, N: int32(len(h.objects) - 1)}
+}
+
+func (h *heap) get(value Value) *object {
+	if value.Kind != KindRef {
+		panic("Null reference")
+	}
+	return &h.objects[value.N]
+}
+
+func (h *heap) shouldCollect() bool {
+	return h.allocated > 0 && h.allocated >= h.threshold
+}
+
+// collect frees every object not reachable from roots
+func (h *heap) collect(roots ...[]Value) {
+	h.collections++
+	for _, values := range roots {
+		for _, root := range values {
+			h.mark(root)
+		}
+	}
+
+	for i := range h.objects {
+		o := &h.objects[i]
+		if !o.live {
+			continue
+		}
+		if o.marked {
+			o.marked = false
+			continue
+		}
+		*o = object{}
+		h.free = append(h.free, int32(i))
+		h.live--
+		h.freed++
+	}
+
+	h.allocated = 0
+	h.threshold = h.initial
+	if h.initial > 0 && h.live > h.threshold {
+		h.threshold = h.live
+	}
+}
+
+func (h *heap) mark(root Value) {
+	// An explicit worklist keeps long lists from using the Go stack
+	worklist := []Value{root}
+	for len(worklist) > 0 {
+		value := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if value.Kind != KindRef {
+			continue
+		}
+		o := &h.objects[value.N]
+		if o.marked {
+			continue
+		}
+		o.marked = true
+		worklist = append(worklist, o.values...)
+	}
+}
+
+func (h *heap) stats() string {
+	return fmt.Sprintf(
+		"heap: %d allocations, %d collections, %d freed, %d live",
+		h.allocations, h.collections, h.freed, h.live)
+}
@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alexmarchant/compiler/interp"
+)
+
+// native is a runtime method implemented in Go, args include
+// self. Natives can allocate freely, their args stay on the
+// stack until they return and collection waits for the next
+// instruction.
+type native struct {
+	name string
+	argc int
+	call func(vm *VM, args []Value) Value
+}
+
+// natives are the String, StringArray, Int and Bool methods of
+// runtime/string.c, sharing the interpreter's helpers. OpNative
+// refers to them by index, so new ones go on the end to keep .bc
+// files working.
+var natives = []native{
+	{"String.concat", 2, func(vm *VM, args []Value) Value {
+		return vm.newString(vm.str(args[0]) + vm.str(args[1]))
+	}},
+	{"String.equals", 2, func(vm *VM, args []Value) Value {
+		return Bool(vm.str(args[0]) == vm.str(args[1]))
+	}},
+	{"String.compare", 2, func(vm *VM, args []Value) Value {
+		return Int(int32(strings.Compare(vm.str(args[0]), vm.str(args[1]))))
+	}},
+	{"String.length", 1, func(vm *VM, args []Value) Value {
+		return Int(int32(len(vm.str(args[0]))))
+	}},
+	{"String.substring", 3, func(vm *VM, args []Value) Value {
+		return vm.newString(interp.Substring(vm.str(args[0]), int(args[1].N), int(args[2].N)))
+	}},
+	{"String.indexOf", 2, func(vm *VM, args []Value) Value {
+		return Int(int32(strings.Index(vm.str(args[0]), vm.str(args[1]))))
+	}},
+	{"String.contains", 2, func(vm *VM, args []Value) Value {
+		return Bool(strings.Contains(vm.str(args[0]), vm.str(args[1])))
+	}},
+	{"String.split", 2, func(vm *VM, args []Value) Value {
+		items := []Value{}
+		for _, part := range interp.Split(vm.str(args[0]), vm.str(args[1])) {
+			items = append(items, vm.newString(part))
+		}
+		return vm.heap.alloc(object{kind: objectStringArray, values: items})
+	}},
+	{"String.join", 2, func(vm *VM, args []Value) Value {
+		parts := []string{}
+		for _, item := range vm.object(args[1], objectStringArray).values {
+			parts = append(parts, vm.str(item))
+		}
+		return vm.newString(strings.Join(parts, vm.str(args[0])))
+	}},
+	{"String.trim", 1, func(vm *VM, args []Value) Value {
+		return vm.newString(interp.Trim(vm.str(args[0])))
+	}},
+	{"String.upper", 1, func(vm *VM, args []Value) Value {
+		return vm.newString(interp.Upper(vm.str(args[0])))
+	}},
+	{"String.lower", 1, func(vm *VM, args []Value) Value {
+		return vm.newString(interp.Lower(vm.str(args[0])))
+	}},
+	{"String.toInt", 1, func(vm *VM, args []Value) Value {
+		return Int(interp.ToInt(vm.str(args[0])))
+	}},
+	{"String.toString", 1, func(vm *VM, args []Value) Value {
+		vm.str(args[0])
+		return args[0]
+	}},
+	{"Int.toString", 1, func(vm *VM, args []Value) Value {
+		return vm.newString(strconv.Itoa(int(args[0].N)))
+	}},
+	{"Bool.toString", 1, func(vm *VM, args []Value) Value {
+		return vm.newString(formatBool(args[0]))
+	}},
+	{"StringArray.make", 0, func(vm *VM, args []Value) Value {
+		return vm.heap.alloc(object{kind: objectStringArray})
+	}},
+	{"StringArray.push", 2, func(vm *VM, args []Value) Value {
+		array := vm.object(args[0], objectStringArray)
+		vm.str(args[1])
+		array.values = append(array.values, args[1])
+		return Null
+	}},
+	{"StringArray.get", 2, func(vm *VM, args []Value) Value {
+		items := vm.object(args[0], objectStringArray).values
+		index := int(args[1].N)
+		if index < 0 || index >= len(items) {
+			fmt.Fprintf(vm.Stdout, "Index out of range: %d\n", index)
+			panic(exit{code: 1})
+		}
+		return items[index]
+	}},
+	{"StringArray.length", 1, func(vm *VM, args []Value) Value {
+		return Int(int32(len(vm.object(args[0], objectStringArray).values)))
+	}},
+}
+
+func nativeIndex(name string) int {
+	for i, native := range natives {
+		if native.name == name {
+			return i
+		}
+	}
+	msg := fmt.Sprintf("Unknown method: %s", name)
+	panic(msg)
+}
+
+func formatBool(value Value) string {
+	if value.N != 0 {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,383 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// VM runs a Program on a stack of Values. Each call's locals
+// are the bottom of its part of the stack, starting with its
+// args, and the values it's working with are pushed above them.
+type VM struct {
+	Program *Program
+	Stdout  io.Writer
+	// GCThreshold is the allocations between collections while
+	// the heap is small, 0 collects as often as possible
+	GCThreshold int
+
+	heap    *heap
+	globals []Value
+	stack   []Value
+	frames  []callFrame
+}
+
+type callFrame struct {
+	function *Function
+	ip       int
+	base     int
+}
+
+// exit unwinds the VM when the program exits early
+type exit struct {
+	code int
+}
+
+// maxFrames stops runaway recursion before it uses all memory
+const maxFrames = 1 << 20
+
+// New ...
+func New(program *Program) *VM {
+	return &VM{
+		Program:     program,
+		Stdout:      os.Stdout,
+		GCThreshold: initialThreshold,
+	}
+}
+
+// Run initialises the globals, calls main and returns the
+// program's exit code. Errors
+// in the program panic, like the rest of the compiler.
+func (vm *VM) Run() (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(exit)
+			if !ok {
+				panic(r)
+			}
+			code = e.code
+		}
+	}()
+
+	main := vm.Program.FunctionIndex("main")
+	if main == -1 {
+		panic("Calling undeclared function: main")
+	}
+
+	vm.heap = newHeap(vm.GCThreshold)
+	vm.globals = []Value{}
+	for _, global := range vm.Program.Globals {
+		vm.globals = append(vm.globals, Value{Kind: global.Zero})
+	}
+	vm.stack = []Value{}
+	vm.frames = []callFrame{}
+	if init := vm.Program.FunctionIndex(GlobalsInit); init != -1 {
+		vm.call(init, 0)
+		vm.run()
+	}
+	vm.call(main, 0)
+	result := vm.run()
+	if result.Kind == KindInt {
+		return int(result.N)
+	}
+	return 0
+}
+
+// Stats describes the heap after Run
+func (vm *VM) Stats() string {
+	return vm.heap.stats()
+}
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) peek() Value {
+	return vm.stack[len(vm.stack)-1]
+}
+
+// call starts a function whose args are on top of the stack
+func (vm *VM) call(index int, argc int) {
+	function := vm.Program.Functions[index]
+	if argc != function.Params {
+		msg := fmt.Sprintf("%s takes %d args, called with %d", function.Name, function.Params, argc)
+		panic(msg)
+	}
+	if len(vm.frames) == maxFrames {
+		panic("Stack overflow")
+	}
+
+	base := len(vm.stack) - argc
+	for i := function.Params; i < function.Locals; i++ {
+		vm.push(Null)
+	}
+	vm.frames = append(vm.frames, callFrame{function: function, base: base})
+}
+
+func (vm *VM) newString(value string) Value {
+	return vm.heap.alloc(object{kind: objectString, str: value})
+}
+
+// object returns the object a value refers to, which the next
+// allocation may move
+func (vm *VM) object(value Value, kind objectKind) *object {
+	if value.Kind != KindRef {
+		panic("Null reference")
+	}
+	o := vm.heap.get(value)
+	if o.kind != kind {
+		panic("Invalid object type")
+	}
+	return o
+}
+
+func (vm *VM) str(value Value) string {
+	if value.Kind == KindNull {
+		panic("Null String")
+	}
+	return vm.object(value, objectString).str
+}
+
+// run executes instructions until the first frame returns
+func (vm *VM) run() Value {
+	for {
+		// Between instructions every live value is on the stack
+		// or in a global
+		if vm.heap.shouldCollect() {
+			vm.heap.collect(vm.globals, vm.stack)
+		}
+
+		frame := &vm.frames[len(vm.frames)-1]
+		op := Opcode(frame.read8())
+
+		switch op {
+		case OpConst:
+			vm.push(Int(vm.Program.Ints[frame.read16()]))
+		case OpTrue:
+			vm.push(Bool(true))
+		case OpFalse:
+			vm.push(Bool(false))
+		case OpNull:
+			vm.push(Null)
+		case OpString:
+			vm.push(vm.newString(vm.Program.Strings[frame.read16()]))
+		case OpPop:
+			vm.pop()
+		case OpLoad:
+			vm.push(vm.stack[frame.base+frame.read16()])
+		case OpStore:
+			vm.stack[frame.base+frame.read16()] = vm.peek()
+		case OpLoadGlobal:
+			vm.push(vm.globals[frame.read16()])
+		case OpStoreGlobal:
+			vm.globals[frame.read16()] = vm.peek()
+		case OpGetField:
+			field := frame.read8()
+			o := vm.object(vm.pop(), objectStruct)
+			vm.push(o.values[field])
+		case OpSetField:
+			field := frame.read8()
+			value := vm.pop()
+			o := vm.object(vm.pop(), objectStruct)
+			o.values[field] = value
+			vm.push(value)
+		case OpNew:
+			vm.push(vm.newObject(frame.read16()))
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod:
+			rhs := vm.pop()
+			lhs := vm.pop()
+			vm.push(vm.arithmetic(op, lhs, rhs))
+		case OpEqual, OpNotEqual, OpLess, OpGreater, OpLessEqual, OpGreaterEqual:
+			rhs := vm.pop()
+			lhs := vm.pop()
+			vm.push(Bool(compare(op, vm.compare(op, lhs, rhs))))
+		case OpJump:
+			frame.ip = frame.read16()
+		case OpJumpIfFalse:
+			address := frame.read16()
+			if vm.pop().N == 0 {
+				frame.ip = address
+			}
+		case OpCall:
+			index := frame.read16()
+			vm.call(index, frame.read8())
+		case OpTailCall:
+			// The args replace the frame's locals, then the frame
+			// is replaced by the callee's
+			index := frame.read16()
+			argc := frame.read8()
+			copy(vm.stack[frame.base:], vm.stack[len(vm.stack)-argc:])
+			vm.stack = vm.stack[:frame.base+argc]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.call(index, argc)
+		case OpNative:
+			native := natives[frame.read8()]
+			argc := frame.read8()
+			if argc != native.argc {
+				msg := fmt.Sprintf("%s takes %d args, called with %d", native.name, native.argc, argc)
+				panic(msg)
+			}
+			result := native.call(vm, vm.stack[len(vm.stack)-argc:])
+			vm.stack = vm.stack[:len(vm.stack)-argc]
+			vm.push(result)
+		case OpReturn:
+			result := vm.pop()
+			vm.stack = vm.stack[:frame.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return result
+			}
+			vm.push(result)
+		case OpToString:
+			vm.toString()
+		case OpConcat:
+			parts := vm.strings(frame.read8())
+			vm.push(vm.newString(strings.Join(parts, "")))
+		case OpPrint:
+			parts := vm.strings(frame.read8())
+			fmt.Fprintln(vm.Stdout, strings.Join(parts, " "))
+			vm.push(Null)
+		default:
+			msg := fmt.Sprintf("Invalid opcode: %s", op)
+			panic(msg)
+		}
+	}
+}
+
+func (f *callFrame) read8() int {
+	value := f.function.Code[f.ip]
+	f.ip++
+	return int(value)
+}
+
+func (f *callFrame) read16() int {
+	code := f.function.Code
+	value := int(code[f.ip])<<8 | int(code[f.ip+1])
+	f.ip += 2
+	return value
+}
+
+func (vm *VM) newObject(index int) Value {
+	str := vm.Program.Structs[index]
+	fields := make([]Value, len(str.Zeros))
+	for i, kind := range str.Zeros {
+		fields[i] = Value{Kind: kind}
+	}
+	return vm.heap.alloc(object{kind: objectStruct, structIndex: index, values: fields})
+}
+
+// strings pops count Strings
+func (vm *VM) strings(count int) []string {
+	parts := []string{}
+	for _, value := range vm.stack[len(vm.stack)-count:] {
+		parts = append(parts, vm.str(value))
+	}
+	vm.stack = vm.stack[:len(vm.stack)-count]
+	return parts
+}
+
+// toString converts the top of the stack to a String, structs
+// are converted by calling their toString method, which leaves
+// its result in the same place
+func (vm *VM) toString() {
+	value := vm.peek()
+	switch value.Kind {
+	case KindInt:
+		vm.stack[len(vm.stack)-1] = vm.newString(strconv.Itoa(int(value.N)))
+	case KindBool:
+		vm.stack[len(vm.stack)-1] = vm.newString(formatBool(value))
+	case KindNull:
+		panic("Null reference")
+	case KindRef:
+		o := vm.heap.get(value)
+		switch o.kind {
+		case objectString:
+		case objectStruct:
+			str := vm.Program.Structs[o.structIndex]
+			if str.ToString == -1 {
+				msg := fmt.Sprintf("Unknown method: %s.toString", str.Name)
+				panic(msg)
+			}
+			vm.call(str.ToString, 1)
+		default:
+			panic("Type can't be converted to a String: StringArray")
+		}
+	}
+}
+
+func (vm *VM) arithmetic(op Opcode, lhs Value, rhs Value) Value {
+	if lhs.Kind == KindRef && op == OpAdd {
+		return vm.newString(vm.str(lhs) + vm.str(rhs))
+	}
+	if lhs.Kind != KindInt || rhs.Kind != KindInt {
+		msg := fmt.Sprintf("Invalid operands for %s", op)
+		panic(msg)
+	}
+
+	switch op {
+	case OpAdd:
+		return Int(lhs.N + rhs.N)
+	case OpSub:
+		return Int(lhs.N - rhs.N)
+	case OpMul:
+		return Int(lhs.N * rhs.N)
+	default:
+		if rhs.N == 0 {
+			panic("Division by zero")
+		}
+		if op == OpMod {
+			return Int(lhs.N % rhs.N)
+		}
+		return Int(lhs.N / rhs.N)
+	}
+}
+
+// compare returns -1, 0 or 1 like strcmp. Strings compare by
+// their characters, structs only by identity.
+func (vm *VM) compare(op Opcode, lhs Value, rhs Value) int {
+	switch {
+	case lhs.Kind == KindRef && vm.heap.get(lhs).kind == objectString:
+		return strings.Compare(vm.str(lhs), vm.str(rhs))
+	case lhs.Kind == KindInt && rhs.Kind == KindInt:
+		switch {
+		case lhs.N < rhs.N:
+			return -1
+		case lhs.N > rhs.N:
+			return 1
+		}
+		return 0
+	case op == OpEqual || op == OpNotEqual:
+		if lhs == rhs {
+			return 0
+		}
+		return 1
+	default:
+		msg := fmt.Sprintf("Invalid operands for %s", op)
+		panic(msg)
+	}
+}
+
+func compare(op Opcode, result int) bool {
+	switch op {
+	case OpEqual:
+		return result == 0
+	case OpNotEqual:
+		return result != 0
+	case OpLess:
+		return result < 0
+	case OpGreater:
+		return result > 0
+	case OpLessEqual:
+		return result <= 0
+	default:
+		return result >= 0
+	}
+}